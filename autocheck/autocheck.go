@@ -3,6 +3,7 @@ package autocheck
 import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/aws/aws-sdk-go/service/rds"
 	"github.com/opsee/basic/schema"
 	opsee_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
@@ -19,25 +20,33 @@ func (t EmptyTarget) Generate() ([]*schema.Check, error) {
 }
 
 func NewTarget(obj interface{}) Target {
-	switch o := obj.(type) {
-	case *elb.LoadBalancerDescription:
-		return LoadBalancer{o}
-	case *ec2.Instance:
-		return EC2CloudWatch{o}
-	case *rds.DBInstance:
-		return RDSCloudWatch{o, nil}
-	default:
-		return EmptyTarget{}
-	}
+	return NewPolicyTarget(obj, nil, nil)
 }
 
 func NewTargetWithAlarms(obj interface{}, alarms interface{}) Target {
-	switch o := obj.(type) {
-	case *rds.DBInstance:
+	switch obj.(type) {
+	case *ec2.Instance, *rds.DBInstance:
 		switch a := alarms.(type) {
 		case []*opsee_cloudwatch.MetricAlarm:
-			return RDSCloudWatch{o, a}
+			return NewPolicyTarget(obj, a, nil)
 		}
 	}
 	return EmptyTarget{}
 }
+
+// NewPolicyTarget is the target for obj following the customer's policy, a
+// nil policy has the defaults. alarms are the ones to take thresholds from.
+func NewPolicyTarget(obj interface{}, alarms []*opsee_cloudwatch.MetricAlarm, policy *Policy) Target {
+	switch o := obj.(type) {
+	case *elb.LoadBalancerDescription:
+		return LoadBalancer{o, policy}
+	case *elbv2.TargetGroup:
+		return TargetGroup{o, policy}
+	case *ec2.Instance:
+		return EC2CloudWatch{o, alarms, policy}
+	case *rds.DBInstance:
+		return RDSCloudWatch{o, alarms, policy}
+	default:
+		return EmptyTarget{}
+	}
+}
@@ -22,7 +22,24 @@ func NewBartnetSink(bartnetEndpoint, hugsEndpoint string, user *schema.User) *ba
 	}
 }
 
-func (s *bartnetSink) Send(check *schema.Check) error {
+func (s *bartnetSink) ListChecks() ([]*schema.Check, error) {
+	return s.bartnetClient.ListChecks(s.user)
+}
+
+// UpdateCheck changes an existing check's thresholds, it keeps the
+// notifications the customer set up for it
+func (s *bartnetSink) UpdateCheck(check *schema.Check) error {
+	_, err := s.bartnetClient.UpdateCheck(s.user, check)
+	return err
+}
+
+// CreateCheck creates the check along with its notifications, checks that
+// don't have any get the customer's default notifications
+func (s *bartnetSink) CreateCheck(check *schema.Check) error {
+	// notifications are hugs' business
+	checkNotifs := check.Notifications
+	check.Notifications = nil
+
 	checkResp, err := s.bartnetClient.CreateCheck(s.user, check)
 	if err != nil {
 		return err
@@ -32,6 +49,18 @@ func (s *bartnetSink) Send(check *schema.Check) error {
 		return fmt.Errorf("error getting check id from bartnet %#v", checkResp)
 	}
 
+	if len(checkNotifs) > 0 {
+		notifs := make([]*hugs.Notification, len(checkNotifs))
+		for i, n := range checkNotifs {
+			notifs[i] = &hugs.Notification{Type: n.Type, Value: n.Value}
+		}
+
+		return s.hugsClient.CreateNotifications(s.user, &hugs.NotificationRequest{
+			CheckId:       checkResp.Id,
+			Notifications: notifs,
+		})
+	}
+
 	if s.defaultNotifs == nil {
 		notifs, err := s.hugsClient.ListNotificationsDefault(s.user)
 		if err != nil || len(notifs) == 0 {
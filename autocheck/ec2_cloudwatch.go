@@ -6,16 +6,32 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/opsee/basic/schema"
+	opsee_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 )
 
+const (
+	maxEC2CPUUtil   = 95.0
+	maxNetworkRatio = 0.85
+
+	// basic monitoring's period, network metrics are bytes over it
+	ec2MetricPeriod = 300
+)
+
 var (
 	errNoInstance = errors.New("no ec2 instance")
-	maxEC2CPUUtil = 0.95
 )
 
 type EC2CloudWatch struct {
 	*ec2.Instance
+	metricAlarms []*opsee_cloudwatch.MetricAlarm
+	policy       *Policy
+}
+
+type ec2Metric struct {
+	name         string
+	relationship string
+	operand      float64
 }
 
 func (ec EC2CloudWatch) Generate() ([]*schema.Check, error) {
@@ -25,43 +41,182 @@ func (ec EC2CloudWatch) Generate() ([]*schema.Check, error) {
 	}
 
 	var (
-		checks = make([]*schema.Check, 0, 3)
-		instID = aws.StringValue(instance.InstanceId)
+		instID         = aws.StringValue(instance.InstanceId)
+		tags           = make(map[string]string)
+		policy         = ec.policy.instances()
+		maxNetwork     = GetInstanceTypeNetwork(aws.StringValue(instance.InstanceType)) * ec2MetricPeriod * policy.MaxNetworkRatio
+		importedAlarms = make([]*opsee_cloudwatch.MetricAlarm, 0)
+		metrics        = []*ec2Metric{
+			{"CPUUtilization", "lessThan", policy.MaxCPUUtil},
+			// 1 when either the instance or system status check failed
+			{"StatusCheckFailed", "lessThan", 1},
+		}
 	)
 
-	// CPU Util check
-	name := fmt.Sprintf("EC2 (%s) CPU Utilization (auto)", instID)
-	clwCheck := &schema.CloudWatchCheck{
-		Metrics: []*schema.CloudWatchMetric{
-			&schema.CloudWatchMetric{
-				Namespace: "AWS/EC2",
-				Name:      "CPUUtilization",
-			},
-		},
+	for _, tag := range instance.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
 	}
+
+	// rules can match instances by their id or their name
+	if !policy.IsEnabled(true) || !ec.policy.includes([]string{instID, tags["Name"]}, tags) {
+		return []*schema.Check{}, nil
+	}
+
+	// we don't know the bandwidth of every instance type
+	if maxNetwork > 0 {
+		metrics = append(metrics,
+			&ec2Metric{"NetworkIn", "lessThan", maxNetwork},
+			&ec2Metric{"NetworkOut", "lessThan", maxNetwork},
+		)
+	}
+
+	// the thresholds of the instance's cloudwatch alarms replace the defaults,
+	// as long as they bound the metric's average on the same side. alarms for
+	// other metrics are checked as well.
+	for _, alarm := range ec.metricAlarms {
+		if aws.StringValue(alarm.Namespace) != "AWS/EC2" || !alarmForInstance(alarm, instID) {
+			continue
+		}
+
+		if aws.StringValue(alarm.Statistic) != "Average" {
+			continue
+		}
+
+		// pass or fail, there's no threshold to take from it
+		if aws.StringValue(alarm.MetricName) == "StatusCheckFailed" {
+			continue
+		}
+
+		imported := true
+		for _, m := range metrics {
+			if m.name != aws.StringValue(alarm.MetricName) {
+				continue
+			}
+
+			if m.relationship == alarmBound(aws.StringValue(alarm.ComparisonOperator)) {
+				m.operand = aws.Float64Value(alarm.Threshold)
+			}
+
+			imported = false
+		}
+
+		if imported {
+			importedAlarms = append(importedAlarms, alarm)
+		}
+	}
+
+	for _, alarm := range importedAlarms {
+		metrics = append(metrics, &ec2Metric{
+			name:         aws.StringValue(alarm.MetricName),
+			relationship: getRelationship(aws.StringValue(alarm.ComparisonOperator)),
+			operand:      aws.Float64Value(alarm.Threshold),
+		})
+	}
+
+	check, err := ec2Check(instID, metrics, policy.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	check.Notifications = ec.policy.notifications()
+	return []*schema.Check{check}, nil
+}
+
+// ec2Check checks all of the instance's metrics at once, like RDSCloudWatch
+// does a db instance's
+func ec2Check(instID string, metrics []*ec2Metric, interval int32) (*schema.Check, error) {
+	var (
+		clwCheck   = &schema.CloudWatchCheck{}
+		assertions = make([]*schema.Assertion, 0, len(metrics))
+	)
+
+	for _, m := range metrics {
+		clwCheck.Metrics = append(clwCheck.Metrics, &schema.CloudWatchMetric{
+			Namespace: "AWS/EC2",
+			Name:      m.name,
+		})
+
+		assertions = append(assertions, &schema.Assertion{
+			Key:          "cloudwatch",
+			Relationship: m.relationship,
+			Operand:      fmt.Sprintf("%.3f", m.operand),
+			Value:        m.name,
+		})
+	}
+
 	checkSpec, err := opsee_types.MarshalAny(clwCheck)
 	if err != nil {
 		return nil, err
 	}
-	check := &schema.Check{
-		Name:     name,
-		Interval: int32(60),
+
+	return &schema.Check{
+		Name:     fmt.Sprintf("EC2 metrics for %s (auto)", instID),
+		Interval: interval,
 		Target: &schema.Target{
 			Name: instID,
 			Type: "cloudwatch",
 			Id:   instID,
 		},
-		CheckSpec: checkSpec,
-		Assertions: []*schema.Assertion{
-			{
-				Key:          "cloudwatch",
-				Relationship: "lessThan",
-				Operand:      fmt.Sprintf("%.3f", maxEC2CPUUtil),
-				Value:        "CPUUtilization",
-			},
-		},
+		CheckSpec:  checkSpec,
+		Assertions: assertions,
+	}, nil
+}
+
+// alarmBound is the relationship a metric has to keep with an alarm's
+// threshold for the alarm not to go off, an alarm on values greater than its
+// threshold bounds the metric from above
+func alarmBound(comparisonOperator string) string {
+	switch comparisonOperator {
+	case "GreaterThanOrEqualToThreshold", "GreaterThanThreshold":
+		return "lessThan"
+	default:
+		return "greaterThan"
+	}
+}
+
+func alarmForInstance(alarm *opsee_cloudwatch.MetricAlarm, instID string) bool {
+	for _, dim := range alarm.Dimensions {
+		if aws.StringValue(dim.Name) == "InstanceId" && aws.StringValue(dim.Value) == instID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetInstanceTypeNetwork is the instance type's network bandwidth in bytes per
+// second, for the burstable types it's their baseline
+func GetInstanceTypeNetwork(instanceType string) float64 {
+	bandwidthMbps := 0.0
+
+	switch instanceType {
+	case "t2.nano":
+		bandwidthMbps = 32
+	case "t2.micro":
+		bandwidthMbps = 64
+	case "t2.small":
+		bandwidthMbps = 128
+	case "t2.medium", "t3.micro", "t3.small":
+		bandwidthMbps = 256
+	case "t2.large", "t3.medium":
+		bandwidthMbps = 512
+	case "t2.xlarge", "t2.2xlarge", "t3.large", "t3.xlarge", "t3.2xlarge":
+		bandwidthMbps = 768
+	case "m3.medium":
+		bandwidthMbps = 300
+	case "m3.large", "m4.large", "c4.large":
+		bandwidthMbps = 450
+	case "m3.xlarge", "m3.2xlarge", "m4.xlarge", "m4.2xlarge", "c4.xlarge", "c4.2xlarge":
+		bandwidthMbps = 1000
+	case "m4.4xlarge", "c4.4xlarge":
+		bandwidthMbps = 2000
+	case "m5.large", "m5.xlarge", "m5.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge":
+		bandwidthMbps = 750
+	case "m5.4xlarge", "c5.4xlarge":
+		bandwidthMbps = 2500
+	case "m4.10xlarge", "m4.16xlarge", "c4.8xlarge", "c5.9xlarge", "m5.12xlarge":
+		bandwidthMbps = 10000
 	}
-	checks = append(checks, check)
 
-	return checks, nil
+	return bandwidthMbps * 1e6 / 8
 }
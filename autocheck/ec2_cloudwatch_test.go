@@ -5,11 +5,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/opsee/basic/schema"
+	opsee_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
+type ec2TestMetric struct {
+	checkName string
+	rel       string
+	op        float64
+}
+
 var ec2Tests = []struct {
 	ec2    *ec2.Instance
 	checks []*schema.Check
@@ -18,7 +25,61 @@ var ec2Tests = []struct {
 		ec2: &ec2.Instance{
 			InstanceId: aws.String("i-e48b6a39"),
 		},
-		checks: writeEC2Checks("i-e48b6a39"),
+		checks: writeEC2Checks("i-e48b6a39", 95.0, 0.0, nil),
+	},
+	{
+		ec2: &ec2.Instance{
+			InstanceId:   aws.String("i-e48b6a39"),
+			InstanceType: aws.String("m4.large"),
+		},
+		checks: writeEC2Checks("i-e48b6a39", 95.0, (450*1e6/8)*300*0.85, nil),
+	},
+}
+
+var ec2AlarmTests = []struct {
+	ec2    *ec2.Instance
+	alarms []*opsee_cloudwatch.MetricAlarm
+	checks []*schema.Check
+}{
+	{
+		ec2: &ec2.Instance{
+			InstanceId:   aws.String("i-7a2b3c4d"),
+			InstanceType: aws.String("m4.large"),
+		},
+		alarms: writeEC2Alarms(),
+		checks: writeEC2Checks("i-7a2b3c4d", 80.0, (450*1e6/8)*300*0.85, &ec2TestMetric{
+			checkName: "DiskReadOps",
+			rel:       "greaterThan",
+			op:        10.0,
+		}),
+	},
+	// alarms for other instances don't apply
+	{
+		ec2: &ec2.Instance{
+			InstanceId: aws.String("i-e48b6a39"),
+		},
+		alarms: writeEC2Alarms(),
+		checks: writeEC2Checks("i-e48b6a39", 95.0, 0.0, nil),
+	},
+	// an alarm on a floor doesn't replace the default's ceiling
+	{
+		ec2: &ec2.Instance{
+			InstanceId: aws.String("i-7a2b3c4d"),
+		},
+		alarms: []*opsee_cloudwatch.MetricAlarm{
+			ec2Alarm("CPUUtilization", "Average", "LessThanThreshold", 10.0),
+		},
+		checks: writeEC2Checks("i-7a2b3c4d", 95.0, 0.0, nil),
+	},
+	// nor does an alarm on anything but the metric's average
+	{
+		ec2: &ec2.Instance{
+			InstanceId: aws.String("i-7a2b3c4d"),
+		},
+		alarms: []*opsee_cloudwatch.MetricAlarm{
+			ec2Alarm("CPUUtilization", "Maximum", "GreaterThanThreshold", 80.0),
+		},
+		checks: writeEC2Checks("i-7a2b3c4d", 95.0, 0.0, nil),
 	},
 }
 
@@ -30,57 +91,104 @@ func TestEC2Generate(t *testing.T) {
 		assert.NoError(err)
 		assert.EqualValues(r.checks, cz)
 	}
+
+	for _, r := range ec2AlarmTests {
+		cz, err := NewTargetWithAlarms(r.ec2, r.alarms).Generate()
+		assert.NoError(err)
+		assert.EqualValues(r.checks, cz)
+	}
 }
 
-func writeEC2Checks(instID string) []*schema.Check {
-	checks := make([]*schema.Check, 0)
-	cwMetrics := []struct {
-		checkName string
-		rel       string
-		op        float64
-		dispName  string
-	}{
-		{
-			checkName: "CPUUtilization",
-			rel:       "lessThan",
-			op:        0.950,
-			dispName:  "CPU Utilization",
+func writeEC2Alarms() []*opsee_cloudwatch.MetricAlarm {
+	return []*opsee_cloudwatch.MetricAlarm{
+		ec2Alarm("CPUUtilization", "Average", "GreaterThanOrEqualToThreshold", 80.0),
+		ec2Alarm("StatusCheckFailed", "Maximum", "GreaterThanThreshold", 0.0),
+		ec2Alarm("DiskReadOps", "Average", "GreaterThanThreshold", 10.0),
+		ec2Alarm("DiskWriteOps", "Sum", "GreaterThanThreshold", 10.0),
+	}
+}
+
+func ec2Alarm(metric, statistic, comparison string, threshold float64) *opsee_cloudwatch.MetricAlarm {
+	return &opsee_cloudwatch.MetricAlarm{
+		AlarmName:          aws.String("i-7a2b3c4d-" + metric),
+		MetricName:         aws.String(metric),
+		StateValue:         aws.String("OK"),
+		Namespace:          aws.String("AWS/EC2"),
+		Statistic:          aws.String(statistic),
+		ComparisonOperator: aws.String(comparison),
+		Threshold:          aws.Float64(threshold),
+		Period:             aws.Int64(300),
+		Dimensions: []*opsee_cloudwatch.Dimension{
+			&opsee_cloudwatch.Dimension{
+				Name:  aws.String("InstanceId"),
+				Value: aws.String("i-7a2b3c4d"),
+			},
 		},
 	}
+}
 
-	for _, m := range cwMetrics {
-		if m.op == 0.0 {
-			continue
-		}
-		ec2Check := &schema.CloudWatchCheck{
-			Metrics: []*schema.CloudWatchMetric{
-				&schema.CloudWatchMetric{
-					Namespace: "AWS/EC2",
-					Name:      m.checkName,
-				},
+func writeEC2Checks(instID string, cpuThresh float64, networkThresh float64, imported *ec2TestMetric) []*schema.Check {
+	var (
+		metrics    []*schema.CloudWatchMetric
+		assertions []*schema.Assertion
+		cwMetrics  = []*ec2TestMetric{
+			{
+				checkName: "CPUUtilization",
+				rel:       "lessThan",
+				op:        cpuThresh,
+			},
+			{
+				checkName: "StatusCheckFailed",
+				rel:       "lessThan",
+				op:        1.0,
 			},
 		}
-		checkSpec, _ := opsee_types.MarshalAny(ec2Check)
-		check := &schema.Check{
-			Name:     fmt.Sprintf("EC2 (%s) %s (auto)", instID, m.dispName),
+	)
+
+	if networkThresh > 0 {
+		cwMetrics = append(cwMetrics,
+			&ec2TestMetric{
+				checkName: "NetworkIn",
+				rel:       "lessThan",
+				op:        networkThresh,
+			},
+			&ec2TestMetric{
+				checkName: "NetworkOut",
+				rel:       "lessThan",
+				op:        networkThresh,
+			},
+		)
+	}
+
+	if imported != nil {
+		cwMetrics = append(cwMetrics, imported)
+	}
+
+	for _, m := range cwMetrics {
+		metrics = append(metrics, &schema.CloudWatchMetric{
+			Namespace: "AWS/EC2",
+			Name:      m.checkName,
+		})
+		assertions = append(assertions, &schema.Assertion{
+			Key:          "cloudwatch",
+			Relationship: m.rel,
+			Operand:      fmt.Sprintf("%.3f", m.op),
+			Value:        m.checkName,
+		})
+	}
+
+	checkSpec, _ := opsee_types.MarshalAny(&schema.CloudWatchCheck{Metrics: metrics})
+	return []*schema.Check{
+		{
+			Name:     fmt.Sprintf("EC2 metrics for %s (auto)", instID),
 			Interval: int32(60),
 			Target: &schema.Target{
 				Name: instID,
 				Type: "cloudwatch",
 				Id:   instID,
 			},
-			CheckSpec: checkSpec,
-			Assertions: []*schema.Assertion{
-				{
-					Key:          "cloudwatch",
-					Relationship: m.rel,
-					Operand:      fmt.Sprintf("%.3f", m.op),
-					Value:        m.checkName,
-				},
-			},
-		}
-		checks = append(checks, check)
+			CheckSpec:  checkSpec,
+			Assertions: assertions,
+		},
 	}
-
-	return checks
 }
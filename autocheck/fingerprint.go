@@ -0,0 +1,82 @@
+package autocheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/opsee/basic/schema"
+	"reflect"
+	"strings"
+)
+
+const autocheckSuffix = " (auto)"
+
+// Fingerprint identifies a generated check across launches by what it checks,
+// its target and check spec, and not by the thresholds it checks against.
+// Checks read back from bartnet may carry their spec in the oneof rather than
+// as an Any, either gets the same fingerprint.
+func Fingerprint(check *schema.Check) (string, error) {
+	if check.Target == nil {
+		return "", fmt.Errorf("check %s has no target", check.Name)
+	}
+
+	var (
+		specType  string
+		specBytes []byte
+	)
+
+	switch {
+	case check.CheckSpec != nil:
+		specType = check.CheckSpec.TypeUrl
+		specBytes = check.CheckSpec.Value
+	case check.Spec != nil:
+		var msg proto.Message
+		switch s := check.Spec.(type) {
+		case *schema.Check_HttpCheck:
+			msg = s.HttpCheck
+		case *schema.Check_CloudwatchCheck:
+			msg = s.CloudwatchCheck
+		default:
+			return "", fmt.Errorf("check %s has an unknown spec", check.Name)
+		}
+
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+
+		specType = reflect.ValueOf(msg).Elem().Type().Name()
+		specBytes = b
+	default:
+		return "", fmt.Errorf("check %s has no spec", check.Name)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", check.Target.Type, check.Target.Id, specType)
+	h.Write(specBytes)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isAutocheck is whether we generated the check, rather than a customer
+func isAutocheck(check *schema.Check) bool {
+	return strings.HasSuffix(check.Name, autocheckSuffix)
+}
+
+// sameThresholds is whether the checks assert the same things, as often
+func sameThresholds(a, b *schema.Check) bool {
+	if a.Interval != b.Interval || len(a.Assertions) != len(b.Assertions) {
+		return false
+	}
+
+	for i, assertion := range a.Assertions {
+		other := b.Assertions[i]
+		if assertion.Key != other.Key || assertion.Value != other.Value ||
+			assertion.Relationship != other.Relationship || assertion.Operand != other.Operand {
+			return false
+		}
+	}
+
+	return true
+}
@@ -22,6 +22,7 @@ var (
 
 type LoadBalancer struct {
 	*elb.LoadBalancerDescription
+	policy *Policy
 }
 
 func (l LoadBalancer) Generate() ([]*schema.Check, error) {
@@ -40,11 +41,16 @@ func (l LoadBalancer) Generate() ([]*schema.Check, error) {
 
 	var (
 		checks        = make([]*schema.Check, 0)
+		policy        = l.policy.loadBalancers()
 		lbName        = aws.StringValue(lbd.LoadBalancerName)
 		target        = aws.StringValue(lbd.HealthCheck.Target)
 		targetMatches = elbTargetRegexp.FindStringSubmatch(target)
 	)
 
+	if !policy.IsEnabled(true) || !l.policy.includes([]string{lbName}, nil) {
+		return checks, nil
+	}
+
 	if len(targetMatches) < 4 {
 		return checks, nil
 	}
@@ -78,7 +84,7 @@ func (l LoadBalancer) Generate() ([]*schema.Check, error) {
 
 		check := &schema.Check{
 			Name:     name,
-			Interval: int32(30),
+			Interval: policy.Interval,
 			Target: &schema.Target{
 				Name: lbName,
 				Type: "elb",
@@ -93,6 +99,7 @@ func (l LoadBalancer) Generate() ([]*schema.Check, error) {
 				},
 			},
 			// Spec <--- TODO: fill this out later when using cats
+			Notifications: l.policy.notifications(),
 		}
 
 		checks = append(checks, check)
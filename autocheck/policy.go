@@ -0,0 +1,236 @@
+package autocheck
+
+import (
+	"errors"
+	"github.com/opsee/basic/schema"
+	"strings"
+)
+
+const (
+	defaultHTTPInterval       = 30
+	defaultCloudWatchInterval = 60
+	minInterval               = 15
+)
+
+var ErrBadPolicy = errors.New("intervals must be at least 15 seconds, thresholds can't be negative and ratios must be at most 1.")
+
+// Policy is a customer's rules for the checks generated for their resources.
+// Zero values keep the defaults.
+type Policy struct {
+	LoadBalancers LoadBalancerPolicy `json:"load_balancers"`
+	TargetGroups  TargetGroupPolicy  `json:"target_groups"`
+	Instances     InstancePolicy     `json:"instances"`
+	DBInstances   DBInstancePolicy   `json:"db_instances"`
+
+	// resources matching any of the exclude rules don't get checks, when there
+	// are include rules resources have to match one of them too
+	Include []*Rule `json:"include"`
+	Exclude []*Rule `json:"exclude"`
+
+	// notifications for new checks, instead of the customer's defaults
+	Notifications []*schema.Notification `json:"notifications"`
+}
+
+// KindPolicy is what every kind of target can be configured with. Targets are
+// enabled unless the policy says otherwise, instances are the exception in
+// that launches only discover them for customers that turned them on.
+type KindPolicy struct {
+	Enabled  *bool `json:"enabled,omitempty"`
+	Interval int32 `json:"interval,omitempty"`
+}
+
+type LoadBalancerPolicy struct {
+	KindPolicy
+}
+
+type TargetGroupPolicy struct {
+	KindPolicy
+}
+
+type InstancePolicy struct {
+	KindPolicy
+	MaxCPUUtil      float64 `json:"max_cpu_util,omitempty"`
+	MaxNetworkRatio float64 `json:"max_network_ratio,omitempty"`
+}
+
+type DBInstancePolicy struct {
+	KindPolicy
+	MaxCPUUtil   float64 `json:"max_cpu_util,omitempty"`
+	MaxConnRatio float64 `json:"max_conn_ratio,omitempty"`
+	MinMemRatio  float64 `json:"min_mem_ratio,omitempty"`
+}
+
+// Rule matches resources by name prefix and tags, a resource has to match
+// both when both are set. Load balancers, target groups and db instances are
+// discovered without their tags, so rules with tags only ever match instances.
+type Rule struct {
+	NamePrefix string            `json:"name_prefix"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// IsEnabled is whether the kind gets checks, def when the policy doesn't say
+func (k KindPolicy) IsEnabled(def bool) bool {
+	if k.Enabled == nil {
+		return def
+	}
+
+	return *k.Enabled
+}
+
+func (k KindPolicy) interval(def int32) int32 {
+	if k.Interval == 0 {
+		return def
+	}
+
+	return k.Interval
+}
+
+// Validate checks that the policy's intervals and thresholds make sense
+func (p *Policy) Validate() error {
+	intervals := []int32{p.LoadBalancers.Interval, p.TargetGroups.Interval, p.Instances.Interval, p.DBInstances.Interval}
+	for _, i := range intervals {
+		if i != 0 && i < minInterval {
+			return ErrBadPolicy
+		}
+	}
+
+	thresholds := []float64{p.Instances.MaxCPUUtil, p.DBInstances.MaxCPUUtil}
+	ratios := []float64{p.Instances.MaxNetworkRatio, p.DBInstances.MaxConnRatio, p.DBInstances.MinMemRatio}
+	for _, t := range append(thresholds, ratios...) {
+		if t < 0 {
+			return ErrBadPolicy
+		}
+	}
+
+	for _, r := range ratios {
+		if r > 1 {
+			return ErrBadPolicy
+		}
+	}
+
+	return nil
+}
+
+func (p *Policy) loadBalancers() LoadBalancerPolicy {
+	var lb LoadBalancerPolicy
+	if p != nil {
+		lb = p.LoadBalancers
+	}
+
+	lb.Interval = lb.interval(defaultHTTPInterval)
+	return lb
+}
+
+func (p *Policy) targetGroups() TargetGroupPolicy {
+	var tg TargetGroupPolicy
+	if p != nil {
+		tg = p.TargetGroups
+	}
+
+	tg.Interval = tg.interval(defaultHTTPInterval)
+	return tg
+}
+
+func (p *Policy) instances() InstancePolicy {
+	var i InstancePolicy
+	if p != nil {
+		i = p.Instances
+	}
+
+	i.Interval = i.interval(defaultCloudWatchInterval)
+	if i.MaxCPUUtil == 0 {
+		i.MaxCPUUtil = maxEC2CPUUtil
+	}
+
+	if i.MaxNetworkRatio == 0 {
+		i.MaxNetworkRatio = maxNetworkRatio
+	}
+
+	return i
+}
+
+func (p *Policy) dbInstances() DBInstancePolicy {
+	var db DBInstancePolicy
+	if p != nil {
+		db = p.DBInstances
+	}
+
+	db.Interval = db.interval(defaultCloudWatchInterval)
+	if db.MaxCPUUtil == 0 {
+		db.MaxCPUUtil = maxCPUUtil
+	}
+
+	if db.MaxConnRatio == 0 {
+		db.MaxConnRatio = maxConnRatio
+	}
+
+	if db.MinMemRatio == 0 {
+		db.MinMemRatio = minMemRatio
+	}
+
+	return db
+}
+
+// includes is whether a resource with any of the names and the tags gets
+// checks
+func (p *Policy) includes(names []string, tags map[string]string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, rule := range p.Exclude {
+		if rule.matches(names, tags) {
+			return false
+		}
+	}
+
+	if len(p.Include) == 0 {
+		return true
+	}
+
+	for _, rule := range p.Include {
+		if rule.matches(names, tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notifications are copies, checks shouldn't share them
+func (p *Policy) notifications() []*schema.Notification {
+	if p == nil || len(p.Notifications) == 0 {
+		return nil
+	}
+
+	notifs := make([]*schema.Notification, len(p.Notifications))
+	for i, n := range p.Notifications {
+		notifs[i] = &schema.Notification{Type: n.Type, Value: n.Value}
+	}
+
+	return notifs
+}
+
+func (r *Rule) matches(names []string, tags map[string]string) bool {
+	if r.NamePrefix != "" {
+		named := false
+		for _, name := range names {
+			if strings.HasPrefix(name, r.NamePrefix) {
+				named = true
+			}
+		}
+
+		if !named {
+			return false
+		}
+	}
+
+	for k, v := range r.Tags {
+		value, ok := tags[k]
+		if !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
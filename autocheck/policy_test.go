@@ -0,0 +1,107 @@
+package autocheck
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var policyRuleTests = []struct {
+	include  []*Rule
+	exclude  []*Rule
+	names    []string
+	tags     map[string]string
+	included bool
+}{
+	{nil, nil, []string{"web"}, nil, true},
+	{nil, []*Rule{{NamePrefix: "dev-"}}, []string{"dev-web"}, nil, false},
+	{nil, []*Rule{{NamePrefix: "dev-"}}, []string{"prod-web"}, nil, true},
+	{[]*Rule{{NamePrefix: "prod-"}}, nil, []string{"dev-web"}, nil, false},
+	{[]*Rule{{NamePrefix: "prod-"}}, nil, []string{"i-1", "prod-web"}, nil, true},
+	{[]*Rule{{Tags: map[string]string{"team": "ops"}}}, nil, []string{"web"}, map[string]string{"team": "ops"}, true},
+	{[]*Rule{{Tags: map[string]string{"team": "ops"}}}, nil, []string{"web"}, map[string]string{"team": "dev"}, false},
+	{[]*Rule{{Tags: map[string]string{"team": "ops"}}}, nil, []string{"web"}, nil, false},
+	// excludes win
+	{[]*Rule{{NamePrefix: "prod-"}}, []*Rule{{NamePrefix: "prod-", Tags: map[string]string{"opsee": "off"}}}, []string{"prod-web"}, map[string]string{"opsee": "off"}, false},
+	{[]*Rule{{NamePrefix: "prod-"}}, []*Rule{{NamePrefix: "prod-", Tags: map[string]string{"opsee": "off"}}}, []string{"prod-web"}, nil, true},
+}
+
+func TestPolicyRules(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, test := range policyRuleTests {
+		policy := &Policy{Include: test.include, Exclude: test.exclude}
+		assert.Equal(test.included, policy.includes(test.names, test.tags), "test %d", i)
+	}
+}
+
+func TestPolicyGenerate(t *testing.T) {
+	assert := assert.New(t)
+
+	disabled := false
+	policy := &Policy{
+		LoadBalancers: LoadBalancerPolicy{KindPolicy{Enabled: &disabled}},
+		Instances: InstancePolicy{
+			KindPolicy: KindPolicy{Interval: 300},
+			MaxCPUUtil: 50,
+		},
+		DBInstances: DBInstancePolicy{
+			MaxConnRatio: 0.5,
+			MinMemRatio:  0.2,
+		},
+		Exclude: []*Rule{{NamePrefix: "invalid"}},
+		Notifications: []*schema.Notification{
+			{Type: "slack_bot", Value: "#ops"},
+		},
+	}
+
+	checks, err := NewPolicyTarget(elbtests[1].elb, nil, policy).Generate()
+	assert.NoError(err)
+	assert.Len(checks, 0)
+
+	instance := &ec2.Instance{
+		InstanceId: aws.String("i-e48b6a39"),
+		Tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("invalid-web")}},
+	}
+	checks, err = NewPolicyTarget(instance, nil, policy).Generate()
+	assert.NoError(err)
+	assert.Len(checks, 0)
+
+	instance.Tags = nil
+	checks, err = NewPolicyTarget(instance, writeEC2Alarms(), policy).Generate()
+	assert.NoError(err)
+	expected := writeEC2Checks("i-e48b6a39", 50.0, 0.0, nil)
+	if assert.Len(checks, len(expected)) {
+		for i, check := range checks {
+			assert.EqualValues(300, check.Interval)
+			assert.Equal(expected[i].Assertions, check.Assertions)
+			assert.Equal(policy.Notifications, check.Notifications)
+		}
+	}
+
+	checks, err = NewPolicyTarget(rdsTests[0].rds, nil, policy).Generate()
+	assert.NoError(err)
+	assert.Len(checks, 0)
+
+	checks, err = NewPolicyTarget(rdsTests[1].rds, nil, policy).Generate()
+	assert.NoError(err)
+	expected = writeChecks("opsee-test-db", "db.m3.xlarge",
+		95.000,
+		((15.0*1e9)/12582880.0)*0.5,
+		(15.0*1e9)*0.2,
+		nil)
+	expected[0].Notifications = policy.notifications()
+	assert.EqualValues(expected, checks)
+}
+
+func TestPolicyValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&Policy{}).Validate())
+	assert.NoError((&Policy{Instances: InstancePolicy{KindPolicy: KindPolicy{Interval: 15}, MaxNetworkRatio: 1}}).Validate())
+	assert.Equal(ErrBadPolicy, (&Policy{LoadBalancers: LoadBalancerPolicy{KindPolicy{Interval: 5}}}).Validate())
+	assert.Equal(ErrBadPolicy, (&Policy{DBInstances: DBInstancePolicy{MaxCPUUtil: -1}}).Validate())
+	assert.Equal(ErrBadPolicy, (&Policy{DBInstances: DBInstancePolicy{MinMemRatio: 1.5}}).Validate())
+}
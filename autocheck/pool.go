@@ -2,18 +2,26 @@ package autocheck
 
 import (
 	"github.com/opsee/basic/schema"
+	opsee_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
 	log "github.com/opsee/logrus"
 )
 
+// Sink is where generated checks end up. Pools look up the checks already
+// there so that draining the same targets again doesn't duplicate them.
 type Sink interface {
-	Send(*schema.Check) error
+	ListChecks() ([]*schema.Check, error)
+	CreateCheck(*schema.Check) error
+	UpdateCheck(*schema.Check) error
 }
 
 type Pool struct {
 	Sink         Sink
 	Logger       *log.Entry
+	Policy       *Policy
 	targets      []Target
-	successCount int
+	createdCount int
+	updatedCount int
+	skippedCount int
 }
 
 func NewPool(sink Sink, logger *log.Entry) *Pool {
@@ -24,14 +32,24 @@ func NewPool(sink Sink, logger *log.Entry) *Pool {
 }
 
 func (p *Pool) AddTarget(obj interface{}) {
-	p.targets = append(p.targets, NewTarget(obj))
+	p.targets = append(p.targets, NewPolicyTarget(obj, nil, p.Policy))
 }
 
 func (p *Pool) AddTargetWithAlarms(obj interface{}, alarms interface{}) {
-	p.targets = append(p.targets, NewTargetWithAlarms(obj, alarms))
+	a, _ := alarms.([]*opsee_cloudwatch.MetricAlarm)
+	p.targets = append(p.targets, NewPolicyTarget(obj, a, p.Policy))
 }
 
+// Drain sends the targets' checks to the sink, creating the ones it doesn't
+// have and updating the ones whose thresholds changed. Checks a customer made
+// themselves are left alone.
 func (p *Pool) Drain() {
+	existing, err := p.existingChecks()
+	if err != nil {
+		p.Logger.WithError(err).Error("couldn't list existing checks, not sending autochecks")
+		return
+	}
+
 	for _, target := range p.targets {
 		checks, err := target.Generate()
 		if err != nil {
@@ -40,17 +58,75 @@ func (p *Pool) Drain() {
 		}
 
 		for _, check := range checks {
-			err = p.Sink.Send(check)
+			fingerprint, err := Fingerprint(check)
 			if err != nil {
-				p.Logger.WithError(err).Error("couldn't send autocheck")
+				p.Logger.WithError(err).Error("couldn't fingerprint autocheck")
+				continue
+			}
+
+			current, ok := existing[fingerprint]
+			switch {
+			case !ok:
+				err = p.Sink.CreateCheck(check)
+				if err != nil {
+					p.Logger.WithError(err).Error("couldn't create autocheck")
+					continue
+				}
+
+				p.createdCount++
+			case !isAutocheck(current) || sameThresholds(current, check):
+				p.skippedCount++
 				continue
+			default:
+				check.Id = current.Id
+				err = p.Sink.UpdateCheck(check)
+				if err != nil {
+					p.Logger.WithError(err).Error("couldn't update autocheck")
+					continue
+				}
+
+				p.updatedCount++
 			}
 
-			p.successCount++
+			// targets can be discovered more than once
+			existing[fingerprint] = check
 		}
 	}
 }
 
+func (p *Pool) existingChecks() (map[string]*schema.Check, error) {
+	checks, err := p.Sink.ListChecks()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]*schema.Check, len(checks))
+	for _, check := range checks {
+		fingerprint, err := Fingerprint(check)
+		if err != nil {
+			continue
+		}
+
+		existing[fingerprint] = check
+	}
+
+	return existing, nil
+}
+
+// SuccessCount is the number of the targets' checks the sink has, whether
+// they were created, updated or already there
 func (p *Pool) SuccessCount() int {
-	return p.successCount
+	return p.createdCount + p.updatedCount + p.skippedCount
+}
+
+func (p *Pool) CreatedCount() int {
+	return p.createdCount
+}
+
+func (p *Pool) UpdatedCount() int {
+	return p.updatedCount
+}
+
+func (p *Pool) SkippedCount() int {
+	return p.skippedCount
 }
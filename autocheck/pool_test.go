@@ -1,14 +1,36 @@
 package autocheck
 
 import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
-type testSink struct{}
+type testSink struct {
+	checks []*schema.Check
+}
+
+func (s *testSink) ListChecks() ([]*schema.Check, error) {
+	return s.checks, nil
+}
+
+func (s *testSink) CreateCheck(check *schema.Check) error {
+	check.Id = fmt.Sprintf("check-%d", len(s.checks))
+	s.checks = append(s.checks, check)
+	return nil
+}
+
+func (s *testSink) UpdateCheck(check *schema.Check) error {
+	for i, c := range s.checks {
+		if c.Id == check.Id {
+			s.checks[i] = check
+		}
+	}
 
-func (s *testSink) Send(check *schema.Check) error {
 	return nil
 }
 
@@ -24,4 +46,80 @@ func TestPool(t *testing.T) {
 	pool.Drain()
 
 	assert.Equal(expected, pool.SuccessCount())
+	assert.Equal(expected, pool.CreatedCount())
+}
+
+func TestPoolRelaunch(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		sink     = &testSink{}
+		instance = &ec2.Instance{InstanceId: aws.String("i-7a2b3c4d")}
+		other    = &ec2.Instance{InstanceId: aws.String("i-e48b6a39")}
+		lb       = elbtests[1].elb
+	)
+
+	// the first launch discovers the load balancer twice
+	pool := NewPool(sink, nil)
+	pool.AddTarget(lb)
+	pool.AddTarget(lb)
+	pool.AddTarget(instance)
+	pool.AddTarget(other)
+	pool.Drain()
+
+	assert.Equal(3, pool.CreatedCount())
+	assert.Equal(1, pool.SkippedCount())
+	assert.Len(sink.checks, 3)
+
+	// a check the customer has made their own isn't updated
+	sink.checks[2].Name = "my instance's metrics"
+	sink.checks[2].Assertions[0].Operand = "50.000"
+
+	// the relaunch finds an alarm on the instance's cpu
+	pool = NewPool(sink, nil)
+	pool.AddTarget(lb)
+	pool.AddTargetWithAlarms(instance, writeEC2Alarms()[:1])
+	pool.AddTarget(other)
+	pool.Drain()
+
+	assert.Equal(0, pool.CreatedCount())
+	assert.Equal(1, pool.UpdatedCount())
+	assert.Equal(2, pool.SkippedCount())
+	assert.Equal(3, pool.SuccessCount())
+	assert.Len(sink.checks, 3)
+	assert.Equal("check-1", sink.checks[1].Id)
+	assert.Equal("80.000", sink.checks[1].Assertions[0].Operand)
+	assert.Equal("50.000", sink.checks[2].Assertions[0].Operand)
+}
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	checks, err := NewTarget(elbtests[1].elb).Generate()
+	assert.NoError(err)
+	check := checks[0]
+
+	fingerprint, err := Fingerprint(check)
+	assert.NoError(err)
+
+	// thresholds aren't part of it
+	check.Assertions[0].Operand = "204"
+	check.Interval = 10
+	same, err := Fingerprint(check)
+	assert.NoError(err)
+	assert.Equal(fingerprint, same)
+
+	// bartnet can return the spec in the oneof instead
+	spec, err := opsee_types.UnmarshalAny(check.CheckSpec)
+	assert.NoError(err)
+	check.CheckSpec = nil
+	check.Spec = &schema.Check_HttpCheck{HttpCheck: spec.(*schema.HttpCheck)}
+	same, err = Fingerprint(check)
+	assert.NoError(err)
+	assert.Equal(fingerprint, same)
+
+	check.Target.Id = "other"
+	other, err := Fingerprint(check)
+	assert.NoError(err)
+	assert.NotEqual(fingerprint, other)
 }
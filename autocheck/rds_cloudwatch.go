@@ -23,6 +23,7 @@ var (
 type RDSCloudWatch struct {
 	*rds.DBInstance
 	metricAlarms []*opsee_cloudwatch.MetricAlarm
+	policy       *Policy
 }
 
 func (rc RDSCloudWatch) Generate() ([]*schema.Check, error) {
@@ -38,12 +39,17 @@ func (rc RDSCloudWatch) Generate() ([]*schema.Check, error) {
 	)
 
 	dbName := aws.StringValue(dbinst.DBInstanceIdentifier)
+	policy := rc.policy.dbInstances()
+	if !policy.IsEnabled(true) || !rc.policy.includes([]string{dbName}, nil) {
+		return []*schema.Check{}, nil
+	}
+
 	name := fmt.Sprintf("RDS metrics for %s (auto)", dbName)
-	maxCPU := maxCPUUtil
+	maxCPU := policy.MaxCPUUtil
 	// RDS DB instance max connections are proporitional to instance class resources, i.e.,
 	// 		max_connections = DBInstanceClassMemoryBytes / 12582880
-	maxConnections := (GetInstanceClassMemory(*rc.DBInstance.DBInstanceClass) / 12582880.0) * maxConnRatio
-	minFreeMem := GetInstanceClassMemory(*rc.DBInstance.DBInstanceClass) * minMemRatio
+	maxConnections := (GetInstanceClassMemory(*rc.DBInstance.DBInstanceClass) / 12582880.0) * policy.MaxConnRatio
+	minFreeMem := GetInstanceClassMemory(*rc.DBInstance.DBInstanceClass) * policy.MinMemRatio
 
 	// if any found cloudwatch alarms are for this RDS instance then
 	//   use their thresholds in either the default metric assertions
@@ -137,14 +143,15 @@ func (rc RDSCloudWatch) Generate() ([]*schema.Check, error) {
 	}
 	check := &schema.Check{
 		Name:     name,
-		Interval: int32(60),
+		Interval: policy.Interval,
 		Target: &schema.Target{
 			Name: dbName,
 			Type: "dbinstance",
 			Id:   dbName,
 		},
-		CheckSpec:  checkSpec,
-		Assertions: assertions,
+		CheckSpec:     checkSpec,
+		Assertions:    assertions,
+		Notifications: rc.policy.notifications(),
 	}
 
 	return []*schema.Check{check}, nil
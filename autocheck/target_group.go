@@ -0,0 +1,186 @@
+package autocheck
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the port the targets get traffic on, rather than one of its own
+	trafficPort = "traffic-port"
+
+	defaultMatcherCode = "200"
+)
+
+var (
+	errNoTargetGroup     = errors.New("no target group")
+	errNoTargetGroupName = errors.New("no target group name")
+)
+
+// TargetGroup is an application load balancer's target group, its checks are
+// the health check the load balancer runs against its targets
+type TargetGroup struct {
+	*elbv2.TargetGroup
+	policy *Policy
+}
+
+func (tg TargetGroup) Generate() ([]*schema.Check, error) {
+	group := tg.TargetGroup
+	if group == nil {
+		return nil, errNoTargetGroup
+	}
+
+	if group.TargetGroupName == nil {
+		return nil, errNoTargetGroupName
+	}
+
+	var (
+		checks   = make([]*schema.Check, 0)
+		policy   = tg.policy.targetGroups()
+		name     = aws.StringValue(group.TargetGroupName)
+		protocol = strings.ToLower(aws.StringValue(group.HealthCheckProtocol))
+	)
+
+	if !policy.IsEnabled(true) || !tg.policy.includes([]string{name}, nil) {
+		return checks, nil
+	}
+
+	// tcp health checks, from network load balancers, need a check type
+	// opsee/basic/schema doesn't have
+	if protocol != "http" && protocol != "https" {
+		return checks, nil
+	}
+
+	port := aws.Int64Value(group.Port)
+	if healthCheckPort := aws.StringValue(group.HealthCheckPort); healthCheckPort != "" && healthCheckPort != trafficPort {
+		p, err := strconv.ParseInt(healthCheckPort, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing port %s", healthCheckPort)
+		}
+
+		port = p
+	}
+
+	path := aws.StringValue(group.HealthCheckPath)
+	if path == "" {
+		path = "/"
+	}
+
+	code := defaultMatcherCode
+	if group.Matcher != nil && aws.StringValue(group.Matcher.HttpCode) != "" {
+		code = aws.StringValue(group.Matcher.HttpCode)
+	}
+
+	assertions, err := codeAssertions(code)
+	if err != nil {
+		return nil, err
+	}
+
+	checkName := fmt.Sprintf("%s %s (auto)", protocol, name)
+	httpCheck := &schema.HttpCheck{
+		Name:     checkName,
+		Path:     path,
+		Protocol: protocol,
+		Port:     int32(port),
+		Verb:     "GET",
+	}
+
+	checkSpec, err := opsee_types.MarshalAny(httpCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	checks = append(checks, &schema.Check{
+		Name:     checkName,
+		Interval: policy.Interval,
+		Target: &schema.Target{
+			Name: name,
+			Type: "target_group",
+			Id:   aws.StringValue(group.TargetGroupArn),
+		},
+		CheckSpec:     checkSpec,
+		Assertions:    assertions,
+		Notifications: tg.policy.notifications(),
+	})
+
+	return checks, nil
+}
+
+// codeAssertions turns a matcher's http codes, like 200, 200,202 or 200-299,
+// into assertions. assertions all have to pass, so only one contiguous run of
+// codes can be asserted. codes that don't join up, like 200,302, are checked
+// against the run holding the first listed code.
+func codeAssertions(httpCode string) ([]*schema.Assertion, error) {
+	runs := make([][2]int, 0)
+
+	for _, part := range strings.Split(httpCode, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		run := [2]int{}
+		for i, b := range bounds {
+			code, err := strconv.Atoi(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("error parsing http code %s", httpCode)
+			}
+
+			run[i] = code
+		}
+
+		if len(bounds) == 1 {
+			run[1] = run[0]
+		}
+
+		if run[1] < run[0] {
+			return nil, fmt.Errorf("error parsing http code %s", httpCode)
+		}
+
+		runs = append(runs, run)
+	}
+
+	// grow the first listed run with any codes that touch it, until none do
+	low, high := runs[0][0], runs[0][1]
+	for grown := true; grown; {
+		grown = false
+		for _, run := range runs {
+			if run[0] > high+1 || run[1] < low-1 {
+				continue
+			}
+
+			if run[0] < low {
+				low, grown = run[0], true
+			}
+
+			if run[1] > high {
+				high, grown = run[1], true
+			}
+		}
+	}
+
+	if low == high {
+		return []*schema.Assertion{
+			{
+				Key:          "code",
+				Relationship: "equal",
+				Operand:      strconv.Itoa(low),
+			},
+		}, nil
+	}
+
+	return []*schema.Assertion{
+		{
+			Key:          "code",
+			Relationship: "greaterThan",
+			Operand:      strconv.Itoa(low - 1),
+		},
+		{
+			Key:          "code",
+			Relationship: "lessThan",
+			Operand:      strconv.Itoa(high + 1),
+		},
+	}, nil
+}
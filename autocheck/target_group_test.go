@@ -0,0 +1,164 @@
+package autocheck
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testTargetGroupArn = "arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/web/73e2d6bc24d8a067"
+
+var targetGroupTests = []struct {
+	group  *elbv2.TargetGroup
+	checks []*schema.Check
+}{
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("web"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("HTTP"),
+			Port:                aws.Int64(8080),
+			HealthCheckProtocol: aws.String("HTTP"),
+			HealthCheckPort:     aws.String("traffic-port"),
+			HealthCheckPath:     aws.String("/health"),
+			Matcher:             &elbv2.Matcher{HttpCode: aws.String("200")},
+		},
+		checks: testTargetGroupChecks("http", "/health", 8080, &schema.Assertion{
+			Key:          "code",
+			Relationship: "equal",
+			Operand:      "200",
+		}),
+	},
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("web"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("HTTP"),
+			Port:                aws.Int64(8080),
+			HealthCheckProtocol: aws.String("HTTPS"),
+			HealthCheckPort:     aws.String("8443"),
+			HealthCheckPath:     aws.String("/ping"),
+			Matcher:             &elbv2.Matcher{HttpCode: aws.String("200-299")},
+		},
+		checks: testTargetGroupChecks("https", "/ping", 8443, &schema.Assertion{
+			Key:          "code",
+			Relationship: "greaterThan",
+			Operand:      "199",
+		}, &schema.Assertion{
+			Key:          "code",
+			Relationship: "lessThan",
+			Operand:      "300",
+		}),
+	},
+	// lists of contiguous codes are checked as the range they span
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("web"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("HTTP"),
+			Port:                aws.Int64(80),
+			HealthCheckProtocol: aws.String("HTTP"),
+			Matcher:             &elbv2.Matcher{HttpCode: aws.String("200,201,202")},
+		},
+		checks: testTargetGroupChecks("http", "/", 80, &schema.Assertion{
+			Key:          "code",
+			Relationship: "greaterThan",
+			Operand:      "199",
+		}, &schema.Assertion{
+			Key:          "code",
+			Relationship: "lessThan",
+			Operand:      "203",
+		}),
+	},
+	// codes that don't join up are checked against the first listed one's run
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("web"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("HTTP"),
+			Port:                aws.Int64(80),
+			HealthCheckProtocol: aws.String("HTTP"),
+			Matcher:             &elbv2.Matcher{HttpCode: aws.String("200,302")},
+		},
+		checks: testTargetGroupChecks("http", "/", 80, &schema.Assertion{
+			Key:          "code",
+			Relationship: "equal",
+			Operand:      "200",
+		}),
+	},
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("web"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("HTTP"),
+			Port:                aws.Int64(80),
+			HealthCheckProtocol: aws.String("HTTP"),
+			Matcher:             &elbv2.Matcher{HttpCode: aws.String("302,200-299,300-301")},
+		},
+		checks: testTargetGroupChecks("http", "/", 80, &schema.Assertion{
+			Key:          "code",
+			Relationship: "greaterThan",
+			Operand:      "199",
+		}, &schema.Assertion{
+			Key:          "code",
+			Relationship: "lessThan",
+			Operand:      "303",
+		}),
+	},
+	{
+		group: &elbv2.TargetGroup{
+			TargetGroupName:     aws.String("tcp"),
+			TargetGroupArn:      aws.String(testTargetGroupArn),
+			Protocol:            aws.String("TCP"),
+			Port:                aws.Int64(5432),
+			HealthCheckProtocol: aws.String("TCP"),
+		},
+		checks: []*schema.Check{},
+	},
+}
+
+func TestTargetGroupGenerate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range targetGroupTests {
+		checks, err := NewTarget(test.group).Generate()
+		assert.NoError(err)
+		assert.EqualValues(test.checks, checks)
+	}
+
+	_, err := NewTarget(&elbv2.TargetGroup{
+		TargetGroupName:     aws.String("web"),
+		HealthCheckProtocol: aws.String("HTTP"),
+		Matcher:             &elbv2.Matcher{HttpCode: aws.String("ok")},
+	}).Generate()
+	assert.Error(err)
+}
+
+func testTargetGroupChecks(protocol, path string, port int32, assertions ...*schema.Assertion) []*schema.Check {
+	name := protocol + " web (auto)"
+	httpCheck := &schema.HttpCheck{
+		Name:     name,
+		Path:     path,
+		Protocol: protocol,
+		Port:     port,
+		Verb:     "GET",
+	}
+
+	checkSpec, _ := opsee_types.MarshalAny(httpCheck)
+	return []*schema.Check{
+		{
+			Name:     name,
+			Interval: int32(30),
+			Target: &schema.Target{
+				Name: "web",
+				Type: "target_group",
+				Id:   testTargetGroupArn,
+			},
+			CheckSpec:  checkSpec,
+			Assertions: assertions,
+		},
+	}
+}
@@ -0,0 +1,183 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// the catalog can be replaced without a deploy by putting it here
+	catalogKeyPath = "/opsee.co/config/keelhaul/catalog"
+	refreshRate    = time.Duration(5) * time.Minute
+)
+
+var (
+	ErrUnsupportedRegion       = errors.New("region is not supported.")
+	ErrUnsupportedInstanceType = errors.New("instance size is not supported in this region.")
+)
+
+type InstanceType struct {
+	Name      string  `json:"name"`
+	Family    string  `json:"family"`
+	VCPUs     int     `json:"vcpus"`
+	MemoryGiB float64 `json:"memory_gib"`
+}
+
+type Region struct {
+	Name                string   `json:"name"`
+	InstanceTypes       []string `json:"instance_types"`
+	DefaultInstanceType string   `json:"default_instance_type"`
+}
+
+// Catalog is the regions bastions can be launched in and the instance types
+// they can be launched as in each of them
+type Catalog struct {
+	InstanceTypes []*InstanceType `json:"instance_types"`
+	Regions       []*Region       `json:"regions"`
+}
+
+// Default is the catalog keelhaul ships with
+var Default = mustParse(defaultCatalog)
+
+// Parse reads a catalog and checks that the instance types its regions
+// refer to, defaults included, are all in it
+func Parse(body []byte) (*Catalog, error) {
+	c := &Catalog{}
+	err := json.Unmarshal(body, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Regions) == 0 {
+		return nil, fmt.Errorf("catalog has no regions")
+	}
+
+	types := make(map[string]bool)
+	for _, t := range c.InstanceTypes {
+		types[t.Name] = true
+	}
+
+	for _, r := range c.Regions {
+		for _, t := range r.InstanceTypes {
+			if !types[t] {
+				return nil, fmt.Errorf("region %s has unknown instance type %s", r.Name, t)
+			}
+		}
+
+		if !r.supports(r.DefaultInstanceType) {
+			return nil, fmt.Errorf("region %s doesn't support its default instance type %s", r.Name, r.DefaultInstanceType)
+		}
+	}
+
+	return c, nil
+}
+
+func mustParse(body string) *Catalog {
+	c, err := Parse([]byte(body))
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+func (r *Region) supports(instanceType string) bool {
+	for _, t := range r.InstanceTypes {
+		if t == instanceType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Region returns the named region, or nil if it isn't supported
+func (c *Catalog) Region(name string) *Region {
+	for _, r := range c.Regions {
+		if r.Name == name {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that bastions can be launched as the instance type in
+// the region
+func (c *Catalog) Validate(region, instanceType string) error {
+	r := c.Region(region)
+	if r == nil {
+		return ErrUnsupportedRegion
+	}
+
+	if !r.supports(instanceType) {
+		return ErrUnsupportedInstanceType
+	}
+
+	return nil
+}
+
+// DefaultInstanceType is the instance type recommended for the region
+func (c *Catalog) DefaultInstanceType(region string) (string, error) {
+	r := c.Region(region)
+	if r == nil {
+		return "", ErrUnsupportedRegion
+	}
+
+	return r.DefaultInstanceType, nil
+}
+
+// Loader keeps the catalog in etcd, or the default when there isn't one
+// there, and refreshes it every few minutes
+type Loader struct {
+	etcd     etcd.KeysAPI
+	mut      sync.Mutex
+	catalog  *Catalog
+	loadedAt time.Time
+}
+
+func NewLoader(etcdKAPI etcd.KeysAPI) *Loader {
+	return &Loader{
+		etcd:    etcdKAPI,
+		catalog: Default,
+	}
+}
+
+// Catalog returns the current catalog. when etcd can't be read, or has a
+// catalog that doesn't parse, the last good one is kept.
+func (l *Loader) Catalog(ctx context.Context) *Catalog {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.etcd == nil || time.Since(l.loadedAt) < refreshRate {
+		return l.catalog
+	}
+
+	l.loadedAt = time.Now()
+	response, err := l.etcd.Get(ctx, catalogKeyPath, &etcd.GetOptions{Quorum: true})
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok && etcdErr.Code == etcd.ErrorCodeKeyNotFound {
+			l.catalog = Default
+			return l.catalog
+		}
+
+		log.WithError(err).Error("failed fetching catalog from etcd")
+		return l.catalog
+	}
+
+	c, err := Parse([]byte(response.Node.Value))
+	if err != nil {
+		log.WithError(err).Error("failed parsing catalog from etcd")
+		return l.catalog
+	}
+
+	l.catalog = c
+	return l.catalog
+}
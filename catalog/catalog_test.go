@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"errors"
+	"testing"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+var validateTests = []struct {
+	region       string
+	instanceType string
+	err          error
+}{
+	{"us-west-2", "t2.micro", nil},
+	{"us-west-2", "m5.large", nil},
+	{"us-east-2", "t3.micro", nil},
+	{"us-east-2", "m3.medium", ErrUnsupportedInstanceType},
+	{"eu-north-1", "t2.micro", ErrUnsupportedInstanceType},
+	{"us-west-2", "x1.32xlarge", ErrUnsupportedInstanceType},
+	{"us-west-2", "", ErrUnsupportedInstanceType},
+	{"us-moon-1", "t2.micro", ErrUnsupportedRegion},
+	{"", "t2.micro", ErrUnsupportedRegion},
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range validateTests {
+		assert.Equal(tt.err, Default.Validate(tt.region, tt.instanceType), tt.region+" "+tt.instanceType)
+	}
+}
+
+func TestDefaultInstanceType(t *testing.T) {
+	assert := assert.New(t)
+
+	instanceType, err := Default.DefaultInstanceType("us-west-2")
+	assert.NoError(err)
+	assert.Equal("t2.micro", instanceType)
+
+	instanceType, err = Default.DefaultInstanceType("eu-north-1")
+	assert.NoError(err)
+	assert.Equal("t3.micro", instanceType)
+
+	_, err = Default.DefaultInstanceType("us-moon-1")
+	assert.Equal(ErrUnsupportedRegion, err)
+
+	// every region's default is launchable there
+	for _, r := range Default.Regions {
+		assert.NoError(Default.Validate(r.Name, r.DefaultInstanceType))
+	}
+}
+
+var parseTests = []struct {
+	body string
+	err  bool
+}{
+	{`{"instance_types": [{"name": "t2.micro"}], "regions": [{"name": "us-west-2", "instance_types": ["t2.micro"], "default_instance_type": "t2.micro"}]}`, false},
+	{`{"instance_types": [{"name": "t2.micro"}], "regions": []}`, true},
+	{`{"instance_types": [{"name": "t2.micro"}], "regions": [{"name": "us-west-2", "instance_types": ["t3.micro"], "default_instance_type": "t3.micro"}]}`, true},
+	{`{"instance_types": [{"name": "t2.micro"}], "regions": [{"name": "us-west-2", "instance_types": ["t2.micro"], "default_instance_type": "t2.small"}]}`, true},
+	{`{"regions": `, true},
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range parseTests {
+		_, err := Parse([]byte(tt.body))
+		assert.Equal(tt.err, err != nil, tt.body)
+	}
+}
+
+type fakeEtcd struct {
+	etcd.KeysAPI
+	value string
+	err   error
+	gets  int
+}
+
+func (e *fakeEtcd) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	e.gets++
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return &etcd.Response{
+		Node: &etcd.Node{Key: key, Value: e.value},
+	}, nil
+}
+
+func TestLoader(t *testing.T) {
+	assert := assert.New(t)
+
+	e := &fakeEtcd{err: etcd.Error{Code: etcd.ErrorCodeKeyNotFound}}
+	l := NewLoader(e)
+	assert.Equal(Default, l.Catalog(context.Background()))
+
+	// the catalog is only fetched every few minutes
+	e.err = nil
+	e.value = parseTests[0].body
+	assert.Equal(Default, l.Catalog(context.Background()))
+	assert.Equal(1, e.gets)
+
+	l.loadedAt = l.loadedAt.Add(-refreshRate)
+	c := l.Catalog(context.Background())
+	assert.Len(c.Regions, 1)
+	assert.Equal(ErrUnsupportedRegion, c.Validate("us-east-1", "t2.micro"))
+
+	// the last good catalog is kept when etcd fails, or has a bad one
+	l.loadedAt = l.loadedAt.Add(-refreshRate)
+	e.err = errors.New("etcd is down")
+	assert.Equal(c, l.Catalog(context.Background()))
+
+	l.loadedAt = l.loadedAt.Add(-refreshRate)
+	e.err = nil
+	e.value = parseTests[2].body
+	assert.Equal(c, l.Catalog(context.Background()))
+	assert.Equal(4, e.gets)
+}
@@ -0,0 +1,125 @@
+package catalog
+
+// defaultCatalog is used until a catalog is put in etcd. newer regions don't
+// have the older instance families, and eu-north-1 only has the newest.
+const defaultCatalog = `{
+  "instance_types": [
+    {"name": "t2.nano", "family": "t2", "vcpus": 1, "memory_gib": 0.5},
+    {"name": "t2.micro", "family": "t2", "vcpus": 1, "memory_gib": 1},
+    {"name": "t2.small", "family": "t2", "vcpus": 1, "memory_gib": 2},
+    {"name": "t2.medium", "family": "t2", "vcpus": 2, "memory_gib": 4},
+    {"name": "t2.large", "family": "t2", "vcpus": 2, "memory_gib": 8},
+    {"name": "t2.xlarge", "family": "t2", "vcpus": 4, "memory_gib": 16},
+    {"name": "t2.2xlarge", "family": "t2", "vcpus": 8, "memory_gib": 32},
+    {"name": "t3.nano", "family": "t3", "vcpus": 2, "memory_gib": 0.5},
+    {"name": "t3.micro", "family": "t3", "vcpus": 2, "memory_gib": 1},
+    {"name": "t3.small", "family": "t3", "vcpus": 2, "memory_gib": 2},
+    {"name": "t3.medium", "family": "t3", "vcpus": 2, "memory_gib": 4},
+    {"name": "t3.large", "family": "t3", "vcpus": 2, "memory_gib": 8},
+    {"name": "t3.xlarge", "family": "t3", "vcpus": 4, "memory_gib": 16},
+    {"name": "t3.2xlarge", "family": "t3", "vcpus": 8, "memory_gib": 32},
+    {"name": "m3.medium", "family": "m3", "vcpus": 1, "memory_gib": 3.75},
+    {"name": "m3.large", "family": "m3", "vcpus": 2, "memory_gib": 7.5},
+    {"name": "m3.xlarge", "family": "m3", "vcpus": 4, "memory_gib": 15},
+    {"name": "m3.2xlarge", "family": "m3", "vcpus": 8, "memory_gib": 30},
+    {"name": "m4.large", "family": "m4", "vcpus": 2, "memory_gib": 8},
+    {"name": "m4.xlarge", "family": "m4", "vcpus": 4, "memory_gib": 16},
+    {"name": "m4.2xlarge", "family": "m4", "vcpus": 8, "memory_gib": 32},
+    {"name": "m4.4xlarge", "family": "m4", "vcpus": 16, "memory_gib": 64},
+    {"name": "m4.10xlarge", "family": "m4", "vcpus": 40, "memory_gib": 160},
+    {"name": "m4.16xlarge", "family": "m4", "vcpus": 64, "memory_gib": 256},
+    {"name": "m5.large", "family": "m5", "vcpus": 2, "memory_gib": 8},
+    {"name": "m5.xlarge", "family": "m5", "vcpus": 4, "memory_gib": 16},
+    {"name": "m5.2xlarge", "family": "m5", "vcpus": 8, "memory_gib": 32},
+    {"name": "m5.4xlarge", "family": "m5", "vcpus": 16, "memory_gib": 64},
+    {"name": "c4.large", "family": "c4", "vcpus": 2, "memory_gib": 3.75},
+    {"name": "c4.xlarge", "family": "c4", "vcpus": 4, "memory_gib": 7.5},
+    {"name": "c4.2xlarge", "family": "c4", "vcpus": 8, "memory_gib": 15},
+    {"name": "c5.large", "family": "c5", "vcpus": 2, "memory_gib": 4},
+    {"name": "c5.xlarge", "family": "c5", "vcpus": 4, "memory_gib": 8},
+    {"name": "c5.2xlarge", "family": "c5", "vcpus": 8, "memory_gib": 16}
+  ],
+  "regions": [
+    {
+      "name": "ap-northeast-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "ap-northeast-2",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "ap-south-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "ap-southeast-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "ap-southeast-2",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "ca-central-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "eu-central-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "eu-north-1",
+      "default_instance_type": "t3.micro",
+      "instance_types": ["t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "eu-west-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "eu-west-2",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "eu-west-3",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "sa-east-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "us-east-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "us-east-2",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "us-west-1",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    },
+    {
+      "name": "us-west-2",
+      "default_instance_type": "t2.micro",
+      "instance_types": ["t2.nano", "t2.micro", "t2.small", "t2.medium", "t2.large", "t2.xlarge", "t2.2xlarge", "t3.nano", "t3.micro", "t3.small", "t3.medium", "t3.large", "t3.xlarge", "t3.2xlarge", "m3.medium", "m3.large", "m3.xlarge", "m3.2xlarge", "m4.large", "m4.xlarge", "m4.2xlarge", "m4.4xlarge", "m4.10xlarge", "m4.16xlarge", "m5.large", "m5.xlarge", "m5.2xlarge", "m5.4xlarge", "c4.large", "c4.xlarge", "c4.2xlarge", "c5.large", "c5.xlarge", "c5.2xlarge"]
+    }
+  ]
+}
+`
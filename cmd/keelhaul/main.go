@@ -3,6 +3,7 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"strconv"
 	"time"
 
 	"crypto/tls"
@@ -10,9 +11,12 @@ import (
 	etcd "github.com/coreos/etcd/client"
 	opsee "github.com/opsee/basic/service"
 	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/catalog"
 	"github.com/opsee/keelhaul/config"
 	"github.com/opsee/keelhaul/launcher"
 	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/reaper"
+	"github.com/opsee/keelhaul/reconciler"
 	"github.com/opsee/keelhaul/router"
 	"github.com/opsee/keelhaul/service"
 	"github.com/opsee/keelhaul/store"
@@ -46,6 +50,7 @@ func main() {
 		SpanxEndpoint:              mustEnvString("KEELHAUL_SPANX_ENDPOINT"),
 		BezosEndpoint:              mustEnvString("KEELHAUL_BEZOS_ENDPOINT"),
 		SkipVerify:                 mustEnvBool("KEELHAUL_SKIP_VERIFY"),
+		MaxBastions:                envInt("KEELHAUL_MAX_BASTIONS", 5),
 	}
 
 	key, err := ioutil.ReadFile(cfg.VapeKey)
@@ -92,20 +97,38 @@ func main() {
 	}
 	bezosClient := opsee.NewBezosClient(bezosConn)
 
+	err = launcher.ValidateTemplates()
+	if err != nil {
+		log.WithError(err).Fatal("cloudformation template doesn't match launch parameters")
+	}
+
 	launcher, err := launcher.New(db, router, etcdKeysAPI, bus, notifier, spanxclient, bezosClient, cfg)
 	if err != nil {
 		log.Fatalf("couldn't initialize launcher: ", err)
 	}
 
-	tracker := tracker.New(db, etcdKeysAPI, notifier)
+	err = launcher.Resume()
+	if err != nil {
+		log.WithError(err).Error("couldn't resume launches")
+	}
+
+	tracker := tracker.New(db, etcdKeysAPI, bus, notifier, spanxclient)
 	tracker.Start()
 
+	reaper := reaper.New(db, etcdKeysAPI, bus, spanxclient)
+	reaper.Start()
+
+	reconciler := reconciler.New(db, etcdKeysAPI, bus, notifier, spanxclient)
+	reconciler.Start()
+
 	certfile := mustEnvString("KEELHAUL_CERT")
 	certkeyfile := mustEnvString("KEELHAUL_CERT_KEY")
 
-	svc := service.New(db, bus, launcher, router, spanxclient, cfg)
+	svc := service.New(db, bus, launcher, router, spanxclient, catalog.NewLoader(etcdKeysAPI), cfg)
 	svc.StartMux(cfg.PublicHost, certfile, certkeyfile)
 
+	reconciler.Stop()
+	reaper.Stop()
 	tracker.Stop()
 	bus.Stop()
 }
@@ -129,6 +152,19 @@ func mustEnvBool(envVar string) bool {
 	return false
 }
 
+func envInt(envVar string, def int) int {
+	out := os.Getenv(envVar)
+	if out == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(out)
+	if err != nil {
+		log.Fatal(envVar, "must be an integer")
+	}
+	return i
+}
+
 func grpcConn(addr string, skipVerify bool) (*grpc.ClientConn, error) {
 	return grpc.Dial(
 		addr,
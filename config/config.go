@@ -22,4 +22,5 @@ type Config struct {
 	SpanxEndpoint              string
 	BezosEndpoint              string
 	SkipVerify                 bool
+	MaxBastions                int
 }
@@ -0,0 +1,975 @@
+package launcher
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/store"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	fakeAccount = "123456789012"
+	fakeRegion  = "us-west-2"
+
+	// elb and elbv2 share their actions' names
+	elbv2Version = "2015-12-01"
+)
+
+// fakeAWS serves the ec2, elb, elbv2, rds and autoscaling describe calls made with
+// its session. the resources it describes can be changed between launches.
+type fakeAWS struct {
+	server *httptest.Server
+	mut    *sync.Mutex
+
+	// image id -> creation date
+	images   map[string]string
+	keyPairs map[string]bool
+
+	// security group id -> instance ids
+	securityGroups map[string][]string
+	loadBalancers  []string
+	dbInstances    []string
+
+	// target group names, each has an application load balancer of its own
+	targetGroups []string
+
+	// actions that fail, and groups whose instances can't be described
+	failActions map[string]bool
+	failGroups  map[string]bool
+}
+
+var ec2Actions = map[string]bool{
+	"DescribeImages":         true,
+	"DescribeKeyPairs":       true,
+	"DescribeInstances":      true,
+	"DescribeSecurityGroups": true,
+	"DescribeRouteTables":    true,
+	"DescribeSubnets":        true,
+}
+
+func newFakeAWS() *fakeAWS {
+	f := &fakeAWS{
+		mut:            &sync.Mutex{},
+		images:         make(map[string]string),
+		keyPairs:       make(map[string]bool),
+		securityGroups: make(map[string][]string),
+		failActions:    make(map[string]bool),
+		failGroups:     make(map[string]bool),
+	}
+
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeAWS) Close() {
+	f.server.Close()
+}
+
+func (f *fakeAWS) session() *session.Session {
+	return session.New(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("AKIDFAKE", "fake", ""),
+		Endpoint:    aws.String(f.server.URL),
+		Region:      aws.String(fakeRegion),
+		MaxRetries:  aws.Int(0),
+	})
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	action := r.Form.Get("Action")
+	if f.failActions[action] {
+		f.writeError(w, action, "UnauthorizedOperation", "You are not authorized to perform this operation.")
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	switch action {
+	case "DescribeImages":
+		ids := make([]string, 0, len(f.images))
+		for id := range f.images {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		buf.WriteString("<imagesSet>")
+		for _, id := range ids {
+			fmt.Fprintf(buf, "<item><imageId>%s</imageId><creationDate>%s</creationDate></item>", id, f.images[id])
+		}
+		buf.WriteString("</imagesSet>")
+
+	case "DescribeKeyPairs":
+		name := r.Form.Get("KeyName.1")
+		if !f.keyPairs[name] {
+			f.writeError(w, action, "InvalidKeyPair.NotFound", fmt.Sprintf("The key pair '%s' does not exist", name))
+			return
+		}
+
+		fmt.Fprintf(buf, "<keySet><item><keyName>%s</keyName></item></keySet>", name)
+
+	case "DescribeSecurityGroups":
+		buf.WriteString("<securityGroupInfo>")
+		for _, id := range f.groupIDs() {
+			fmt.Fprintf(buf, "<item><groupId>%s</groupId><vpcId>vpc-fake</vpcId></item>", id)
+		}
+		buf.WriteString("</securityGroupInfo>")
+
+	case "DescribeInstances":
+		group := filterValue(r, "instance.group-id")
+		if f.failGroups[group] {
+			f.writeError(w, action, "RequestLimitExceeded", "Request limit exceeded.")
+			return
+		}
+
+		buf.WriteString("<reservationSet><item><instancesSet>")
+		for _, id := range f.securityGroups[group] {
+			fmt.Fprintf(buf, "<item><instanceId>%s</instanceId></item>", id)
+		}
+		buf.WriteString("</instancesSet></item></reservationSet>")
+
+	case "DescribeRouteTables":
+		buf.WriteString("<routeTableSet/>")
+
+	case "DescribeSubnets":
+		buf.WriteString("<subnetSet/>")
+
+	case "DescribeLoadBalancers":
+		if r.Form.Get("Version") == elbv2Version {
+			buf.WriteString("<LoadBalancers>")
+			for _, name := range f.targetGroups {
+				fmt.Fprintf(buf, "<member><LoadBalancerArn>%s</LoadBalancerArn><VpcId>vpc-fake</VpcId><Type>application</Type></member>", fakeALBArn(name))
+			}
+
+			// network load balancers aren't counted
+			buf.WriteString("<member><LoadBalancerArn>nlb</LoadBalancerArn><VpcId>vpc-fake</VpcId><Type>network</Type></member>")
+			buf.WriteString("</LoadBalancers>")
+			break
+		}
+
+		buf.WriteString("<LoadBalancerDescriptions>")
+		for _, name := range f.loadBalancers {
+			fmt.Fprintf(buf, "<member><LoadBalancerName>%s</LoadBalancerName><VPCId>vpc-fake</VPCId><HealthCheck><Target>HTTP:80/health</Target></HealthCheck></member>", name)
+		}
+		buf.WriteString("</LoadBalancerDescriptions>")
+
+	case "DescribeTargetGroups":
+		buf.WriteString("<TargetGroups>")
+		for _, name := range f.targetGroups {
+			fmt.Fprintf(buf, "<member><TargetGroupName>%s</TargetGroupName><TargetGroupArn>arn:aws:elasticloadbalancing:%s:%s:targetgroup/%s/fake</TargetGroupArn>", name, fakeRegion, fakeAccount, name)
+			fmt.Fprintf(buf, "<VpcId>vpc-fake</VpcId><Port>80</Port><HealthCheckProtocol>HTTP</HealthCheckProtocol><HealthCheckPath>/health</HealthCheckPath>")
+			fmt.Fprintf(buf, "<LoadBalancerArns><member>%s</member></LoadBalancerArns></member>", fakeALBArn(name))
+		}
+
+		// one that isn't used by any load balancer
+		buf.WriteString("<member><TargetGroupName>unused</TargetGroupName><VpcId>vpc-fake</VpcId><HealthCheckProtocol>HTTP</HealthCheckProtocol></member>")
+		buf.WriteString("</TargetGroups>")
+
+	case "DescribeDBInstances":
+		buf.WriteString("<DBInstances>")
+		for _, id := range f.dbInstances {
+			fmt.Fprintf(buf, "<DBInstance><DBInstanceIdentifier>%s</DBInstanceIdentifier><DBInstanceClass>db.m3.medium</DBInstanceClass></DBInstance>", id)
+		}
+		buf.WriteString("</DBInstances>")
+
+	case "DescribeDBSecurityGroups":
+		buf.WriteString("<DBSecurityGroups/>")
+
+	case "DescribeAutoScalingGroups":
+		buf.WriteString("<AutoScalingGroups/>")
+
+	default:
+		f.writeError(w, action, "InvalidAction", fmt.Sprintf("fake aws doesn't do %s", action))
+		return
+	}
+
+	// ec2 responses are the bare result, the query services wrap it
+	if ec2Actions[action] {
+		fmt.Fprintf(w, "<%sResponse>%s</%sResponse>", action, buf.String(), action)
+	} else {
+		fmt.Fprintf(w, "<%sResponse><%sResult>%s</%sResult></%sResponse>", action, action, buf.String(), action, action)
+	}
+}
+
+func (f *fakeAWS) groupIDs() []string {
+	ids := make([]string, 0, len(f.securityGroups))
+	for id := range f.securityGroups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (f *fakeAWS) writeError(w http.ResponseWriter, action, code, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+
+	if ec2Actions[action] {
+		fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>fake</RequestID></Response>", code, message)
+	} else {
+		fmt.Fprintf(w, "<ErrorResponse><Error><Code>%s</Code><Message>%s</Message></Error><RequestId>fake</RequestId></ErrorResponse>", code, message)
+	}
+}
+
+func fakeALBArn(name string) string {
+	return fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:loadbalancer/app/%s/fake", fakeRegion, fakeAccount, name)
+}
+
+// filterValue is the first value of the named ec2 filter
+func filterValue(r *http.Request, name string) string {
+	for i := 1; ; i++ {
+		n := r.Form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		if n == "" {
+			return ""
+		}
+
+		if n == name {
+			return r.Form.Get(fmt.Sprintf("Filter.%d.Value.1", i))
+		}
+	}
+}
+
+type fakeQueue struct {
+	url      string
+	arn      string
+	policy   string
+	messages []*sqs.Message
+}
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	mut     *sync.Mutex
+	queues  map[string]*fakeQueue
+	deleted []string
+}
+
+func newFakeSQS() *fakeSQS {
+	return &fakeSQS{
+		mut:    &sync.Mutex{},
+		queues: make(map[string]*fakeQueue),
+	}
+}
+
+func (f *fakeSQS) CreateQueue(input *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	name := aws.StringValue(input.QueueName)
+	url := fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", fakeRegion, fakeAccount, name)
+	if _, ok := f.queues[url]; !ok {
+		f.queues[url] = &fakeQueue{
+			url: url,
+			arn: fmt.Sprintf("arn:aws:sqs:%s:%s:%s", fakeRegion, fakeAccount, name),
+		}
+	}
+
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(url)}, nil
+}
+
+func (f *fakeSQS) queue(url *string) (*fakeQueue, error) {
+	q, ok := f.queues[aws.StringValue(url)]
+	if !ok {
+		return nil, fmt.Errorf("AWS.SimpleQueueService.NonExistentQueue: %s", aws.StringValue(url))
+	}
+
+	return q, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	q, err := f.queue(input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]*string{"QueueArn": aws.String(q.arn)},
+	}, nil
+}
+
+func (f *fakeSQS) SetQueueAttributes(input *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	q, err := f.queue(input.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	q.policy = aws.StringValue(input.Attributes["Policy"])
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+// ReceiveMessage waits a little for messages rather than the requested
+// long poll, messages are in flight until they're deleted
+func (f *fakeSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	deadline := time.Now().Add(100 * time.Millisecond)
+
+	for {
+		f.mut.Lock()
+		q, err := f.queue(input.QueueUrl)
+		if err != nil {
+			f.mut.Unlock()
+			return nil, err
+		}
+
+		if len(q.messages) > 0 || time.Now().After(deadline) {
+			messages := q.messages
+			q.messages = nil
+			f.mut.Unlock()
+
+			return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+		}
+
+		f.mut.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fakeSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.deleted = append(f.deleted, aws.StringValue(input.QueueUrl))
+	delete(f.queues, aws.StringValue(input.QueueUrl))
+
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+func (f *fakeSQS) send(queueARN, body string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	for _, q := range f.queues {
+		if q.arn == queueARN {
+			q.messages = append(q.messages, &sqs.Message{
+				MessageId:     aws.String(uuid.NewV4().String()),
+				ReceiptHandle: aws.String(uuid.NewV4().String()),
+				Body:          aws.String(body),
+			})
+		}
+	}
+}
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	sqs           *fakeSQS
+	mut           *sync.Mutex
+	subscriptions map[string][]string
+	deleted       []string
+}
+
+func newFakeSNS(sqsClient *fakeSQS) *fakeSNS {
+	return &fakeSNS{
+		sqs:           sqsClient,
+		mut:           &sync.Mutex{},
+		subscriptions: make(map[string][]string),
+	}
+}
+
+func (f *fakeSNS) CreateTopic(input *sns.CreateTopicInput) (*sns.CreateTopicOutput, error) {
+	return &sns.CreateTopicOutput{
+		TopicArn: aws.String(fmt.Sprintf("arn:aws:sns:%s:%s:%s", fakeRegion, fakeAccount, aws.StringValue(input.Name))),
+	}, nil
+}
+
+func (f *fakeSNS) Subscribe(input *sns.SubscribeInput) (*sns.SubscribeOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	topic := aws.StringValue(input.TopicArn)
+	f.subscriptions[topic] = append(f.subscriptions[topic], aws.StringValue(input.Endpoint))
+
+	return &sns.SubscribeOutput{SubscriptionArn: aws.String(topic + ":" + uuid.NewV4().String())}, nil
+}
+
+func (f *fakeSNS) DeleteTopic(input *sns.DeleteTopicInput) (*sns.DeleteTopicOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.deleted = append(f.deleted, aws.StringValue(input.TopicArn))
+	delete(f.subscriptions, aws.StringValue(input.TopicArn))
+
+	return &sns.DeleteTopicOutput{}, nil
+}
+
+// publish delivers the message to the topic's queues wrapped the way sns does
+func (f *fakeSNS) publish(topicARN, message string) {
+	f.mut.Lock()
+	queues := f.subscriptions[topicARN]
+	f.mut.Unlock()
+
+	body, _ := json.Marshal(map[string]string{
+		"Type":      "Notification",
+		"MessageId": uuid.NewV4().String(),
+		"TopicArn":  topicARN,
+		"Subject":   "AWS CloudFormation Notification",
+		"Message":   message,
+	})
+
+	for _, q := range queues {
+		f.sqs.send(q, string(body))
+	}
+}
+
+// fakeStackEvent is a stack event the fake publishes, an empty logical id
+// is the stack itself
+type fakeStackEvent struct {
+	LogicalID    string
+	ResourceType string
+	Status       string
+	Reason       string
+}
+
+type fakeStack struct {
+	id         string
+	name       string
+	status     string
+	reason     string
+	parameters []*cloudformation.Parameter
+	tags       []*cloudformation.Tag
+	topics     []*string
+}
+
+// fakeCloudFormation publishes its events to a stack's notification topics
+// once the stack is created. deleted stacks are gone by the time they're
+// described again.
+var stackUpdated = []fakeStackEvent{
+	{Status: "UPDATE_IN_PROGRESS", Reason: "User Initiated"},
+	{LogicalID: "BastionAutoScalingGroup", ResourceType: "AWS::AutoScaling::AutoScalingGroup", Status: "UPDATE_IN_PROGRESS"},
+	{LogicalID: "BastionAutoScalingGroup", ResourceType: "AWS::AutoScaling::AutoScalingGroup", Status: "UPDATE_COMPLETE"},
+	{Status: "UPDATE_COMPLETE_CLEANUP_IN_PROGRESS"},
+	{Status: "UPDATE_COMPLETE"},
+}
+
+var stackDeleted = []fakeStackEvent{
+	{Status: "DELETE_IN_PROGRESS", Reason: "User Initiated"},
+	{Status: "DELETE_COMPLETE"},
+}
+
+type fakeCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	sns       *fakeSNS
+	mut       *sync.Mutex
+	stacks    map[string]*fakeStack
+	deleted   []*fakeStack
+	events    []fakeStackEvent
+	createErr error
+	creates   int
+
+	// the events of stack updates, and their parameters
+	updateEvents []fakeStackEvent
+	updates      [][]*cloudformation.Parameter
+}
+
+func newFakeCloudFormation(snsClient *fakeSNS) *fakeCloudFormation {
+	return &fakeCloudFormation{
+		sns:    snsClient,
+		mut:    &sync.Mutex{},
+		stacks: make(map[string]*fakeStack),
+	}
+}
+
+func (f *fakeCloudFormation) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.creates++
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+
+	name := aws.StringValue(input.StackName)
+	if _, ok := f.stacks[name]; ok {
+		return nil, fmt.Errorf("AlreadyExistsException: Stack [%s] already exists", name)
+	}
+
+	stack := f.addStack(name, cloudformation.StackStatusCreateInProgress, "User Initiated")
+	stack.parameters = input.Parameters
+	stack.tags = input.Tags
+	stack.topics = input.NotificationARNs
+
+	go f.emit(stack, f.events)
+
+	return &cloudformation.CreateStackOutput{StackId: aws.String(stack.id)}, nil
+}
+
+// addStack is a stack that's already there, like one an earlier launch left behind
+func (f *fakeCloudFormation) addStack(name, status, reason string) *fakeStack {
+	stack := &fakeStack{
+		id:     fmt.Sprintf("arn:aws:cloudformation:%s:%s:stack/%s/%s", fakeRegion, fakeAccount, name, uuid.NewV4().String()),
+		name:   name,
+		status: status,
+		reason: reason,
+	}
+	f.stacks[name] = stack
+
+	return stack
+}
+
+func (f *fakeCloudFormation) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	statuses := make(map[string]bool)
+	for _, status := range input.StackStatusFilter {
+		statuses[aws.StringValue(status)] = true
+	}
+
+	output := &cloudformation.ListStacksOutput{}
+	for _, stack := range f.stacks {
+		if len(statuses) > 0 && !statuses[stack.status] {
+			continue
+		}
+
+		output.StackSummaries = append(output.StackSummaries, &cloudformation.StackSummary{
+			StackId:           aws.String(stack.id),
+			StackName:         aws.String(stack.name),
+			StackStatus:       aws.String(stack.status),
+			StackStatusReason: aws.String(stack.reason),
+		})
+	}
+
+	return output, nil
+}
+
+func (f *fakeCloudFormation) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	name := aws.StringValue(input.StackName)
+	for _, stack := range append(f.deleted, f.stackList()...) {
+		if stack.id == name || stack.name == name {
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackId:           aws.String(stack.id),
+						StackName:         aws.String(stack.name),
+						StackStatus:       aws.String(stack.status),
+						StackStatusReason: aws.String(stack.reason),
+						Parameters:        stack.parameters,
+					},
+				},
+			}, nil
+		}
+	}
+
+	return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", name), nil)
+}
+
+func (f *fakeCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	name := aws.StringValue(input.StackName)
+	for _, stack := range f.stackList() {
+		if stack.id == name || stack.name == name {
+			stack.status = cloudformation.StackStatusDeleteComplete
+			f.deleted = append(f.deleted, stack)
+			delete(f.stacks, stack.name)
+
+			go f.emit(stack, stackDeleted)
+		}
+	}
+
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+// UpdateStack keeps the stack's parameters, even when the update rolls back,
+// the parameters it was updated with are in updates
+func (f *fakeCloudFormation) UpdateStack(input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	name := aws.StringValue(input.StackName)
+	for _, stack := range f.stackList() {
+		if stack.id != name && stack.name != name {
+			continue
+		}
+
+		previous := make(map[string]*string)
+		for _, p := range stack.parameters {
+			previous[aws.StringValue(p.ParameterKey)] = p.ParameterValue
+		}
+
+		params := make([]*cloudformation.Parameter, len(input.Parameters))
+		for i, p := range input.Parameters {
+			params[i] = &cloudformation.Parameter{ParameterKey: p.ParameterKey, ParameterValue: p.ParameterValue}
+			if aws.BoolValue(p.UsePreviousValue) {
+				params[i].ParameterValue = previous[aws.StringValue(p.ParameterKey)]
+			}
+		}
+
+		f.updates = append(f.updates, params)
+		stack.topics = input.NotificationARNs
+		go f.emit(stack, f.updateEvents)
+
+		return &cloudformation.UpdateStackOutput{StackId: aws.String(stack.id)}, nil
+	}
+
+	return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", name), nil)
+}
+
+func (f *fakeCloudFormation) stackList() []*fakeStack {
+	stacks := make([]*fakeStack, 0, len(f.stacks))
+	for _, stack := range f.stacks {
+		stacks = append(stacks, stack)
+	}
+
+	return stacks
+}
+
+func (f *fakeCloudFormation) ListStackResources(input *cloudformation.ListStackResourcesInput) (*cloudformation.ListStackResourcesOutput, error) {
+	return &cloudformation.ListStackResourcesOutput{
+		StackResourceSummaries: []*cloudformation.StackResourceSummary{
+			{
+				LogicalResourceId:  aws.String("BastionSecurityGroup"),
+				PhysicalResourceId: aws.String("sg-bastion"),
+				ResourceType:       aws.String("AWS::EC2::SecurityGroup"),
+				ResourceStatus:     aws.String("CREATE_COMPLETE"),
+			},
+		},
+	}, nil
+}
+
+func (f *fakeCloudFormation) stack(name string) *fakeStack {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	return f.stacks[name]
+}
+
+func (f *fakeCloudFormation) emit(stack *fakeStack, events []fakeStackEvent) {
+	for _, e := range events {
+		logicalID, physicalID, resourceType := e.LogicalID, e.LogicalID, e.ResourceType
+		if logicalID == "" {
+			logicalID, physicalID, resourceType = stack.name, stack.id, "AWS::CloudFormation::Stack"
+
+			f.mut.Lock()
+			stack.status, stack.reason = e.Status, e.Reason
+			f.mut.Unlock()
+		}
+
+		// the stack notification format that parseCloudFormation reads
+		message := strings.Join([]string{
+			fmt.Sprintf("StackId='%s'", stack.id),
+			fmt.Sprintf("Timestamp='%s'", time.Now().UTC().Format(time.RFC3339)),
+			fmt.Sprintf("EventId='%s'", uuid.NewV4().String()),
+			fmt.Sprintf("LogicalResourceId='%s'", logicalID),
+			fmt.Sprintf("Namespace='%s'", fakeAccount),
+			fmt.Sprintf("PhysicalResourceId='%s'", physicalID),
+			"PrincipalId='AIDAFAKE'",
+			"ResourceProperties='null'",
+			fmt.Sprintf("ResourceStatus='%s'", e.Status),
+			fmt.Sprintf("ResourceStatusReason='%s'", e.Reason),
+			fmt.Sprintf("ResourceType='%s'", resourceType),
+			fmt.Sprintf("StackName='%s'", stack.name),
+			"",
+		}, "\n")
+
+		for _, topic := range stack.topics {
+			f.sns.publish(aws.StringValue(topic), message)
+		}
+	}
+}
+
+type fakeStore struct {
+	store.Store
+	mut      *sync.Mutex
+	bastions map[string]*com.Bastion
+	launches map[string]*store.Launch
+	events   []*store.LaunchEvent
+	versions map[string]string
+	reasons  map[string]string
+	tags     []*store.CustomerTag
+	policy   *store.AutocheckPolicy
+	// every password hash a bastion was saved with
+	hashes []string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		mut:      &sync.Mutex{},
+		bastions: make(map[string]*com.Bastion),
+		launches: make(map[string]*store.Launch),
+		versions: make(map[string]string),
+		reasons:  make(map[string]string),
+	}
+}
+
+func (s *fakeStore) PutBastion(bastion *com.Bastion) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	bastion.ID = uuid.NewV4().String()
+	b := *bastion
+	s.bastions[bastion.ID] = &b
+
+	return nil
+}
+
+func (s *fakeStore) UpdateBastion(bastion *com.Bastion) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.hashes = append(s.hashes, bastion.PasswordHash)
+	b := *bastion
+	s.bastions[bastion.ID] = &b
+
+	return nil
+}
+
+func (s *fakeStore) UpdateBastionTemplateVersion(bastionID, version string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.versions[bastionID] = version
+	return nil
+}
+
+func (s *fakeStore) UpdateBastionFailureReason(bastionID, reason string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.reasons[bastionID] = reason
+	return nil
+}
+
+func (s *fakeStore) UpdateTrackingState(bastionID, state string) error {
+	return nil
+}
+
+func (s *fakeStore) ListBastionUsers(customerID string) ([]*com.BastionUser, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) ListCustomerTags(customerID string) ([]*store.CustomerTag, error) {
+	return s.tags, nil
+}
+
+func (s *fakeStore) GetAutocheckPolicy(customerID string) (*store.AutocheckPolicy, error) {
+	if s.policy == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return s.policy, nil
+}
+
+func (s *fakeStore) PutLaunch(launch *store.Launch) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// like the database's index, a bastion only has one launch in progress
+	for _, other := range s.launches {
+		if other.ID != launch.ID && other.BastionID == launch.BastionID && other.State == stateInProgress && launch.State == stateInProgress {
+			return store.ErrLaunchInProgress
+		}
+	}
+
+	l := *launch
+	s.launches[launch.ID] = &l
+
+	return nil
+}
+
+// ClaimLaunches returns the launches as they were before they were claimed
+func (s *fakeStore) ClaimLaunches(request *store.ClaimLaunchesRequest) (*store.ListLaunchesResponse, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	claimed := make([]*store.Launch, 0)
+	for _, launch := range s.launches {
+		if launch.State != request.State {
+			continue
+		}
+
+		if launch.Owner != "" && time.Since(launch.Heartbeat) < request.Expiry {
+			continue
+		}
+
+		l := *launch
+		claimed = append(claimed, &l)
+
+		launch.Owner = request.Owner
+		launch.Heartbeat = time.Now()
+	}
+
+	return &store.ListLaunchesResponse{Launches: claimed}, nil
+}
+
+func (s *fakeStore) HeartbeatLaunches(owner, state string) (*store.ListLaunchesResponse, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	renewed := make([]*store.Launch, 0)
+	for _, launch := range s.launches {
+		if launch.Owner == owner && launch.State == state {
+			launch.Heartbeat = time.Now()
+			l := *launch
+			renewed = append(renewed, &l)
+		}
+	}
+
+	return &store.ListLaunchesResponse{Launches: renewed}, nil
+}
+
+// GetLaunch returns the bastion's most recent launch
+func (s *fakeStore) GetLaunch(request *store.GetLaunchRequest) (*store.Launch, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var latest *store.Launch
+	for _, launch := range s.launches {
+		if launch.BastionID != request.BastionID || launch.CustomerID != request.CustomerID {
+			continue
+		}
+
+		if latest == nil || launch.CreatedAt.After(latest.CreatedAt) {
+			latest = launch
+		}
+	}
+
+	if latest == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	l := *latest
+	return &l, nil
+}
+
+func (s *fakeStore) RequestLaunchCancel(id, state string) (bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	launch, ok := s.launches[id]
+	if !ok || launch.State != state {
+		return false, nil
+	}
+
+	launch.CancelRequested = true
+	return true, nil
+}
+
+func (s *fakeStore) GetBastion(request *store.GetBastionRequest) (*store.GetBastionResponse, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	bastion, ok := s.bastions[request.ID]
+	if !ok || (!request.AnyState && bastion.State != request.State) {
+		return nil, sql.ErrNoRows
+	}
+
+	b := *bastion
+	return &store.GetBastionResponse{Bastion: &b}, nil
+}
+
+func (s *fakeStore) PutLaunchEvent(event *store.LaunchEvent) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *fakeStore) bastion(id string) *com.Bastion {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.bastions[id]
+}
+
+func (s *fakeStore) launch(id string) *store.Launch {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.launches[id]
+}
+
+type fakeEtcd struct {
+	etcd.KeysAPI
+	value string
+}
+
+func (e *fakeEtcd) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	return &etcd.Response{
+		Node: &etcd.Node{Key: key, Value: e.value, ModifiedIndex: 1},
+	}, nil
+}
+
+type fakeRouter struct {
+	connected bool
+}
+
+func (r *fakeRouter) GetServices(bastion *com.Bastion) (map[string]interface{}, error) {
+	if !r.connected {
+		return map[string]interface{}{}, nil
+	}
+
+	return map[string]interface{}{"checker": "10.0.0.1:4000"}, nil
+}
+
+type fakeBezos struct{}
+
+func (b *fakeBezos) Get(ctx context.Context, in *service.BezosRequest, opts ...grpc.CallOption) (*service.BezosResponse, error) {
+	return &service.BezosResponse{}, nil
+}
+
+type fakeSink struct {
+	mut    *sync.Mutex
+	checks []*schema.Check
+}
+
+func (s *fakeSink) ListChecks() ([]*schema.Check, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.checks, nil
+}
+
+func (s *fakeSink) CreateCheck(check *schema.Check) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.checks = append(s.checks, check)
+	return nil
+}
+
+func (s *fakeSink) UpdateCheck(check *schema.Check) error {
+	return nil
+}
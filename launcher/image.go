@@ -0,0 +1,255 @@
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	ImageSourceEC2Tag   = "ec2-tag"
+	ImageSourceConfig   = "config"
+	ImageSourceCustomer = "customer-pin"
+
+	imageCacheTTL = 5 * time.Minute
+)
+
+// launches share a resolver so that they share its cache
+var defaultImageResolver = NewImageResolver()
+
+// ErrNoImage is returned by resolvers that have no image for a request,
+// so that the next resolver in a chain can be tried
+var ErrNoImage = errors.New("no bastion image found")
+
+// ImageRequest describes the bastion image a launch needs
+type ImageRequest struct {
+	Region        string
+	Tag           string
+	CustomerID    string
+	BastionConfig *BastionConfig
+}
+
+// Image is a resolved bastion AMI and the resolver it came from
+type Image struct {
+	ID     string
+	Source string
+}
+
+type ImageResolver interface {
+	Resolve(*ImageRequest) (*Image, error)
+}
+
+// NewImageResolver returns the resolver used for launches: a customer's pinned
+// image, then the config's image for the region, then the newest image tagged
+// with the release, cached for a few minutes.
+func NewImageResolver() ImageResolver {
+	return NewCachingImageResolver(
+		NewChainImageResolver(
+			NewCustomerImageResolver(),
+			NewConfigImageResolver(),
+			NewEC2TagImageResolver(),
+		),
+		imageCacheTTL,
+	)
+}
+
+type ImageList []*ec2.Image
+
+func (l ImageList) Len() int           { return len(l) }
+func (l ImageList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l ImageList) Less(i, j int) bool { return *l[i].CreationDate > *l[j].CreationDate }
+
+type ec2TagImageResolver struct {
+	// images are looked up with our own credentials unless a session is set
+	session *session.Session
+}
+
+// NewEC2TagImageResolver finds the newest public image owned by the config's
+// owner id and tagged with the requested release
+func NewEC2TagImageResolver() ImageResolver {
+	return &ec2TagImageResolver{}
+}
+
+func (r *ec2TagImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	sess := r.session
+	if sess == nil {
+		// We use our own access-key and secret-key here, because for whatever
+		// reason, customers can't find our AMIs like this even when they're public.
+		creds := credentials.NewChainCredentials(
+			[]credentials.Provider{
+				&ec2rolecreds.EC2RoleProvider{
+					Client: ec2metadata.New(session.New()),
+				},
+				&credentials.EnvProvider{},
+			},
+		)
+
+		sess = session.New(&aws.Config{
+			Credentials: creds,
+			MaxRetries:  aws.Int(11),
+		})
+	}
+
+	ec2client := ec2.New(sess, &aws.Config{Region: aws.String(req.Region)})
+
+	imageOutput, err := ec2client.DescribeImages(&ec2.DescribeImagesInput{
+		Owners: []*string{
+			aws.String(req.BastionConfig.OwnerID),
+		},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:release"),
+				Values: []*string{aws.String(req.Tag)},
+			},
+			{
+				Name:   aws.String("is-public"),
+				Values: []*string{aws.String("true")},
+			},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(imageOutput.Images) == 0 {
+		return nil, fmt.Errorf("No images with ownerID=%s and tag:release=%s found.", req.BastionConfig.OwnerID, req.Tag)
+	}
+
+	// sort in descending order
+	sort.Sort(ImageList(imageOutput.Images))
+
+	return &Image{
+		ID:     aws.StringValue(imageOutput.Images[0].ImageId),
+		Source: ImageSourceEC2Tag,
+	}, nil
+}
+
+type configImageResolver struct{}
+
+// NewConfigImageResolver uses the region's image from the bastion config.
+// The config's images are of the config's release, so requests for other
+// releases are left to the next resolver.
+func NewConfigImageResolver() ImageResolver {
+	return &configImageResolver{}
+}
+
+func (r *configImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	if req.Tag != "" && req.Tag != req.BastionConfig.Tag {
+		return nil, ErrNoImage
+	}
+
+	imageID, ok := req.BastionConfig.Images[req.Region]
+	if !ok {
+		return nil, ErrNoImage
+	}
+
+	return &Image{ID: imageID, Source: ImageSourceConfig}, nil
+}
+
+type customerImageResolver struct{}
+
+// NewCustomerImageResolver uses an image pinned for the customer and region
+// in the bastion config. Pins stand in for the config's release, requests for
+// other releases are an error rather than silently getting the pinned image.
+func NewCustomerImageResolver() ImageResolver {
+	return &customerImageResolver{}
+}
+
+func (r *customerImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	imageID, ok := req.BastionConfig.PinnedImages[req.CustomerID][req.Region]
+	if !ok {
+		return nil, ErrNoImage
+	}
+
+	if req.Tag != "" && req.Tag != req.BastionConfig.Tag {
+		return nil, fmt.Errorf("customer is pinned to image %s in %s, it can't get release %s", imageID, req.Region, req.Tag)
+	}
+
+	return &Image{ID: imageID, Source: ImageSourceCustomer}, nil
+}
+
+type chainImageResolver struct {
+	resolvers []ImageResolver
+}
+
+// NewChainImageResolver tries each resolver in turn until one has an image
+func NewChainImageResolver(resolvers ...ImageResolver) ImageResolver {
+	return &chainImageResolver{resolvers: resolvers}
+}
+
+func (r *chainImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	for _, resolver := range r.resolvers {
+		image, err := resolver.Resolve(req)
+		if err == ErrNoImage {
+			continue
+		}
+
+		return image, err
+	}
+
+	return nil, ErrNoImage
+}
+
+type cachedImage struct {
+	image   *Image
+	expires time.Time
+}
+
+type cachingImageResolver struct {
+	resolver ImageResolver
+	ttl      time.Duration
+	cache    map[string]cachedImage
+	mut      *sync.Mutex
+}
+
+// NewCachingImageResolver caches images from resolver for ttl. the cache is
+// keyed on the config's modified index too, so config changes apply at once.
+func NewCachingImageResolver(resolver ImageResolver, ttl time.Duration) ImageResolver {
+	return &cachingImageResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		cache:    make(map[string]cachedImage),
+		mut:      &sync.Mutex{},
+	}
+}
+
+func (r *cachingImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	key := fmt.Sprintf("%s/%s/%s/%d", req.Region, req.Tag, req.CustomerID, req.BastionConfig.ModifiedIndex)
+
+	r.mut.Lock()
+	cached, ok := r.cache[key]
+	r.mut.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.image, nil
+	}
+
+	image, err := r.resolver.Resolve(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.cache[key] = cachedImage{image: image, expires: time.Now().Add(r.ttl)}
+
+	// expired entries would otherwise stay around forever
+	for k, c := range r.cache {
+		if time.Now().After(c.expires) {
+			delete(r.cache, k)
+		}
+	}
+
+	return image, nil
+}
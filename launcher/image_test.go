@@ -0,0 +1,141 @@
+package launcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const imageCustomerID = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+
+func testImageConfig() *BastionConfig {
+	return &BastionConfig{
+		Tag:    "stable",
+		Images: map[string]string{"us-west-2": "ami-config"},
+		PinnedImages: map[string]map[string]string{
+			imageCustomerID: {"us-west-2": "ami-pinned"},
+		},
+	}
+}
+
+// fakeImageResolver counts its lookups, and has an image for every request
+type fakeImageResolver struct {
+	resolves int
+	err      error
+}
+
+func (r *fakeImageResolver) Resolve(req *ImageRequest) (*Image, error) {
+	r.resolves++
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &Image{ID: "ami-" + req.Tag, Source: ImageSourceEC2Tag}, nil
+}
+
+var resolveTests = []struct {
+	region     string
+	tag        string
+	customerID string
+	imageID    string
+	source     string
+	err        bool
+}{
+	{"us-west-2", "stable", imageCustomerID, "ami-pinned", ImageSourceCustomer, false},
+	{"us-west-2", "", imageCustomerID, "ami-pinned", ImageSourceCustomer, false},
+	{"us-west-2", "stable", "other", "ami-config", ImageSourceConfig, false},
+	{"us-west-2", "", "other", "ami-config", ImageSourceConfig, false},
+	// the config's images are of its release, other releases are looked up
+	{"us-west-2", "beta", "other", "ami-beta", ImageSourceEC2Tag, false},
+	{"us-east-1", "stable", imageCustomerID, "ami-stable", ImageSourceEC2Tag, false},
+	// a pinned customer can't be given another release
+	{"us-west-2", "beta", imageCustomerID, "", "", true},
+}
+
+func TestResolveImage(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range resolveTests {
+		resolver := NewChainImageResolver(
+			NewCustomerImageResolver(),
+			NewConfigImageResolver(),
+			&fakeImageResolver{},
+		)
+
+		image, err := resolver.Resolve(&ImageRequest{
+			Region:        tt.region,
+			Tag:           tt.tag,
+			CustomerID:    tt.customerID,
+			BastionConfig: testImageConfig(),
+		})
+
+		if tt.err {
+			assert.Error(err, "%s %s %s", tt.region, tt.tag, tt.customerID)
+			assert.Nil(image)
+			continue
+		}
+
+		if assert.NoError(err, "%s %s %s", tt.region, tt.tag, tt.customerID) {
+			assert.Equal(tt.imageID, image.ID, "%s %s %s", tt.region, tt.tag, tt.customerID)
+			assert.Equal(tt.source, image.Source, "%s %s %s", tt.region, tt.tag, tt.customerID)
+		}
+	}
+}
+
+func TestChainImageResolverNoImage(t *testing.T) {
+	assert := assert.New(t)
+
+	resolver := NewChainImageResolver(NewCustomerImageResolver(), NewConfigImageResolver())
+	_, err := resolver.Resolve(&ImageRequest{Region: "us-east-1", Tag: "stable", BastionConfig: testImageConfig()})
+	assert.Equal(ErrNoImage, err)
+}
+
+func TestCachingImageResolver(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		fake     = &fakeImageResolver{}
+		resolver = NewCachingImageResolver(fake, time.Minute).(*cachingImageResolver)
+		config   = testImageConfig()
+		req      = &ImageRequest{Region: "us-west-2", Tag: "stable", BastionConfig: config}
+	)
+
+	image, err := resolver.Resolve(req)
+	assert.NoError(err)
+	assert.Equal("ami-stable", image.ID)
+
+	_, err = resolver.Resolve(req)
+	assert.NoError(err)
+	assert.Equal(1, fake.resolves)
+
+	// releases are cached separately
+	image, err = resolver.Resolve(&ImageRequest{Region: "us-west-2", Tag: "beta", BastionConfig: config})
+	assert.NoError(err)
+	assert.Equal("ami-beta", image.ID)
+	assert.Equal(2, fake.resolves)
+
+	// config changes apply at once
+	config.ModifiedIndex++
+	_, err = resolver.Resolve(req)
+	assert.NoError(err)
+	assert.Equal(3, fake.resolves)
+
+	// and images expire
+	for key, cached := range resolver.cache {
+		cached.expires = time.Now().Add(-time.Second)
+		resolver.cache[key] = cached
+	}
+
+	_, err = resolver.Resolve(req)
+	assert.NoError(err)
+	assert.Equal(4, fake.resolves)
+	assert.Len(resolver.cache, 1)
+
+	// errors aren't cached
+	fake.err = errors.New("RequestLimitExceeded")
+	_, err = resolver.Resolve(&ImageRequest{Region: "us-east-1", Tag: "stable", BastionConfig: config})
+	assert.Error(err)
+	assert.Len(resolver.cache, 1)
+}
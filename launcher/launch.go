@@ -2,10 +2,16 @@ package launcher
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"text/template"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
@@ -22,17 +28,31 @@ import (
 	"github.com/opsee/keelhaul/config"
 	"github.com/opsee/keelhaul/router"
 	"github.com/opsee/keelhaul/store"
+	"github.com/opsee/keelhaul/templates"
 	log "github.com/opsee/logrus"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 )
 
 const (
 	commandLaunchBastion  = "launch-bastion"
 	commandConnectBastion = "connect-bastion"
 	commandDiscovery      = "discovery"
+	commandDeleteBastion  = "delete-bastion"
+	commandUpgradeBastion = "upgrade-bastion"
+	commandRotateKeys     = "rotate-keys"
+	commandPreflight      = "preflight-launch"
 
 	stateInProgress = "in-progress"
 	stateComplete   = "complete"
 	stateFailed     = "failed"
+	stateCancelled  = "cancelled"
+
+	maxResumeAge = time.Hour
+
+	stackTimeout     = 45 * time.Minute
+	connectTimeout   = 30 * time.Minute
+	discoveryTimeout = 15 * time.Minute
 
 	userdata = `#cloud-config
 write_files:
@@ -56,8 +76,8 @@ write_files:
   - name: "{{ .Username }}"
     groups:
       - "sudo"
-    ssh-authorized-keys:
-      - "{{ .Key }}{{ end }}{{ end }}"
+    ssh-authorized-keys:{{ range .Keys }}
+      - "{{ . }}"{{ end }}{{ end }}{{ end }}
 coreos:
   units:
     - name: "docker.service"
@@ -77,7 +97,11 @@ coreos:
 `
 )
 
-var userdataTmpl = template.Must(template.New("userdata").Parse(userdata))
+var (
+	userdataTmpl = template.Must(template.New("userdata").Parse(userdata))
+
+	errLaunchCancelled = errors.New("launch cancelled")
+)
 
 type BastionConfig struct {
 	OwnerID       string `json:"owner_id"`
@@ -89,13 +113,27 @@ type BastionConfig struct {
 	BartnetHost   string `json:"bartnet_host"`
 	AuthType      string `json:"auth_type"`
 	ModifiedIndex uint64 `json:"modified_index"`
+
+	// stack defaults, customers can pick their own key pair or allow ssh
+	AllowSSH           bool   `json:"allow_ssh"`
+	IngressTemplateURL string `json:"ingress_template_url"`
+
+	// region -> ami
+	Images map[string]string `json:"images"`
+
+	// customer id -> region -> ami
+	PinnedImages map[string]map[string]string `json:"pinned_images"`
+
+	// customer id -> whether their instances get autochecks
+	EC2Autochecks map[string]bool `json:"ec2_autochecks"`
 }
 
 type Stage interface {
-	Execute(*Launch)
+	Execute(context.Context, *Launch) error
 }
 
 type Launch struct {
+	ID                        string
 	Bastion                   *com.Bastion
 	User                      *schema.User
 	Autochecks                *autocheck.Pool
@@ -106,8 +144,18 @@ type Launch struct {
 	ImageID                   string
 	ImageTag                  string
 	InstanceType              string
-	state                     int
+	KeyName                   string
+	AllowSSH                  bool
+	SubnetIDs                 []string
+	command                   string
+	owner                     string
+	done                      bool
 	stateMut                  *sync.RWMutex
+	ctx                       context.Context
+	cancel                    context.CancelFunc
+	cancelOnce                *sync.Once
+	lastStage                 string
+	checkpointMut             *sync.Mutex
 	session                   *session.Session
 	logger                    *log.Entry
 	db                        store.Store
@@ -117,6 +165,7 @@ type Launch struct {
 	bezos                     service.BezosClient
 	config                    *config.Config
 	bastionConfig             *BastionConfig
+	images                    ImageResolver
 	sqsClient                 sqsiface.SQSAPI
 	snsClient                 snsiface.SNSAPI
 	cloudformationClient      cloudformationiface.CloudFormationAPI
@@ -126,6 +175,9 @@ type Launch struct {
 	getQueueAttributesOutput  *sqs.GetQueueAttributesOutput
 	setQueueAttributesOutput  *sqs.SetQueueAttributesOutput
 	createStackOutput         *cloudformation.CreateStackOutput
+	deleteStackOutput         *cloudformation.DeleteStackOutput
+	updateStackOutput         *cloudformation.UpdateStackOutput
+	previousPasswordHash      string
 	connectAttempts           float64
 }
 
@@ -135,15 +187,17 @@ type Event struct {
 }
 
 type VPCEnvironment struct {
-	SecurityGroupCount    int   `json:"security_group_count"`
-	DBSecurityGroupCount  int   `json:"db_security_group_count"`
-	LoadBalancerCount     int   `json:"load_balancer_count"`
-	AutoscalingGroupCount int   `json:"autoscaling_group_count"`
-	InstanceCount         int   `json:"instance_count"`
-	DBInstanceCount       int   `json:"db_instance_count"`
-	GroupErrorCount       int   `json:"group_error_count"`
-	InstanceErrorCount    int   `json:"instance_error_count"`
-	LastError             error `json:"last_error"`
+	SecurityGroupCount           int   `json:"security_group_count"`
+	DBSecurityGroupCount         int   `json:"db_security_group_count"`
+	LoadBalancerCount            int   `json:"load_balancer_count"`
+	ApplicationLoadBalancerCount int   `json:"application_load_balancer_count"`
+	TargetGroupCount             int   `json:"target_group_count"`
+	AutoscalingGroupCount        int   `json:"autoscaling_group_count"`
+	InstanceCount                int   `json:"instance_count"`
+	DBInstanceCount              int   `json:"db_instance_count"`
+	GroupErrorCount              int   `json:"group_error_count"`
+	InstanceErrorCount           int   `json:"instance_error_count"`
+	LastError                    error `json:"last_error"`
 }
 
 func NewLaunch(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, spanx service.SpanxClient, bezos service.BezosClient, cfg *config.Config, sess *session.Session, user *schema.User) *Launch {
@@ -152,13 +206,20 @@ func NewLaunch(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, span
 		"user_id":     user.Id,
 	})
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Launch{
+		ID:                   uuid.NewV4().String(),
 		User:                 user,
 		EventChan:            make(chan *Event),
 		VPCEnvironment:       &VPCEnvironment{},
 		Autochecks:           autocheck.NewPool(autocheck.NewBartnetSink(cfg.BartnetEndpoint, cfg.HugsEndpoint, user), logger),
-		state:                3,
+		command:              commandLaunchBastion,
 		stateMut:             &sync.RWMutex{},
+		ctx:                  ctx,
+		cancel:               cancel,
+		cancelOnce:           &sync.Once{},
+		checkpointMut:        &sync.Mutex{},
 		db:                   db,
 		router:               router,
 		etcd:                 etcdKAPI,
@@ -169,6 +230,7 @@ func NewLaunch(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, span
 		sqsClient:            sqs.New(sess),
 		snsClient:            sns.New(sess),
 		cloudformationClient: cloudformation.New(sess),
+		images:               defaultImageResolver,
 		logger:               logger,
 		connectAttempts:      float64(1),
 	}
@@ -177,19 +239,22 @@ func NewLaunch(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, span
 func (launch *Launch) NotifyVars() interface{} {
 	vars := struct {
 		*VPCEnvironment
-		Error        string `json:"error"`
-		UserID       int    `json:"user_id"`
-		UserEmail    string `json:"user_email"`
-		CustomerID   string `json:"customer_id"`
-		Region       string `json:"region"`
-		ImageID      string `json:"image_id"`
-		VPCID        string `json:"vpc_id"`
-		SubnetID     string `json:"subnet_id"`
-		InstanceID   string `json:"instance_id"`
-		GroupID      string `json:"group_id"`
-		InstanceName string `json:"instance_name"`
-		GroupName    string `json:"group_name"`
-		CheckCount   int    `json:"check_count"`
+		Error         string `json:"error"`
+		UserID        int    `json:"user_id"`
+		UserEmail     string `json:"user_email"`
+		CustomerID    string `json:"customer_id"`
+		Region        string `json:"region"`
+		ImageID       string `json:"image_id"`
+		VPCID         string `json:"vpc_id"`
+		SubnetID      string `json:"subnet_id"`
+		InstanceID    string `json:"instance_id"`
+		GroupID       string `json:"group_id"`
+		InstanceName  string `json:"instance_name"`
+		GroupName     string `json:"group_name"`
+		CheckCount    int    `json:"check_count"`
+		ChecksCreated int    `json:"checks_created"`
+		ChecksUpdated int    `json:"checks_updated"`
+		ChecksSkipped int    `json:"checks_skipped"`
 	}{
 		VPCEnvironment: launch.VPCEnvironment,
 		UserID:         int(launch.User.Id),
@@ -204,6 +269,9 @@ func (launch *Launch) NotifyVars() interface{} {
 		InstanceName:   "Opsee Instance",
 		GroupName:      "Opsee Instance Security Group",
 		CheckCount:     launch.Autochecks.SuccessCount(),
+		ChecksCreated:  launch.Autochecks.CreatedCount(),
+		ChecksUpdated:  launch.Autochecks.UpdatedCount(),
+		ChecksSkipped:  launch.Autochecks.SkippedCount(),
 	}
 
 	if launch.Err != nil {
@@ -245,22 +313,327 @@ func (launch *Launch) CreateBastion(executionGroupId, region, vpcID, subnetID, s
 	return nil
 }
 
+// queueStages follow a stack's events through an sns topic and sqs queue
+func queueStages() []*stageSpec {
+	return []*stageSpec{
+		{
+			Stage:    createTopic{},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		{
+			Stage:    createQueue{},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		{
+			Stage:    getQueueAttributes{},
+			After:    []Stage{createQueue{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		{
+			Stage:    setQueueAttributes{},
+			After:    []Stage{createTopic{}, getQueueAttributes{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		{
+			Stage:    subscribe{},
+			After:    []Stage{setQueueAttributes{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+	}
+}
+
+var (
+	launchWorkflow = newWorkflow(append(queueStages(),
+		&stageSpec{
+			Stage:    getBastionConfig{},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+			Rerun:    true,
+		},
+		&stageSpec{
+			Stage:    getLatestImageID{},
+			After:    []Stage{getBastionConfig{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    validateKeyPair{},
+			After:    []Stage{getBastionConfig{}},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    recoverStacks{},
+			Timeout:  stackTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    createStack{},
+			After:    []Stage{getLatestImageID{}, validateKeyPair{}, subscribe{}, recoverStacks{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    bastionLaunchingState{},
+			After:    []Stage{createStack{}},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    launchStackMessages,
+			After:    []Stage{bastionLaunchingState{}},
+			Timeout:  stackTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    bastionActiveState{},
+			After:    []Stage{launchStackMessages},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    waitConnect{},
+			After:    []Stage{bastionActiveState{}},
+			Timeout:  connectTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    vpcDiscovery{},
+			After:    []Stage{getBastionConfig{}, bastionActiveState{}},
+			Timeout:  discoveryTimeout,
+			Required: true,
+		},
+	)...)
+
+	deleteWorkflow = newWorkflow(append(queueStages(),
+		&stageSpec{
+			Stage:    bastionDisabledState{},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    deleteStack{},
+			After:    []Stage{bastionDisabledState{}, subscribe{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    waitStackDelete{},
+			After:    []Stage{deleteStack{}},
+			Timeout:  stackTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    bastionDeletedState{},
+			After:    []Stage{waitStackDelete{}},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+	)...)
+
+	upgradeWorkflow = newWorkflow(append(queueStages(),
+		&stageSpec{
+			Stage:    getBastionConfig{},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+			Rerun:    true,
+		},
+		&stageSpec{
+			Stage:    getLatestImageID{},
+			After:    []Stage{getBastionConfig{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    updateStack{},
+			After:    []Stage{getLatestImageID{}, subscribe{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    updateStackMessages,
+			After:    []Stage{updateStack{}},
+			Timeout:  stackTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    bastionUpgradedState{},
+			After:    []Stage{updateStackMessages},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+	)...)
+
+	rotateKeysWorkflow = newWorkflow(append(queueStages(),
+		&stageSpec{
+			Stage:    getBastionConfig{},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+			Rerun:    true,
+		},
+		&stageSpec{
+			Stage:    currentImageID{},
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    updateStack{},
+			After:    []Stage{getBastionConfig{}, currentImageID{}, subscribe{}},
+			Timeout:  time.Minute,
+			Retry:    shortRetry,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    updateStackMessages,
+			After:    []Stage{updateStack{}},
+			Timeout:  stackTimeout,
+			Required: true,
+		},
+		&stageSpec{
+			Stage:    bastionUpgradedState{},
+			After:    []Stage{updateStackMessages},
+			Timeout:  time.Minute,
+			Required: true,
+		},
+	)...)
+)
+
+// Launch creates the bastion's stack and waits for the bastion to connect,
+// discovering the vpc's environment meanwhile
 func (launch *Launch) Launch(imageTag string) {
 	launch.ImageTag = imageTag
-	launch.stage(getBastionConfig{})
-	launch.stage(getLatestImageID{})
-	launch.stage(createTopic{})
-	launch.stage(createQueue{})
-	launch.stage(getQueueAttributes{})
-	launch.stage(setQueueAttributes{})
-	launch.stage(subscribe{})
-	launch.stage(createStack{})
-	launch.stage(bastionLaunchingState{})
-	launch.stage(consumeSQS{})
-	launch.stage(bastionActiveState{})
-	go launch.stage(vpcDiscovery{})
-	go launch.stage(waitConnect{})
-	//TODO add stage post launch
+	launch.run(launchWorkflow, nil)
+}
+
+// Delete tears down the bastion's cloudformation stack, following the stack
+// events the same way a launch does
+func (launch *Launch) Delete() {
+	launch.run(deleteWorkflow, nil)
+}
+
+// Upgrade moves the bastion to the latest image for imageTag by updating its
+// cloudformation stack in place, the stack's rolling update policy replaces
+// the instance. If the update rolls back the bastion keeps its old image.
+func (launch *Launch) Upgrade(imageTag string) {
+	launch.ImageTag = imageTag
+	launch.run(upgradeWorkflow, nil)
+}
+
+// RotateKeys updates the bastion's stack with freshly rendered userdata, so that
+// it picks up the customer's current ssh users. It keeps the bastion's image.
+func (launch *Launch) RotateKeys() {
+	launch.run(rotateKeysWorkflow, nil)
+}
+
+// Resume continues a launch interrupted by a restart after the last stage it
+// checkpointed, restoring the stage outputs that were persisted along the way.
+// Deletes are idempotent, so they simply start over.
+func (launch *Launch) Resume(record *store.Launch) {
+	launch.ImageTag = record.ImageTag
+	launch.ImageID = record.ImageID
+	launch.previousPasswordHash = record.PasswordHash
+	launch.KeyName = record.KeyName
+	launch.AllowSSH = record.AllowSSH
+	if record.SubnetIDs != "" {
+		launch.SubnetIDs = strings.Split(record.SubnetIDs, ",")
+	}
+	launch.lastStage = record.Stage
+
+	if record.TopicARN != "" {
+		launch.createTopicOutput = &sns.CreateTopicOutput{TopicArn: aws.String(record.TopicARN)}
+	}
+
+	if record.QueueURL != "" {
+		launch.createQueueOutput = &sqs.CreateQueueOutput{QueueUrl: aws.String(record.QueueURL)}
+	}
+
+	if record.QueueARN != "" {
+		launch.getQueueAttributesOutput = &sqs.GetQueueAttributesOutput{
+			Attributes: map[string]*string{"QueueArn": aws.String(record.QueueARN)},
+		}
+	}
+
+	if record.StackID != "" {
+		launch.createStackOutput = &cloudformation.CreateStackOutput{StackId: aws.String(record.StackID)}
+	}
+
+	// the sqs messages we'd need are long gone by now
+	if time.Since(record.UpdatedAt) > maxResumeAge {
+		launch.error(fmt.Errorf("launch last updated at %s", record.UpdatedAt), &bus.Message{
+			Command: launch.command,
+			Message: "launch is too old to resume",
+		})
+		return
+	}
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("resuming after stage: %s", record.Stage),
+	})
+
+	switch launch.command {
+	case commandLaunchBastion:
+		done := launchWorkflow.completed(record.Stage)
+
+		// the vpn password is only kept in memory, but until the stack
+		// exists nothing is using it, so the bastion can get a new one
+		if !done[stageName(createStack{})] {
+			password, passwordHash, err := generatePassword()
+			if err != nil {
+				launch.error(err, &bus.Message{
+					Command: launch.command,
+					Message: "failed creating bastion credentials",
+				})
+				return
+			}
+
+			launch.Bastion.Password = password
+			launch.Bastion.PasswordHash = passwordHash
+			if err = launch.db.UpdateBastion(launch.Bastion); err != nil {
+				launch.error(err, &bus.Message{
+					Command: launch.command,
+					Message: "failed saving bastion object",
+				})
+				return
+			}
+		}
+
+		launch.run(launchWorkflow, done)
+
+	case commandUpgradeBastion:
+		launch.run(upgradeWorkflow, upgradeWorkflow.completed(record.Stage))
+
+	case commandRotateKeys:
+		launch.run(rotateKeysWorkflow, rotateKeysWorkflow.completed(record.Stage))
+
+	case commandDeleteBastion:
+		launch.Delete()
+
+	default:
+		launch.error(fmt.Errorf("unknown launch command: %s", launch.command), &bus.Message{
+			Command: launch.command,
+			Message: "failed resuming launch",
+		})
+	}
 }
 
 func (launch *Launch) State() string {
@@ -271,33 +644,93 @@ func (launch *Launch) State() string {
 		return stateFailed
 	}
 
-	if launch.state > 0 {
+	if !launch.done {
 		return stateInProgress
 	}
 
 	return stateComplete
 }
 
+// stackName is the name of the bastion's cloudformation stack, which also
+// names its sns topic. bastions launched before stacks were named per bastion
+// keep the per customer name, which we find in their stack id.
+func (launch *Launch) stackName() string {
+	if launch.Bastion.StackID.Valid {
+		// arn:aws:cloudformation:<region>:<account>:stack/<name>/<id>
+		parts := strings.Split(launch.Bastion.StackID.String, "/")
+		if len(parts) == 3 {
+			return parts[1]
+		}
+	}
+
+	return launch.Bastion.StackName()
+}
+
+// ingressTemplateURL is the launch's ingress template url, or the config's,
+// or else the default the bastion template declares
+func (launch *Launch) ingressTemplateURL() string {
+	if launch.BastionIngressTemplateURL != "" {
+		return launch.BastionIngressTemplateURL
+	}
+
+	if launch.bastionConfig.IngressTemplateURL != "" {
+		return launch.bastionConfig.IngressTemplateURL
+	}
+
+	url, _ := templates.Bastion.Parameters["BastionIngressTemplateUrl"].Default.(string)
+	return url
+}
+
+// opseeBastionUser is on every bastion, the customer's own users are added to it
+var opseeBastionUser = &com.BastionUser{
+	"opsee",
+	"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAACAQDP+VmyztGmJJTe6YtMtrKazGy3tQC/Pku156Ae10TMzCjvtiol+eL11FKyvNvlENM5EWwIQEng5w3J616kRa92mWr9OWALBn4HJZcztS2YLAXyiC+GLauil6W6xnGzS0DmU5RiYSSPSrmQEwHvmO2umbG190srdaDn/ZvAwptC1br/zc/7ya3XqxHugw1V9kw+KXzTWSC95nPkhOFoaA3nLcMvYWfoTbsU/G08qQy8medqyK80LJJntedpFAYPUrVdGY2J7F2y994YLfapPGzDjM7nR0sRWAZbFgm/BSD0YM8KA0mfGZuKPwKSLMtTUlsmv3l6GJl5a7TkyOlK3zzYtVGO6dnHdZ3X19nldreE3DywpjDrKIfYF2L42FKnpTGFgvunsg9vPdYOiJyIfk6lYsGE6h451OAmV0dxeXhtbqpw4/DsSHtLm5kKjhjRwunuQXEg8SfR3kesJjq6rmhCjLc7bIKm3rSU07zbXSR40JHO1Mc9rqzg2bCk3inJmCKWbMnDvWU1RD475eATEKoG/hv0/7EOywDnFe1m4yi6yZh7XlvakYsxDBPO9/FMlZm2T+cn+TyTmDiw9tEAIEAEiiu18CUNIii1em7XtFDmXjGFWfvteQG/2A98/uDGbmlXd64F2OtU/ulDRJXFGaji8tqxQ/To+2zIeIptLjtqBw==",
+}
+
+type userdataUser struct {
+	Username string
+	Keys     []string
+}
+
+// groupBastionUsers collects the keys of each user, which are stored one per entry
+func groupBastionUsers(bastionUsers []*com.BastionUser) []*userdataUser {
+	users := make([]*userdataUser, 0, len(bastionUsers))
+	byName := make(map[string]*userdataUser)
+
+	for _, bu := range bastionUsers {
+		u, ok := byName[bu.Username]
+		if !ok {
+			u = &userdataUser{Username: bu.Username}
+			byName[bu.Username] = u
+			users = append(users, u)
+		}
+
+		u.Keys = append(u.Keys, bu.Key)
+	}
+
+	return users
+}
+
 func (launch *Launch) GenerateUserData() ([]byte, error) {
+	bastionUsers, err := launch.db.ListBastionUsers(launch.User.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
 	buf := bytes.NewBuffer([]byte{})
 	var ud = struct {
 		User         *schema.User
-		BastionUsers []*com.BastionUser
+		BastionUsers []*userdataUser
 		Config       *BastionConfig
 		Bastion      *com.Bastion
 	}{
 		launch.User,
-		[]*com.BastionUser{
-			{
-				"opsee",
-				"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAACAQDP+VmyztGmJJTe6YtMtrKazGy3tQC/Pku156Ae10TMzCjvtiol+eL11FKyvNvlENM5EWwIQEng5w3J616kRa92mWr9OWALBn4HJZcztS2YLAXyiC+GLauil6W6xnGzS0DmU5RiYSSPSrmQEwHvmO2umbG190srdaDn/ZvAwptC1br/zc/7ya3XqxHugw1V9kw+KXzTWSC95nPkhOFoaA3nLcMvYWfoTbsU/G08qQy8medqyK80LJJntedpFAYPUrVdGY2J7F2y994YLfapPGzDjM7nR0sRWAZbFgm/BSD0YM8KA0mfGZuKPwKSLMtTUlsmv3l6GJl5a7TkyOlK3zzYtVGO6dnHdZ3X19nldreE3DywpjDrKIfYF2L42FKnpTGFgvunsg9vPdYOiJyIfk6lYsGE6h451OAmV0dxeXhtbqpw4/DsSHtLm5kKjhjRwunuQXEg8SfR3kesJjq6rmhCjLc7bIKm3rSU07zbXSR40JHO1Mc9rqzg2bCk3inJmCKWbMnDvWU1RD475eATEKoG/hv0/7EOywDnFe1m4yi6yZh7XlvakYsxDBPO9/FMlZm2T+cn+TyTmDiw9tEAIEAEiiu18CUNIii1em7XtFDmXjGFWfvteQG/2A98/uDGbmlXd64F2OtU/ulDRJXFGaji8tqxQ/To+2zIeIptLjtqBw==",
-			},
-		},
+		groupBastionUsers(append([]*com.BastionUser{opseeBastionUser}, bastionUsers...)),
 		launch.bastionConfig,
 		launch.Bastion,
 	}
 
-	err := userdataTmpl.Execute(buf, ud)
+	err = userdataTmpl.Execute(buf, ud)
 	if err != nil {
 		return nil, err
 	}
@@ -305,12 +738,158 @@ func (launch *Launch) GenerateUserData() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (launch *Launch) stage(st Stage) {
-	if launch.State() == stateFailed {
+// Cancel stops the launch's running stages, the workflow then winds the
+// launch down once they've returned
+func (launch *Launch) Cancel() {
+	launch.cancel()
+}
+
+func (launch *Launch) cancelled() {
+	launch.cancelStack()
+
+	msg := &bus.Message{
+		State:   stateCancelled,
+		Command: launch.command,
+		Message: "launch cancelled",
+	}
+
+	launch.loggerWithAttributes(msg.Attributes).Info(
+		fmt.Sprintf("[%s](%s): %s", msg.Command, msg.State, msg.Message),
+	)
+
+	launch.handleEvent(&Event{Err: errLaunchCancelled, Message: msg})
+}
+
+// cancelStack stops whatever the launch was doing to its cloudformation stack,
+// new stacks are deleted and updates are rolled back. deletes can't be cancelled.
+func (launch *Launch) cancelStack() {
+	var err error
+
+	switch launch.command {
+	case commandLaunchBastion:
+		if launch.createStackOutput == nil {
+			return
+		}
+
+		_, err = launch.cloudformationClient.DeleteStack(&cloudformation.DeleteStackInput{
+			StackName: launch.createStackOutput.StackId,
+		})
+
+	case commandUpgradeBastion, commandRotateKeys:
+		if launch.updateStackOutput == nil {
+			return
+		}
+
+		_, err = launch.cloudformationClient.CancelUpdateStack(&cloudformation.CancelUpdateStackInput{
+			StackName: aws.String(launch.stackName()),
+		})
+
+	default:
 		return
 	}
 
-	st.Execute(launch)
+	if err != nil {
+		launch.logger.WithError(err).Error("failed to stop cloudformation stack")
+	} else {
+		launch.logger.Info("stopped cloudformation stack")
+	}
+}
+
+// setOutput saves a stage's output under the checkpoint lock, checkpoints
+// are taken while other stages are still running
+func (launch *Launch) setOutput(set func()) {
+	launch.checkpointMut.Lock()
+	defer launch.checkpointMut.Unlock()
+
+	set()
+}
+
+// checkpoint persists the launch's progress so that it can be resumed
+// if keelhaul restarts before it is finished
+func (launch *Launch) checkpoint(stage, state string) {
+	if launch.Bastion == nil {
+		return
+	}
+
+	launch.checkpointMut.Lock()
+	defer launch.checkpointMut.Unlock()
+
+	if stage != "" {
+		launch.lastStage = stage
+	}
+
+	record, err := launch.record(state)
+	if err != nil {
+		launch.logger.WithError(err).Error("failed to marshal launch user")
+		return
+	}
+
+	err = launch.db.PutLaunch(record)
+	if err != nil {
+		launch.logger.WithError(err).Error("failed to persist launch progress")
+	}
+}
+
+// begin persists the launch before it starts, which fails with
+// store.ErrLaunchInProgress when its bastion already has a launch in progress
+func (launch *Launch) begin() error {
+	launch.checkpointMut.Lock()
+	defer launch.checkpointMut.Unlock()
+
+	record, err := launch.record(stateInProgress)
+	if err != nil {
+		return err
+	}
+
+	return launch.db.PutLaunch(record)
+}
+
+// record is the launch as it's persisted, callers hold the checkpoint lock
+func (launch *Launch) record(state string) (*store.Launch, error) {
+	userJSON, err := json.Marshal(launch.User)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &store.Launch{
+		ID:           launch.ID,
+		BastionID:    launch.Bastion.ID,
+		CustomerID:   launch.User.CustomerId,
+		Command:      launch.command,
+		Stage:        launch.lastStage,
+		State:        state,
+		Region:       aws.StringValue(launch.session.Config.Region),
+		UserJSON:     userJSON,
+		ImageTag:     launch.ImageTag,
+		ImageID:      launch.ImageID,
+		PasswordHash: launch.previousPasswordHash,
+		KeyName:      launch.KeyName,
+		AllowSSH:     launch.AllowSSH,
+		SubnetIDs:    strings.Join(launch.SubnetIDs, ","),
+		Owner:        launch.owner,
+	}
+
+	if launch.createTopicOutput != nil {
+		record.TopicARN = aws.StringValue(launch.createTopicOutput.TopicArn)
+	}
+
+	if launch.createQueueOutput != nil {
+		record.QueueURL = aws.StringValue(launch.createQueueOutput.QueueUrl)
+	}
+
+	if launch.getQueueAttributesOutput != nil {
+		record.QueueARN = aws.StringValue(launch.getQueueAttributesOutput.Attributes["QueueArn"])
+	}
+
+	if launch.createStackOutput != nil {
+		record.StackID = aws.StringValue(launch.createStackOutput.StackId)
+	}
+
+	return record, nil
+}
+
+func stageName(st Stage) string {
+	return reflect.TypeOf(st).Name()
 }
 
 func (launch *Launch) handleEvent(event *Event) {
@@ -323,6 +902,7 @@ func (launch *Launch) handleEvent(event *Event) {
 	}
 
 	if launch.EventChan != nil {
+		launch.recordEvent(event)
 		launch.EventChan <- event
 	}
 
@@ -330,12 +910,60 @@ func (launch *Launch) handleEvent(event *Event) {
 		launch.Err = event.Err
 	}
 
-	if event.Message.State == stateComplete {
-		launch.state = launch.state - 1
+	// stages still running after cleanup can't clean up twice
+	if launch.Err != nil && launch.EventChan != nil {
+		launch.cleanup()
 	}
+}
 
-	if launch.state == 0 || launch.Err != nil {
-		launch.cleanup()
+// finish completes the launch once its workflow has
+func (launch *Launch) finish() {
+	launch.stateMut.Lock()
+	defer launch.stateMut.Unlock()
+
+	if launch.Err != nil || launch.EventChan == nil {
+		return
+	}
+
+	launch.done = true
+	launch.cleanup()
+}
+
+// recordEvent saves the event to the bastion's history, so that failed launches
+// can be looked into after the fact
+func (launch *Launch) recordEvent(event *Event) {
+	msg := event.Message
+	if msg.BastionID == "" {
+		return
+	}
+
+	attrs := make(map[string]interface{}, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+
+	if event.Err != nil {
+		attrs["error"] = event.Err.Error()
+	}
+
+	attributes, err := json.Marshal(attrs)
+	if err != nil {
+		launch.logger.WithError(err).Error("failed to marshal event attributes")
+		attributes = nil
+	}
+
+	err = launch.db.PutLaunchEvent(&store.LaunchEvent{
+		LaunchID:   launch.ID,
+		BastionID:  msg.BastionID,
+		CustomerID: msg.CustomerID,
+		Command:    msg.Command,
+		State:      msg.State,
+		Message:    msg.Message,
+		Attributes: attributes,
+	})
+
+	if err != nil {
+		launch.logger.WithError(err).Error("failed to record launch event")
 	}
 }
 
@@ -374,9 +1002,23 @@ func (launch *Launch) cleanup() {
 		// but only for non-global bastions
 		if launch.User.CustomerId != MagicExgid {
 			launch.Autochecks.Drain()
+			launch.logger.WithFields(log.Fields{
+				"created": launch.Autochecks.CreatedCount(),
+				"updated": launch.Autochecks.UpdatedCount(),
+				"skipped": launch.Autochecks.SkippedCount(),
+			}).Info("synced autochecks")
 		}
 	}
 
+	switch launch.Err {
+	case nil:
+		launch.checkpoint("", stateComplete)
+	case errLaunchCancelled:
+		launch.checkpoint("", stateCancelled)
+	default:
+		launch.checkpoint("", stateFailed)
+	}
+
 	var err error
 	if launch.createTopicOutput != nil {
 		_, err = launch.snsClient.DeleteTopic(&sns.DeleteTopicInput{
@@ -402,7 +1044,22 @@ func (launch *Launch) cleanup() {
 		}
 	}
 
-	if launch.Err != nil && launch.Bastion != nil {
+	// the bastion's new vpn password was saved when its stack update was
+	// issued, a failed update rolls the instance back to the old one
+	if launch.Err != nil && launch.previousPasswordHash != "" &&
+		(launch.command == commandUpgradeBastion || launch.command == commandRotateKeys) {
+		launch.Bastion.PasswordHash = launch.previousPasswordHash
+		err = launch.db.UpdateBastion(launch.Bastion)
+
+		if err != nil {
+			launch.logger.WithError(err).Error("failed to restore bastion password")
+		} else {
+			launch.logger.Info("restored bastion password")
+		}
+	}
+
+	// a failed teardown leaves the bastion disabled, only launches fail it
+	if launch.Err != nil && launch.Bastion != nil && launch.command == commandLaunchBastion {
 		err = launch.db.UpdateBastion(launch.Bastion.Fail())
 
 		if err != nil {
@@ -0,0 +1,770 @@
+package launcher
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/autocheck"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/config"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+const testBastionConfig = `{
+	"owner_id": "933693344490",
+	"tag": "stable",
+	"keypair": "opsee-keypair"
+}`
+
+// launchTest is a launch wired up to the fakes, with its events collected
+type launchTest struct {
+	aws    *fakeAWS
+	sqs    *fakeSQS
+	sns    *fakeSNS
+	cf     *fakeCloudFormation
+	db     *fakeStore
+	router *fakeRouter
+	sink   *fakeSink
+	launch *Launch
+	events []*Event
+	done   chan struct{}
+}
+
+func newLaunchTest() *launchTest {
+	lt := &launchTest{
+		aws:    newFakeAWS(),
+		sqs:    newFakeSQS(),
+		db:     newFakeStore(),
+		router: &fakeRouter{connected: true},
+		sink:   &fakeSink{mut: &sync.Mutex{}},
+		done:   make(chan struct{}),
+	}
+
+	lt.sns = newFakeSNS(lt.sqs)
+	lt.cf = newFakeCloudFormation(lt.sns)
+
+	lt.aws.images["ami-old"] = "2016-05-01T00:00:00.000Z"
+	lt.aws.images["ami-new"] = "2016-06-01T00:00:00.000Z"
+	lt.aws.keyPairs["opsee-keypair"] = true
+
+	return lt
+}
+
+// start creates the launch and its bastion, the launch's workflow is up to the test
+func (lt *launchTest) start() error {
+	user := &schema.User{
+		Id:         13,
+		CustomerId: "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5",
+		Email:      "vapin@opsee.co",
+	}
+
+	lt.follow(lt.newLaunch(user))
+	return lt.launch.CreateBastion("exgid", fakeRegion, "vpc-fake", "subnet-fake", schema.RoutingStatePublic, "t2.micro")
+}
+
+// next follows another command's launch for the bastion, like deleting it
+// once it has launched
+func (lt *launchTest) next(command string) {
+	launch := lt.newLaunch(lt.launch.User)
+	launch.command = command
+	launch.Bastion = lt.db.bastion(lt.launch.Bastion.ID)
+
+	lt.events = nil
+	lt.done = make(chan struct{})
+	lt.follow(launch)
+}
+
+func (lt *launchTest) newLaunch(user *schema.User) *Launch {
+	cfg := &config.Config{BastionConfigKey: "/opsee.co/keelhaul/bastion-config"}
+	launch := NewLaunch(lt.db, lt.router, &fakeEtcd{value: testBastionConfig}, nil, &fakeBezos{}, cfg, lt.aws.session(), user)
+	launch.sqsClient = lt.sqs
+	launch.snsClient = lt.sns
+	launch.cloudformationClient = lt.cf
+	launch.Autochecks = autocheck.NewPool(lt.sink, launch.logger)
+	launch.images = NewChainImageResolver(
+		NewCustomerImageResolver(),
+		NewConfigImageResolver(),
+		&ec2TagImageResolver{session: lt.aws.session()},
+	)
+
+	return launch
+}
+
+// follow makes the launch the test's launch, collecting its events
+func (lt *launchTest) follow(launch *Launch) {
+	lt.launch = launch
+	go func() {
+		for event := range launch.EventChan {
+			lt.events = append(lt.events, event)
+		}
+		close(lt.done)
+	}()
+}
+
+// wait is for the launch to close its event channel
+func (lt *launchTest) wait() {
+	select {
+	case <-lt.done:
+	case <-time.After(10 * time.Second):
+		panic("launch events never finished")
+	}
+}
+
+func (lt *launchTest) close() {
+	lt.aws.Close()
+}
+
+var stackCreated = []fakeStackEvent{
+	{Status: "CREATE_IN_PROGRESS", Reason: "User Initiated"},
+	{LogicalID: "BastionInstance", ResourceType: "AWS::EC2::Instance", Status: "CREATE_IN_PROGRESS"},
+	{LogicalID: "BastionInstance", ResourceType: "AWS::EC2::Instance", Status: "CREATE_COMPLETE"},
+	{Status: "CREATE_COMPLETE"},
+}
+
+var launchTests = []struct {
+	name            string
+	stackEvents     []fakeStackEvent
+	createErr       error
+	connected       bool
+	connectAttempts float64
+	failedStack     string
+	leftovers       map[string]string
+	err             string
+	failureReason   string
+	bastionState    string
+	checks          int
+}{
+	{
+		name:         "launch succeeds",
+		stackEvents:  stackCreated,
+		connected:    true,
+		bastionState: com.BastionStateActive,
+		checks:       1,
+	},
+	{
+		name: "stack rolls back",
+		stackEvents: []fakeStackEvent{
+			{Status: "CREATE_IN_PROGRESS", Reason: "User Initiated"},
+			{LogicalID: "BastionInstance", ResourceType: "AWS::EC2::Instance", Status: "CREATE_FAILED", Reason: "The key pair 'opsee-keypair' does not exist"},
+			{Status: "ROLLBACK_IN_PROGRESS", Reason: "The following resource(s) failed to create: [BastionInstance]. . Rollback requested by user."},
+			{LogicalID: "BastionInstance", ResourceType: "AWS::EC2::Instance", Status: "DELETE_COMPLETE"},
+			{Status: "ROLLBACK_COMPLETE"},
+		},
+		connected:     true,
+		err:           "The key pair 'opsee-keypair' does not exist",
+		failureReason: "The key pair 'opsee-keypair' does not exist",
+		bastionState:  com.BastionStateFailed,
+	},
+	{
+		name: "the first failed resource is the reason",
+		stackEvents: []fakeStackEvent{
+			{Status: "CREATE_IN_PROGRESS", Reason: "User Initiated"},
+			{LogicalID: "BastionSecurityGroup", ResourceType: "AWS::EC2::SecurityGroup", Status: "CREATE_FAILED", Reason: "The security group limit has been reached"},
+			{LogicalID: "BastionInstance", ResourceType: "AWS::EC2::Instance", Status: "CREATE_FAILED", Reason: "Resource creation cancelled"},
+			{Status: "ROLLBACK_IN_PROGRESS", Reason: "The following resource(s) failed to create: [BastionInstance, BastionSecurityGroup]. . Rollback requested by user."},
+			{Status: "ROLLBACK_COMPLETE"},
+		},
+		connected:     true,
+		err:           "The security group limit has been reached",
+		failureReason: "The security group limit has been reached",
+		bastionState:  com.BastionStateFailed,
+	},
+	{
+		name: "stack rolls back without a failed resource",
+		stackEvents: []fakeStackEvent{
+			{Status: "CREATE_IN_PROGRESS", Reason: "User Initiated"},
+			{Status: "ROLLBACK_IN_PROGRESS", Reason: "Stack creation time exceeded the specified timeout"},
+			{Status: "ROLLBACK_COMPLETE", Reason: "Stack creation time exceeded the specified timeout"},
+		},
+		connected:     true,
+		err:           "Stack creation time exceeded the specified timeout",
+		failureReason: "Stack creation time exceeded the specified timeout",
+		bastionState:  com.BastionStateFailed,
+	},
+	{
+		name:        "the bastion's failed stack is deleted before launching",
+		stackEvents: stackCreated,
+		connected:   true,
+		failedStack: "ROLLBACK_COMPLETE",
+		// other bastions' stacks are the reaper's
+		leftovers: map[string]string{
+			"opsee-stack-5963d7bc-6ba2-11e5-8603-6ba085b2f5b5":   "ROLLBACK_COMPLETE",
+			"opsee-bastion-e9f5cb9e-0c3a-11e6-9b7b-a7ee0ea2f2b5": "DELETE_FAILED",
+			"customer-stack": "ROLLBACK_COMPLETE",
+		},
+		bastionState: com.BastionStateActive,
+		checks:       1,
+	},
+	{
+		name:         "stack can't be created",
+		createErr:    errors.New("InsufficientCapabilitiesException: Requires capabilities : [CAPABILITY_IAM]"),
+		connected:    true,
+		err:          "InsufficientCapabilitiesException: Requires capabilities : [CAPABILITY_IAM]",
+		bastionState: com.BastionStateFailed,
+	},
+	{
+		name:            "bastion never connects",
+		stackEvents:     stackCreated,
+		connected:       false,
+		connectAttempts: connectAttempts + 1,
+		err:             "timed out waiting for bastion to connect",
+		bastionState:    com.BastionStateFailed,
+	},
+}
+
+func TestLaunch(t *testing.T) {
+	assert := assert.New(t)
+	recoverPollInterval = 10 * time.Millisecond
+
+	for _, tt := range launchTests {
+		lt := newLaunchTest()
+		for name, status := range tt.leftovers {
+			lt.cf.addStack(name, status, "")
+		}
+
+		lt.cf.events = tt.stackEvents
+		lt.cf.createErr = tt.createErr
+		lt.router.connected = tt.connected
+		lt.aws.loadBalancers = []string{"web"}
+
+		err := lt.start()
+		assert.NoError(err, tt.name)
+
+		// an earlier attempt at the launch left the bastion's stack behind
+		if tt.failedStack != "" {
+			lt.cf.addStack(lt.launch.stackName(), tt.failedStack, "")
+		}
+
+		if tt.connectAttempts > 0 {
+			lt.launch.connectAttempts = tt.connectAttempts
+		}
+
+		lt.launch.Launch("stable")
+		lt.wait()
+		lt.close()
+
+		launch := lt.launch
+		if tt.err == "" {
+			assert.NoError(launch.Err, tt.name)
+			assert.Equal(stateComplete, launch.State(), tt.name)
+		} else if assert.Error(launch.Err, tt.name) {
+			assert.Equal(tt.err, launch.Err.Error(), tt.name)
+			assert.Equal(stateFailed, launch.State(), tt.name)
+		}
+
+		bastion := lt.db.bastion(launch.Bastion.ID)
+		if assert.NotNil(bastion, tt.name) {
+			assert.Equal(tt.bastionState, bastion.State, tt.name)
+		}
+
+		record := lt.db.launch(launch.ID)
+		if assert.NotNil(record, tt.name) {
+			assert.Equal(launch.State(), record.State, tt.name)
+		}
+
+		// the launch cleans up after itself no matter how it ended
+		assert.Len(lt.sqs.deleted, 1, tt.name)
+		assert.Len(lt.sns.deleted, 1, tt.name)
+		assert.Len(lt.sink.checks, tt.checks, tt.name)
+		assert.NotEmpty(lt.db.events, tt.name)
+		assert.Equal(tt.failureReason, lt.db.reasons[launch.Bastion.ID], tt.name)
+
+		recovered := []string{}
+		for _, stack := range lt.cf.deleted {
+			recovered = append(recovered, stack.name)
+		}
+
+		if tt.failedStack != "" {
+			assert.Equal([]string{launch.stackName()}, recovered, tt.name)
+		} else {
+			assert.Empty(recovered, tt.name)
+		}
+
+		for name := range tt.leftovers {
+			assert.NotNil(lt.cf.stack(name), tt.name)
+		}
+
+		last := lt.events[len(lt.events)-1]
+		if tt.err == "" {
+			assert.NoError(last.Err, tt.name)
+		} else {
+			assert.Error(last.Err, tt.name)
+			assert.Equal(stateFailed, last.Message.State, tt.name)
+		}
+
+		if tt.createErr != nil {
+			// createStack is retried before the launch gives up
+			assert.True(lt.cf.creates > 1, tt.name)
+			continue
+		}
+
+		// the stack gets the newest image and the config's key pair
+		stack := lt.cf.stack(launch.stackName())
+		if assert.NotNil(stack, tt.name) {
+			params := make(map[string]string)
+			for _, p := range stack.parameters {
+				params[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+			}
+
+			assert.Equal("ami-new", params["ImageId"], tt.name)
+			assert.Equal("opsee-keypair", params["KeyName"], tt.name)
+			assert.Equal(launch.Bastion.ID, params["BastionId"], tt.name)
+		}
+	}
+}
+
+var deleteTests = []struct {
+	name     string
+	launched bool
+	deleted  int
+	message  string
+}{
+	{
+		name:     "the stack is deleted",
+		launched: true,
+		deleted:  1,
+		message:  "cloudformation stack delete complete",
+	},
+	{
+		name:    "a bastion without a stack is just marked deleted",
+		message: "no cloudformation stack found",
+	},
+}
+
+func TestDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range deleteTests {
+		lt := newLaunchTest()
+		lt.cf.events = stackCreated
+
+		err := lt.start()
+		assert.NoError(err, tt.name)
+
+		if tt.launched {
+			lt.launch.Launch("stable")
+		} else {
+			lt.launch.error(errors.New("failed"), &bus.Message{Message: "failed before creating the stack"})
+		}
+		lt.wait()
+
+		lt.next(commandDeleteBastion)
+		err = lt.launch.begin()
+		assert.NoError(err, tt.name)
+
+		lt.launch.Delete()
+		lt.wait()
+		lt.close()
+
+		assert.NoError(lt.launch.Err, tt.name)
+		assert.Len(lt.cf.deleted, tt.deleted, tt.name)
+		assert.Empty(lt.cf.stacks, tt.name)
+		assert.Equal(com.BastionStateDeleted, lt.db.bastion(lt.launch.Bastion.ID).State, tt.name)
+		assert.Equal(stateComplete, lt.db.launch(lt.launch.ID).State, tt.name)
+
+		messages := make([]string, len(lt.events))
+		for i, event := range lt.events {
+			messages[i] = event.Message.Message
+		}
+		assert.Contains(messages, tt.message, tt.name)
+		assert.Contains(messages, "bastion deletion complete", tt.name)
+	}
+}
+
+var stackUpdateRolledBack = []fakeStackEvent{
+	{Status: "UPDATE_IN_PROGRESS", Reason: "User Initiated"},
+	{LogicalID: "BastionAutoScalingGroup", ResourceType: "AWS::AutoScaling::AutoScalingGroup", Status: "UPDATE_FAILED", Reason: "Received 0 SUCCESS signal(s) out of 1"},
+	{Status: "UPDATE_ROLLBACK_IN_PROGRESS", Reason: "The following resource(s) failed to update: [BastionAutoScalingGroup]."},
+	{Status: "UPDATE_ROLLBACK_COMPLETE"},
+}
+
+var updateTests = []struct {
+	name        string
+	command     string
+	events      []fakeStackEvent
+	err         string
+	imageID     string
+	newPassword bool
+}{
+	{
+		name:        "upgrades move the bastion to the newest image",
+		command:     commandUpgradeBastion,
+		events:      stackUpdated,
+		imageID:     "ami-new",
+		newPassword: true,
+	},
+	{
+		name:    "a rolled back upgrade keeps the bastion on its image",
+		command: commandUpgradeBastion,
+		events:  stackUpdateRolledBack,
+		err:     "Received 0 SUCCESS signal(s) out of 1",
+		imageID: "ami-old",
+	},
+	{
+		name:        "rotating keys keeps the image",
+		command:     commandRotateKeys,
+		events:      stackUpdated,
+		imageID:     "ami-old",
+		newPassword: true,
+	},
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range updateTests {
+		lt := newLaunchTest()
+		lt.cf.events = stackCreated
+		lt.cf.updateEvents = tt.events
+
+		// the bastion launches before the newer image comes out
+		delete(lt.aws.images, "ami-new")
+
+		err := lt.start()
+		assert.NoError(err, tt.name)
+
+		lt.launch.Launch("stable")
+		lt.wait()
+
+		launched := *lt.db.bastion(lt.launch.Bastion.ID)
+		assert.Equal("ami-old", launched.ImageID.String, tt.name)
+
+		lt.aws.images["ami-new"] = "2016-06-01T00:00:00.000Z"
+		saved := len(lt.db.hashes)
+		lt.next(tt.command)
+		err = lt.launch.begin()
+		assert.NoError(err, tt.name)
+
+		if tt.command == commandRotateKeys {
+			lt.launch.RotateKeys()
+		} else {
+			lt.launch.Upgrade("stable")
+		}
+		lt.wait()
+		lt.close()
+
+		if tt.err == "" {
+			assert.NoError(lt.launch.Err, tt.name)
+			assert.Equal(stateComplete, lt.db.launch(lt.launch.ID).State, tt.name)
+		} else if assert.Error(lt.launch.Err, tt.name) {
+			assert.Equal(tt.err, lt.launch.Err.Error(), tt.name)
+			assert.Equal(stateFailed, lt.db.launch(lt.launch.ID).State, tt.name)
+		}
+
+		bastion := lt.db.bastion(lt.launch.Bastion.ID)
+		assert.Equal(tt.imageID, bastion.ImageID.String, tt.name)
+		assert.Equal(tt.newPassword, bastion.PasswordHash != launched.PasswordHash, tt.name)
+
+		// the new password is saved as soon as the update is issued, and a rolled
+		// back update restores the old one
+		hashes := lt.db.hashes[saved:]
+		if assert.NotEmpty(hashes, tt.name) {
+			assert.NotEqual(launched.PasswordHash, hashes[0], tt.name)
+		}
+
+		// the stack is updated with new userdata and the image the bastion ends up with
+		if assert.Len(lt.cf.updates, 1, tt.name) {
+			params := make(map[string]string)
+			for _, p := range lt.cf.updates[0] {
+				params[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+			}
+
+			if tt.err == "" {
+				assert.Equal(tt.imageID, params["ImageId"], tt.name)
+			}
+			assert.NotEmpty(params["UserData"], tt.name)
+			assert.Equal(launched.ID, params["BastionId"], tt.name)
+		}
+	}
+}
+
+var discoveryTests = []struct {
+	name           string
+	groups         map[string][]string
+	failGroups     []string
+	failActions    []string
+	instances      int
+	instanceErrors int
+	groupErrors    int
+	tooManyErrors  bool
+}{
+	{
+		name: "discovery succeeds",
+		groups: map[string][]string{
+			"sg-1": {"i-1", "i-2"},
+			"sg-2": {"i-2", "i-3"},
+		},
+		instances: 3,
+	},
+	{
+		name: "a load balancer error fails discovery",
+		groups: map[string][]string{
+			"sg-1": {"i-1", "i-2"},
+		},
+		failActions:   []string{"DescribeLoadBalancers"},
+		instances:     2,
+		groupErrors:   1,
+		tooManyErrors: true,
+	},
+	{
+		name: "a few instance errors are ignored",
+		groups: map[string][]string{
+			"sg-1": {"i-1", "i-2"},
+			"sg-2": {"i-3", "i-4"},
+			"sg-3": {"i-5"},
+		},
+		failGroups:     []string{"sg-3"},
+		instances:      4,
+		instanceErrors: 1,
+	},
+	{
+		name: "too many instance errors fail discovery",
+		groups: map[string][]string{
+			"sg-1": {"i-1", "i-2"},
+			"sg-2": {"i-3"},
+			"sg-3": {"i-4"},
+		},
+		failGroups:     []string{"sg-2", "sg-3"},
+		instances:      2,
+		instanceErrors: 2,
+		tooManyErrors:  true,
+	},
+}
+
+func TestVPCDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range discoveryTests {
+		lt := newLaunchTest()
+		lt.aws.securityGroups = tt.groups
+		for _, g := range tt.failGroups {
+			lt.aws.failGroups[g] = true
+		}
+		for _, a := range tt.failActions {
+			lt.aws.failActions[a] = true
+		}
+
+		err := lt.start()
+		assert.NoError(err, tt.name)
+
+		lt.launch.run(newWorkflow(&stageSpec{Stage: vpcDiscovery{}, Required: true}), nil)
+		lt.wait()
+		lt.close()
+
+		env := lt.launch.VPCEnvironment
+		assert.Equal(tt.instances, env.InstanceCount, tt.name)
+		assert.Equal(tt.instanceErrors, env.InstanceErrorCount, tt.name)
+		assert.Equal(tt.groupErrors, env.GroupErrorCount, tt.name)
+		assert.Equal(len(tt.groups), env.SecurityGroupCount, tt.name)
+		assert.Equal(tt.tooManyErrors, env.tooManyErrors(), tt.name)
+
+		if tt.tooManyErrors {
+			assert.Error(lt.launch.Err, tt.name)
+		} else {
+			assert.NoError(lt.launch.Err, tt.name)
+		}
+	}
+}
+
+func TestStageTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	lt := newLaunchTest()
+	lt.router.connected = false
+
+	err := lt.start()
+	assert.NoError(err)
+
+	// waiting on a bastion that never connects runs into the stage's timeout
+	lt.launch.run(newWorkflow(&stageSpec{Stage: waitConnect{}, Timeout: 50 * time.Millisecond, Required: true}), nil)
+	lt.wait()
+	lt.close()
+
+	if assert.Error(lt.launch.Err) {
+		assert.Equal("waitConnect timed out after 50ms", lt.launch.Err.Error())
+	}
+
+	last := lt.events[len(lt.events)-1]
+	assert.Equal("timed out waiting for waitConnect", last.Message.Message)
+	assert.Equal(com.BastionStateFailed, lt.db.bastion(lt.launch.Bastion.ID).State)
+}
+
+func TestHALaunch(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, subnetIDs := range [][]string{nil, {"subnet-aaaaaa"}, {"subnet-aaaaaa", "subnet-bbbbbb"}} {
+		lt := newLaunchTest()
+
+		err := lt.start()
+		assert.NoError(err)
+
+		lt.launch.SubnetIDs = subnetIDs
+		lt.launch.run(newWorkflow(
+			&stageSpec{Stage: getBastionConfig{}, Required: true},
+			&stageSpec{Stage: createTopic{}, Required: true},
+			&stageSpec{Stage: createStack{}, After: []Stage{getBastionConfig{}, createTopic{}}, Required: true},
+		), nil)
+		lt.wait()
+		lt.close()
+
+		assert.NoError(lt.launch.Err)
+
+		params := make(map[string]string)
+		for _, stack := range lt.cf.stacks {
+			for _, p := range stack.parameters {
+				params[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+			}
+		}
+
+		// only a launch across several subnets gets the ha template
+		if len(subnetIDs) > 1 {
+			assert.Equal("subnet-aaaaaa,subnet-bbbbbb", params["SubnetIds"])
+			assert.NotContains(params, "SubnetId")
+		} else {
+			assert.Equal(lt.launch.Bastion.SubnetID, params["SubnetId"])
+			assert.NotContains(params, "SubnetIds")
+		}
+	}
+}
+
+func TestEC2Autochecks(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, enabled := range []bool{false, true} {
+		lt := newLaunchTest()
+		lt.aws.securityGroups = map[string][]string{
+			"sg-1": {"i-1", "i-2"},
+			"sg-2": {"i-2"},
+		}
+
+		err := lt.start()
+		assert.NoError(err)
+
+		lt.launch.bastionConfig = &BastionConfig{
+			EC2Autochecks: map[string]bool{lt.launch.User.CustomerId: enabled},
+		}
+		lt.launch.run(newWorkflow(&stageSpec{Stage: vpcDiscovery{}, Required: true}), nil)
+		lt.wait()
+		lt.close()
+
+		// the autochecks are sent once the workflow completes
+		instanceChecks := 0
+		for _, check := range lt.sink.checks {
+			if check.Target.Type == "cloudwatch" {
+				instanceChecks++
+			}
+		}
+
+		// one check of each instance's metrics
+		if enabled {
+			assert.Equal(2, instanceChecks)
+		} else {
+			assert.Equal(0, instanceChecks)
+		}
+	}
+}
+
+func TestAutocheckPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	lt := newLaunchTest()
+	lt.aws.securityGroups = map[string][]string{
+		"sg-1": {"i-1", "i-2"},
+	}
+	lt.aws.loadBalancers = []string{"lb-1"}
+
+	// instances are turned on by the policy instead of the bastion config
+	lt.db.policy = &store.AutocheckPolicy{
+		Policy: []byte(`{
+			"load_balancers": {"enabled": false},
+			"instances": {"enabled": true, "interval": 120, "max_cpu_util": 80},
+			"exclude": [{"name_prefix": "i-1"}]
+		}`),
+	}
+
+	err := lt.start()
+	assert.NoError(err)
+
+	lt.launch.run(newWorkflow(&stageSpec{Stage: vpcDiscovery{}, Required: true}), nil)
+	lt.wait()
+	lt.close()
+
+	if assert.Len(lt.sink.checks, 1) {
+		check := lt.sink.checks[0]
+		assert.Equal("i-2", check.Target.Id)
+		assert.EqualValues(120, check.Interval)
+		assert.Equal("80.000", check.Assertions[0].Operand)
+	}
+}
+
+func TestTargetGroupAutochecks(t *testing.T) {
+	assert := assert.New(t)
+
+	lt := newLaunchTest()
+	lt.aws.loadBalancers = []string{"classic"}
+	lt.aws.targetGroups = []string{"web", "api"}
+
+	err := lt.start()
+	assert.NoError(err)
+
+	lt.launch.run(newWorkflow(&stageSpec{Stage: vpcDiscovery{}, Required: true}), nil)
+	lt.wait()
+	lt.close()
+
+	assert.Equal(1, lt.launch.VPCEnvironment.LoadBalancerCount)
+	assert.Equal(2, lt.launch.VPCEnvironment.ApplicationLoadBalancerCount)
+	assert.Equal(2, lt.launch.VPCEnvironment.TargetGroupCount)
+
+	targetGroups := make([]string, 0)
+	for _, check := range lt.sink.checks {
+		if check.Target.Type == "target_group" {
+			targetGroups = append(targetGroups, check.Target.Name)
+		}
+	}
+
+	sort.Strings(targetGroups)
+	assert.Equal([]string{"api", "web"}, targetGroups)
+}
+
+// slowTopic creates the topic after the launch has already failed
+type slowTopic struct{}
+
+func (s slowTopic) Execute(ctx context.Context, launch *Launch) error {
+	time.Sleep(100 * time.Millisecond)
+	return createTopic{}.Execute(ctx, launch)
+}
+
+type failStage struct{}
+
+func (s failStage) Execute(ctx context.Context, launch *Launch) error {
+	return errors.New("failed")
+}
+
+func TestFailureWaitsForStages(t *testing.T) {
+	assert := assert.New(t)
+
+	lt := newLaunchTest()
+
+	err := lt.start()
+	assert.NoError(err)
+
+	// the launch only winds down once the topic exists, so cleanup deletes it
+	lt.launch.run(newWorkflow(
+		&stageSpec{Stage: slowTopic{}, Required: true},
+		&stageSpec{Stage: failStage{}, Required: true},
+	), nil)
+	lt.wait()
+	lt.close()
+
+	assert.Error(lt.launch.Err)
+	assert.Len(lt.sns.deleted, 1)
+	assert.Equal(com.BastionStateFailed, lt.db.bastion(lt.launch.Bastion.ID).State)
+}
@@ -1,8 +1,16 @@
 package launcher
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/basic/com"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/basic/service"
 	"github.com/opsee/keelhaul/bus"
@@ -10,10 +18,34 @@ import (
 	"github.com/opsee/keelhaul/notifier"
 	"github.com/opsee/keelhaul/router"
 	"github.com/opsee/keelhaul/store"
-	"time"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"github.com/satori/go.uuid"
 )
 
-var MagicExgid = "127a7354-290e-11e6-b178-2bc1f6aefc14"
+var (
+	MagicExgid = "127a7354-290e-11e6-b178-2bc1f6aefc14"
+
+	ErrLaunchNotFound       = errors.New("no running launch for bastion")
+	ErrLaunchNotCancellable = errors.New("bastion deletions can't be cancelled")
+	ErrBastionBusy          = errors.New("bastion has another operation in progress")
+	ErrBastionLimit         = errors.New("customer has reached their bastion limit")
+
+	// bastions in these states count against the limits
+	runningBastionStates = []string{
+		com.BastionStateNew,
+		com.BastionStateLaunching,
+		com.BastionStateActive,
+	}
+)
+
+const (
+	// launches are claimed by the process running them, which renews its
+	// claim every leaseInterval. other processes take over launches whose
+	// claim hasn't been renewed in leaseExpiry.
+	leaseInterval = 30 * time.Second
+	leaseExpiry   = 2 * time.Minute
+)
 
 type systemClock struct{}
 
@@ -22,7 +54,22 @@ func (s *systemClock) Now() time.Time {
 }
 
 type Launcher interface {
-	LaunchBastion(*session.Session, *schema.User, string, string, string, string, string, string, string) (*Launch, error)
+	LaunchBastion(*session.Session, *schema.User, string, string, string, string, string, string, string, *LaunchOptions) (*Launch, error)
+	DeleteBastion(*session.Session, *schema.User, *com.Bastion) (*Launch, error)
+	UpgradeBastion(*session.Session, *schema.User, *com.Bastion, string) (*Launch, error)
+	RotateBastionKeys(*session.Session, *schema.User, *com.Bastion) (*Launch, error)
+	CancelLaunch(*com.Bastion) error
+	PreflightLaunch(*session.Session, *schema.User, string, string, string, string, *LaunchOptions) []*Finding
+	Resume() error
+}
+
+// LaunchOptions are the optional stack settings a customer can choose.
+// SubnetIDs launches the bastion in HA mode across all of them, each in a
+// different availability zone.
+type LaunchOptions struct {
+	KeyName   string
+	AllowSSH  bool
+	SubnetIDs []string
 }
 
 type launcher struct {
@@ -34,6 +81,10 @@ type launcher struct {
 	config   *config.Config
 	notifier notifier.Notifier
 	bezos    service.BezosClient
+	launches map[string]*Launch
+	mut      *sync.Mutex
+	owner    string
+	leases   *sync.Once
 }
 
 func New(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, bus bus.Bus, notifier notifier.Notifier, spanxclient service.SpanxClient, bezos service.BezosClient, cfg *config.Config) (*launcher, error) {
@@ -46,29 +97,373 @@ func New(db store.Store, router router.Router, etcdKAPI etcd.KeysAPI, bus bus.Bu
 		config:   cfg,
 		notifier: notifier,
 		bezos:    bezos,
+		launches: make(map[string]*Launch),
+		mut:      &sync.Mutex{},
+		owner:    uuid.NewV4().String(),
+		leases:   &sync.Once{},
 	}, nil
 }
 
-func (l *launcher) LaunchBastion(sess *session.Session, user *schema.User, executionGroupId, region, vpcID, subnetID, subnetRouting, instanceType, imageTag string) (*Launch, error) {
-	launch := NewLaunch(l.db, l.router, l.etcd, l.spanx, l.bezos, l.config, sess, user)
+func (l *launcher) LaunchBastion(sess *session.Session, user *schema.User, executionGroupId, region, vpcID, subnetID, subnetRouting, instanceType, imageTag string, opts *LaunchOptions) (*Launch, error) {
+	err := checkBastionLimits(l.db, l.config, user, region, vpcID)
+	if err != nil {
+		return nil, err
+	}
+
+	launch := l.newLaunch(sess, user)
+	if opts != nil {
+		launch.KeyName = opts.KeyName
+		launch.AllowSSH = opts.AllowSSH
+		launch.SubnetIDs = opts.SubnetIDs
+	}
 	go l.watchLaunch(launch)
 
 	// this is done synchronously so that we can return the bastion id
-	err := launch.CreateBastion(executionGroupId, region, vpcID, subnetID, subnetRouting, instanceType)
+	err = launch.CreateBastion(executionGroupId, region, vpcID, subnetID, subnetRouting, instanceType)
 	if err != nil {
 		return nil, err
 	}
 
+	l.track(launch)
 	go launch.Launch(imageTag)
 
 	return launch, nil
 }
 
+func (l *launcher) DeleteBastion(sess *session.Session, user *schema.User, bastion *com.Bastion) (*Launch, error) {
+	launch := l.newLaunch(sess, user)
+	launch.command = commandDeleteBastion
+	launch.Bastion = bastion
+	launch.logger = launch.logger.WithField("bastion-id", bastion.ID)
+	err := l.begin(launch)
+	if err != nil {
+		return nil, err
+	}
+
+	go l.watchLaunch(launch)
+	go launch.Delete()
+
+	return launch, nil
+}
+
+func (l *launcher) UpgradeBastion(sess *session.Session, user *schema.User, bastion *com.Bastion, imageTag string) (*Launch, error) {
+	launch := l.newLaunch(sess, user)
+	launch.command = commandUpgradeBastion
+	launch.Bastion = bastion
+	launch.logger = launch.logger.WithField("bastion-id", bastion.ID)
+	err := l.begin(launch)
+	if err != nil {
+		return nil, err
+	}
+
+	go l.watchLaunch(launch)
+	go launch.Upgrade(imageTag)
+
+	return launch, nil
+}
+
+func (l *launcher) RotateBastionKeys(sess *session.Session, user *schema.User, bastion *com.Bastion) (*Launch, error) {
+	launch := l.newLaunch(sess, user)
+	launch.command = commandRotateKeys
+	launch.Bastion = bastion
+	launch.logger = launch.logger.WithField("bastion-id", bastion.ID)
+	err := l.begin(launch)
+	if err != nil {
+		return nil, err
+	}
+
+	go l.watchLaunch(launch)
+	go launch.RotateKeys()
+
+	return launch, nil
+}
+
+// Resume picks up the launches left in progress by keelhaul processes that
+// have stopped, and from then on keeps claiming those of processes that stop
+// while this one is running. Launches are claimed in the database, so each is
+// only resumed by one process.
+func (l *launcher) Resume() error {
+	err := l.resume()
+	l.leases.Do(func() {
+		go l.renewLeases()
+	})
+
+	return err
+}
+
+func (l *launcher) renewLeases() {
+	for range time.Tick(leaseInterval) {
+		err := l.heartbeat()
+		if err != nil {
+			log.WithError(err).Error("failed to renew launch claims")
+		}
+
+		err = l.resume()
+		if err != nil {
+			log.WithError(err).Error("couldn't resume launches")
+		}
+	}
+}
+
+// heartbeat renews the claims on our launches, and cancels the ones another
+// process was asked to cancel
+func (l *launcher) heartbeat() error {
+	resp, err := l.db.HeartbeatLaunches(l.owner, stateInProgress)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range resp.Launches {
+		if !record.CancelRequested {
+			continue
+		}
+
+		l.mut.Lock()
+		launch, ok := l.launches[record.BastionID]
+		l.mut.Unlock()
+
+		if ok && launch.ID == record.ID {
+			launch.Cancel()
+		}
+	}
+
+	return nil
+}
+
+func (l *launcher) resume() error {
+	resp, err := l.db.ClaimLaunches(&store.ClaimLaunchesRequest{
+		Owner:  l.owner,
+		State:  stateInProgress,
+		Expiry: leaseExpiry,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, record := range resp.Launches {
+		logger := log.WithFields(log.Fields{
+			"launch-id":  record.ID,
+			"bastion-id": record.BastionID,
+		})
+
+		// our own claim lapsed, the launch is still running
+		if l.tracking(record.BastionID, record.ID) {
+			continue
+		}
+
+		user := &schema.User{}
+		err = json.Unmarshal(record.UserJSON, user)
+		if err != nil {
+			logger.WithError(err).Error("failed to unmarshal launch user")
+			continue
+		}
+
+		bastionResp, err := l.db.GetBastion(&store.GetBastionRequest{ID: record.BastionID, AnyState: true})
+		if err != nil {
+			logger.WithError(err).Error("failed to get launch bastion")
+			continue
+		}
+
+		sess := session.New(&aws.Config{
+			Credentials: spanxcreds.NewSpanxCredentials(user, l.spanx),
+			Region:      aws.String(record.Region),
+			MaxRetries:  aws.Int(11),
+		})
+
+		launch := l.newLaunch(sess, user)
+		launch.ID = record.ID
+		launch.command = record.Command
+		launch.Bastion = bastionResp.Bastion
+		launch.logger = launch.logger.WithField("bastion-id", record.BastionID)
+		go l.watchLaunch(launch)
+		l.track(launch)
+		go launch.Resume(record)
+
+		logger.Infof("resuming %s launch", record.Command)
+	}
+
+	return nil
+}
+
+// checkBastionLimits allows one running bastion per vpc, and no more than the
+// configured number per customer. the database enforces the former as well,
+// since concurrent launches can both get past this check.
+func checkBastionLimits(db store.Store, cfg *config.Config, user *schema.User, region, vpcID string) error {
+	resp, err := db.ListBastions(&store.ListBastionsRequest{
+		CustomerID: user.CustomerId,
+		State:      runningBastionStates,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, bastion := range resp.Bastions {
+		if bastion.Region == region && bastion.VPCID == vpcID {
+			return store.ErrBastionExists
+		}
+	}
+
+	// the global bastions aren't limited
+	if user.CustomerId != MagicExgid && cfg.MaxBastions > 0 && len(resp.Bastions) >= cfg.MaxBastions {
+		return ErrBastionLimit
+	}
+
+	return nil
+}
+
+// PreflightLaunch checks whether a launch into the subnet is likely to succeed
+func (l *launcher) PreflightLaunch(sess *session.Session, user *schema.User, vpcID, subnetID, instanceType, imageTag string, opts *LaunchOptions) []*Finding {
+	launch := l.newLaunch(sess, user)
+
+	// nobody watches a preflight's events, and it has nothing to clean up
+	launch.EventChan = nil
+	launch.command = commandPreflight
+	launch.ImageTag = imageTag
+	if opts != nil {
+		launch.KeyName = opts.KeyName
+		launch.AllowSSH = opts.AllowSSH
+		launch.SubnetIDs = opts.SubnetIDs
+	}
+
+	launch.Bastion = &com.Bastion{
+		Region:       aws.StringValue(sess.Config.Region),
+		VPCID:        vpcID,
+		SubnetID:     subnetID,
+		InstanceType: instanceType,
+	}
+
+	return launch.Preflight()
+}
+
+// CancelLaunch stops the launch running for a bastion. a launch running in
+// another keelhaul process is marked for cancellation, and that process
+// cancels it the next time it renews its claim on it.
+func (l *launcher) CancelLaunch(bastion *com.Bastion) error {
+	l.mut.Lock()
+	launch, ok := l.launches[bastion.ID]
+	l.mut.Unlock()
+
+	if ok {
+		if launch.command == commandDeleteBastion {
+			return ErrLaunchNotCancellable
+		}
+
+		launch.Cancel()
+		return nil
+	}
+
+	record, err := l.db.GetLaunch(&store.GetLaunchRequest{
+		BastionID:  bastion.ID,
+		CustomerID: bastion.CustomerID,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrLaunchNotFound
+		}
+
+		return err
+	}
+
+	if record.State != stateInProgress {
+		return ErrLaunchNotFound
+	}
+
+	if !CanCancel(record) {
+		return ErrLaunchNotCancellable
+	}
+
+	requested, err := l.db.RequestLaunchCancel(record.ID, stateInProgress)
+	if err != nil {
+		return err
+	}
+
+	if !requested {
+		return ErrLaunchNotFound
+	}
+
+	return nil
+}
+
+// CanCancel is false for the launches in progress that can't be cancelled,
+// deletions, which disable their bastion before deleting its stack
+func CanCancel(record *store.Launch) bool {
+	return record.State != stateInProgress || record.Command != commandDeleteBastion
+}
+
+// newLaunch is a launch owned by this launcher
+func (l *launcher) newLaunch(sess *session.Session, user *schema.User) *Launch {
+	launch := NewLaunch(l.db, l.router, l.etcd, l.spanx, l.bezos, l.config, sess, user)
+	launch.owner = l.owner
+	return launch
+}
+
+func (l *launcher) tracking(bastionID, launchID string) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	launch, ok := l.launches[bastionID]
+	return ok && launch.ID == launchID
+}
+
+// begin tracks the launch as its bastion's operation, unless the bastion
+// already has one in progress, whether in this keelhaul process or another
+func (l *launcher) begin(launch *Launch) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if _, ok := l.launches[launch.Bastion.ID]; ok {
+		return ErrBastionBusy
+	}
+
+	err := launch.begin()
+	if err != nil {
+		if err == store.ErrLaunchInProgress {
+			return ErrBastionBusy
+		}
+
+		return err
+	}
+
+	l.launches[launch.Bastion.ID] = launch
+	return nil
+}
+
+func (l *launcher) track(launch *Launch) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.launches[launch.Bastion.ID] = launch
+}
+
+func (l *launcher) untrack(launch *Launch) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if launch.Bastion == nil {
+		return
+	}
+
+	// a newer launch may have taken the bastion's place
+	if l.launches[launch.Bastion.ID] == launch {
+		delete(l.launches, launch.Bastion.ID)
+	}
+}
+
 func (l *launcher) watchLaunch(launch *Launch) {
 	for event := range launch.EventChan {
 		l.bus.Publish(event.Message)
 	}
 
+	l.untrack(launch)
+
+	// customers are only emailed about launches they didn't cancel
+	if launch.command != commandLaunchBastion || launch.Err == errLaunchCancelled {
+		return
+	}
+
 	if launch.Err != nil {
 		l.notifier.NotifyError(int(launch.User.Id), launch.NotifyVars())
 	} else {
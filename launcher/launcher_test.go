@@ -0,0 +1,197 @@
+package launcher
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/config"
+	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type fakeBus struct {
+	bus.Bus
+	mut      *sync.Mutex
+	messages []*bus.Message
+}
+
+func (b *fakeBus) Publish(msg *bus.Message) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+// fakeNotifier lets tests wait on the launches the launcher has finished
+type fakeNotifier struct {
+	notifier.Notifier
+	finished chan bool
+}
+
+func (n *fakeNotifier) NotifyError(userID int, vars interface{}) error {
+	n.finished <- false
+	return nil
+}
+
+func (n *fakeNotifier) NotifySuccess(userID int, vars interface{}) error {
+	n.finished <- true
+	return nil
+}
+
+func newTestLauncher(db *fakeStore) *launcher {
+	l, _ := New(db, &fakeRouter{connected: true}, &fakeEtcd{value: testBastionConfig}, &fakeBus{mut: &sync.Mutex{}}, &fakeNotifier{finished: make(chan bool, 10)}, nil, &fakeBezos{}, &config.Config{})
+	return l
+}
+
+// finished waits for n launches to finish, returning whether they succeeded
+func (l *launcher) finished(n int) []bool {
+	results := make([]bool, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case ok := <-l.notifier.(*fakeNotifier).finished:
+			results = append(results, ok)
+		case <-time.After(10 * time.Second):
+			panic("launches never finished")
+		}
+	}
+
+	return results
+}
+
+func TestResumeClaims(t *testing.T) {
+	assert := assert.New(t)
+
+	db := newFakeStore()
+	l := newTestLauncher(db)
+
+	user, _ := json.Marshal(&schema.User{Id: 13, CustomerId: "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"})
+	owners := map[string]struct {
+		owner     string
+		heartbeat time.Time
+	}{
+		"unowned": {},
+		"running": {"replica-1", time.Now()},
+		"stopped": {"replica-2", time.Now().Add(-leaseExpiry)},
+	}
+
+	for id, o := range owners {
+		db.bastions[id] = &com.Bastion{ID: id, State: com.BastionStateLaunching}
+		db.launches[id] = &store.Launch{
+			ID:        id,
+			BastionID: id,
+			Command:   commandLaunchBastion,
+			State:     stateInProgress,
+			Region:    fakeRegion,
+			UserJSON:  user,
+			Owner:     o.owner,
+			Heartbeat: o.heartbeat,
+		}
+	}
+
+	err := l.Resume()
+	assert.NoError(err)
+
+	// they're too old to finish, the launcher gives up on them
+	assert.Equal([]bool{false, false}, l.finished(2))
+	assert.Equal(l.owner, db.launch("unowned").Owner)
+	assert.Equal(l.owner, db.launch("stopped").Owner)
+	assert.Equal(stateFailed, db.launch("stopped").State)
+
+	// a launch whose owner is still running stays with it
+	assert.Equal("replica-1", db.launch("running").Owner)
+	assert.Equal(stateInProgress, db.launch("running").State)
+}
+
+func TestBastionBusy(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		db      = newFakeStore()
+		l       = newTestLauncher(db)
+		user    = &schema.User{Id: 13, CustomerId: "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"}
+		fake    = newFakeAWS()
+		sess    = fake.session()
+		running = &com.Bastion{ID: "running", State: com.BastionStateActive}
+		other   = &com.Bastion{ID: "other", State: com.BastionStateActive}
+	)
+
+	defer fake.Close()
+
+	// this process is upgrading one bastion, another is rotating the other's keys
+	l.track(&Launch{Bastion: running})
+	db.launches["rotate"] = &store.Launch{ID: "rotate", BastionID: other.ID, Command: commandRotateKeys, State: stateInProgress}
+
+	for _, bastion := range []*com.Bastion{running, other} {
+		_, err := l.DeleteBastion(sess, user, bastion)
+		assert.Equal(ErrBastionBusy, err, bastion.ID)
+
+		_, err = l.UpgradeBastion(sess, user, bastion, "stable")
+		assert.Equal(ErrBastionBusy, err, bastion.ID)
+
+		_, err = l.RotateBastionKeys(sess, user, bastion)
+		assert.Equal(ErrBastionBusy, err, bastion.ID)
+	}
+
+	assert.Len(db.launches, 1)
+	assert.NotContains(l.launches, other.ID)
+}
+
+func TestCancelLaunch(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		db         = newFakeStore()
+		l          = newTestLauncher(db)
+		customerID = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+		local      = &Launch{ID: "local", Bastion: &com.Bastion{ID: "local", CustomerID: customerID}}
+		remote     = &com.Bastion{ID: "remote", CustomerID: customerID}
+		finished   = &com.Bastion{ID: "finished", CustomerID: customerID}
+	)
+
+	local.ctx, local.cancel = context.WithCancel(context.Background())
+	l.track(local)
+	db.launches["local"] = &store.Launch{ID: "local", BastionID: "local", CustomerID: customerID, State: stateInProgress, Owner: l.owner}
+	db.launches["remote"] = &store.Launch{ID: "remote", BastionID: "remote", CustomerID: customerID, State: stateInProgress, Owner: "replica-1"}
+	db.launches["finished"] = &store.Launch{ID: "finished", BastionID: "finished", CustomerID: customerID, State: stateComplete}
+
+	// a launch in this process is cancelled right away
+	assert.NoError(l.CancelLaunch(local.Bastion))
+	assert.Error(local.ctx.Err())
+	assert.False(db.launch("local").CancelRequested)
+
+	// one in another process is marked for that process to cancel
+	assert.NoError(l.CancelLaunch(remote))
+	assert.True(db.launch("remote").CancelRequested)
+
+	assert.Equal(ErrLaunchNotFound, l.CancelLaunch(finished))
+	assert.Equal(ErrLaunchNotFound, l.CancelLaunch(&com.Bastion{ID: "none", CustomerID: customerID}))
+
+	// deletions can't be cancelled, wherever they're running
+	deleting := &Launch{ID: "deleting", command: commandDeleteBastion, Bastion: &com.Bastion{ID: "deleting", CustomerID: customerID}}
+	deleting.ctx, deleting.cancel = context.WithCancel(context.Background())
+	l.track(deleting)
+	db.launches["deleting-remote"] = &store.Launch{ID: "deleting-remote", BastionID: "deleting-remote", CustomerID: customerID, Command: commandDeleteBastion, State: stateInProgress, Owner: "replica-1"}
+
+	assert.Equal(ErrLaunchNotCancellable, l.CancelLaunch(deleting.Bastion))
+	assert.NoError(deleting.ctx.Err())
+	assert.Equal(ErrLaunchNotCancellable, l.CancelLaunch(&com.Bastion{ID: "deleting-remote", CustomerID: customerID}))
+	assert.False(db.launch("deleting-remote").CancelRequested)
+
+	// the owner cancels the launches marked for it when it renews its claims
+	other := newTestLauncher(db)
+	running := &Launch{ID: "remote", Bastion: remote}
+	running.ctx, running.cancel = context.WithCancel(context.Background())
+	other.owner = "replica-1"
+	other.track(running)
+
+	assert.NoError(other.heartbeat())
+	assert.Error(running.ctx.Err())
+}
@@ -0,0 +1,203 @@
+package launcher
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/scanner"
+	"github.com/opsee/keelhaul/store"
+)
+
+const (
+	FindingPass = "pass"
+	FindingWarn = "warn"
+	FindingFail = "fail"
+
+	// customers' bastions were launched with per customer stacks before
+	// stacks were named per bastion
+	legacyStackPrefix = "opsee-stack-"
+)
+
+// Finding is the result of a single preflight check
+type Finding struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type preflight struct {
+	launch   *Launch
+	findings []*Finding
+}
+
+// Preflight runs the checks for the most common reasons a launch fails,
+// without creating anything in the customer's account
+func (launch *Launch) Preflight() []*Finding {
+	p := &preflight{launch: launch}
+
+	// everything else depends on the bastion config
+	if !p.checkStage("bastion_config", getBastionConfig{}) {
+		return p.findings
+	}
+	p.add("bastion_config", FindingPass, "found bastion config")
+
+	if p.checkStage("image", getLatestImageID{}) {
+		p.add("image", FindingPass, fmt.Sprintf("found bastion image: %s", launch.ImageID))
+	}
+
+	if p.checkStage("key_pair", validateKeyPair{}) && launch.KeyName != "" {
+		p.add("key_pair", FindingPass, fmt.Sprintf("found key pair: %s", launch.KeyName))
+	}
+
+	p.checkSubnet()
+	p.checkLimits()
+	p.checkStack()
+	p.checkTemplate()
+
+	if launch.ImageID != "" {
+		p.checkInstance()
+	}
+
+	return p.findings
+}
+
+func (p *preflight) add(check, status, message string) {
+	p.findings = append(p.findings, &Finding{
+		Check:   check,
+		Status:  status,
+		Message: message,
+	})
+}
+
+// checkStage runs a launch stage, which must not create anything, and
+// fails the check if the stage does
+func (p *preflight) checkStage(check string, st Stage) bool {
+	err := st.Execute(p.launch.ctx, p.launch)
+	if err != nil {
+		p.add(check, FindingFail, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (p *preflight) checkSubnet() {
+	subnet, routing, err := scanner.SubnetRouting(p.launch.session, p.launch.Bastion.SubnetID)
+	if err != nil {
+		p.add("subnet", FindingFail, err.Error())
+		return
+	}
+
+	if aws.StringValue(subnet.VpcId) != p.launch.Bastion.VPCID {
+		p.add("subnet", FindingFail, fmt.Sprintf("subnet %s is not in vpc %s", p.launch.Bastion.SubnetID, p.launch.Bastion.VPCID))
+		return
+	}
+
+	if aws.Int64Value(subnet.AvailableIpAddressCount) == 0 {
+		p.add("subnet", FindingFail, "subnet has no free ip addresses")
+	} else {
+		p.add("subnet", FindingPass, fmt.Sprintf("subnet has %d free ip addresses", aws.Int64Value(subnet.AvailableIpAddressCount)))
+	}
+
+	p.launch.Bastion.SubnetRouting = routing
+	switch routing {
+	case schema.RoutingStateOccluded:
+		p.add("subnet_routing", FindingFail, "subnet can't reach every instance in the vpc")
+	case schema.RoutingStatePrivate:
+		p.add("subnet_routing", FindingWarn, "subnet has no route to the internet, the bastion won't be able to connect")
+	default:
+		p.add("subnet_routing", FindingPass, fmt.Sprintf("subnet routing is %s", routing))
+	}
+}
+
+func (p *preflight) checkLimits() {
+	err := checkBastionLimits(p.launch.db, p.launch.config, p.launch.User, p.launch.Bastion.Region, p.launch.Bastion.VPCID)
+	switch err {
+	case nil:
+		p.add("bastion_limit", FindingPass, "bastion is within the customer's limits")
+	case store.ErrBastionExists, ErrBastionLimit:
+		p.add("bastion_limit", FindingFail, err.Error())
+	default:
+		p.add("bastion_limit", FindingWarn, fmt.Sprintf("couldn't check bastion limits: %s", err))
+	}
+}
+
+// checkStack looks for a stack under the name customers' bastions were
+// launched with before stacks were named per bastion. the bastion being
+// launched has no id yet, so its own stack name can't be checked, and a
+// legacy bastion that's still up would be in the way of the new one.
+func (p *preflight) checkStack() {
+	stackName := legacyStackPrefix + p.launch.Bastion.CustomerID
+
+	stacksOutput, err := p.launch.cloudformationClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
+			p.add("stack", FindingPass, fmt.Sprintf("no existing stack named %s", stackName))
+			return
+		}
+
+		p.add("stack", FindingWarn, fmt.Sprintf("couldn't check for an existing stack: %s", err))
+		return
+	}
+
+	for _, stack := range stacksOutput.Stacks {
+		status := aws.StringValue(stack.StackStatus)
+		if status == cloudformation.StackStatusDeleteComplete {
+			continue
+		}
+
+		p.add("stack", FindingFail, fmt.Sprintf("legacy stack %s already exists with status %s", stackName, status))
+		return
+	}
+
+	p.add("stack", FindingPass, fmt.Sprintf("no existing stack named %s", stackName))
+}
+
+func (p *preflight) checkTemplate() {
+	templateOutput, err := p.launch.cloudformationClient.ValidateTemplate(&cloudformation.ValidateTemplateInput{
+		TemplateBody: aws.String(p.launch.template().Body),
+	})
+
+	if err != nil {
+		p.add("template", FindingFail, err.Error())
+		return
+	}
+
+	p.add("template", FindingPass, "bastion cloudformation template is valid")
+
+	// iam permissions can't be tried without creating something, so the best
+	// we can do is point out that they're needed
+	for _, capability := range templateOutput.Capabilities {
+		if aws.StringValue(capability) == cloudformation.CapabilityCapabilityIam {
+			p.add("iam", FindingWarn, fmt.Sprintf("the stack requires permission to create iam resources: %s", aws.StringValue(templateOutput.CapabilitiesReason)))
+		}
+	}
+}
+
+func (p *preflight) checkInstance() {
+	_, err := ec2.New(p.launch.session).RunInstances(&ec2.RunInstancesInput{
+		DryRun:       aws.Bool(true),
+		ImageId:      aws.String(p.launch.ImageID),
+		InstanceType: aws.String(p.launch.Bastion.InstanceType),
+		SubnetId:     aws.String(p.launch.Bastion.SubnetID),
+		MinCount:     aws.Int64(1),
+		MaxCount:     aws.Int64(1),
+	})
+
+	awsErr, ok := err.(awserr.Error)
+	switch {
+	case ok && awsErr.Code() == "DryRunOperation":
+		p.add("instance", FindingPass, fmt.Sprintf("credentials can launch a %s instance", p.launch.Bastion.InstanceType))
+	case ok && awsErr.Code() == "UnauthorizedOperation":
+		p.add("instance", FindingFail, "credentials aren't permitted to launch instances")
+	case err != nil:
+		p.add("instance", FindingFail, err.Error())
+	}
+}
@@ -5,281 +5,231 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"sort"
 	"strings"
 	"text/template"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/cenkalti/backoff"
 	etcd "github.com/coreos/etcd/client"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/templates"
 	"golang.org/x/net/context"
-	"time"
 )
 
 type getBastionConfig struct{}
 
-func (s getBastionConfig) Execute(launch *Launch) {
-	response, err := launch.etcd.Get(context.Background(), launch.config.BastionConfigKey, &etcd.GetOptions{
+func (s getBastionConfig) Execute(ctx context.Context, launch *Launch) error {
+	response, err := launch.etcd.Get(ctx, launch.config.BastionConfigKey, &etcd.GetOptions{
 		Recursive: true,
 		Sort:      true,
 		Quorum:    true,
 	})
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed fetching bastion config from etcd",
 		})
-		return
 	}
 
 	bastionConfig := &BastionConfig{}
 	err = json.Unmarshal([]byte(response.Node.Value), bastionConfig)
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed unmarshaling bastion config",
 		})
-		return
 	}
 
 	bastionConfig.ModifiedIndex = response.Node.ModifiedIndex
 	launch.bastionConfig = bastionConfig
 	launch.event(&bus.Message{
 		State:   stateInProgress,
-		Command: commandLaunchBastion,
+		Command: launch.command,
 		Message: "generated bastion config",
 	})
-}
-
-type ImageList []*ec2.Image
 
-func (l ImageList) Len() int           { return len(l) }
-func (l ImageList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
-func (l ImageList) Less(i, j int) bool { return *l[i].CreationDate > *l[j].CreationDate }
+	return nil
+}
 
 type getLatestImageID struct{}
 
-func (s getLatestImageID) Execute(launch *Launch) {
-	// We use our own access-key and secret-key here, because for whatever
-	// reason, customers can't find our AMIs like this even when they're public.
-	creds := credentials.NewChainCredentials(
-		[]credentials.Provider{
-			&ec2rolecreds.EC2RoleProvider{
-				Client: ec2metadata.New(session.New()),
-			},
-			&credentials.EnvProvider{},
-		},
-	)
-
-	ec2client := ec2.New(session.New(&aws.Config{
-		Credentials: creds,
-		MaxRetries:  aws.Int(11),
-		Region:      launch.session.Config.Region,
-	}))
-
+func (s getLatestImageID) Execute(ctx context.Context, launch *Launch) error {
 	tag := launch.ImageTag
 	if tag == "" {
 		tag = launch.bastionConfig.Tag
 	}
 
-	imageOutput, err := ec2client.DescribeImages(&ec2.DescribeImagesInput{
-		Owners: []*string{
-			aws.String(launch.bastionConfig.OwnerID),
-		},
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("tag:release"),
-				Values: []*string{aws.String(tag)},
-			},
-			{
-				Name:   aws.String("is-public"),
-				Values: []*string{aws.String("true")},
-			},
-		},
+	image, err := launch.images.Resolve(&ImageRequest{
+		Region:        aws.StringValue(launch.session.Config.Region),
+		Tag:           tag,
+		CustomerID:    launch.User.CustomerId,
+		BastionConfig: launch.bastionConfig,
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed to get list of bastion images",
 		})
-		return
 	}
 
-	if len(imageOutput.Images) == 0 {
-		launch.error(
-			fmt.Errorf("No images with ownerID=%s and tag:release=%s found.", launch.bastionConfig.OwnerID, tag),
-			&bus.Message{
-				Command: commandLaunchBastion,
-				Message: "failed to get list of bastion images",
-			})
-		return
-	}
+	launch.setOutput(func() {
+		launch.ImageID = image.ID
+	})
 
-	// sort in descending order
-	sort.Sort(ImageList(imageOutput.Images))
-	launch.ImageID = *imageOutput.Images[0].ImageId
 	launch.event(&bus.Message{
 		State:   stateInProgress,
-		Command: commandLaunchBastion,
-		Message: fmt.Sprintf("got latest stable bastion image: %s", launch.ImageID),
+		Command: launch.command,
+		Message: fmt.Sprintf("resolved bastion image %s from %s", image.ID, image.Source),
+		Attributes: map[string]interface{}{
+			"image_id":     image.ID,
+			"image_source": image.Source,
+		},
 	})
-}
 
-type createTopic struct{}
-
-func (s createTopic) Execute(launch *Launch) {
-	var (
-		topic *sns.CreateTopicOutput
-		err   error
-	)
+	return nil
+}
 
-	err = backoff.Retry(func() error {
-		topic, err = launch.snsClient.CreateTopic(&sns.CreateTopicInput{
-			Name: aws.String("opsee-stack-" + launch.User.CustomerId),
-		})
+type validateKeyPair struct{}
 
-		if err != nil {
-			return err
-		}
+func (s validateKeyPair) Execute(ctx context.Context, launch *Launch) error {
+	keyName := launch.KeyName
+	if keyName == "" {
+		keyName = launch.bastionConfig.KeyPair
+	}
 
+	if keyName == "" {
 		return nil
+	}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
+	_, err := ec2.New(launch.session).DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String(keyName)},
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
-			Message: "failed creating topic",
-		})
-	} else {
-		launch.createTopicOutput = topic
-		launch.event(&bus.Message{
-			State:   stateInProgress,
-			Command: commandLaunchBastion,
-			Message: fmt.Sprintf("created sns topic: %s", *topic.TopicArn),
+		// the config's key pair is only a default, customers without it
+		// get a bastion without a key pair
+		if launch.KeyName == "" {
+			launch.logger.WithError(err).Warnf("default key pair %s not available", keyName)
+			return nil
+		}
+
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: fmt.Sprintf("key pair %s not found in region", keyName),
 		})
 	}
+
+	launch.setOutput(func() {
+		launch.KeyName = keyName
+	})
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("using key pair: %s", keyName),
+	})
+
+	return nil
 }
 
-type createQueue struct{}
+type createTopic struct{}
 
-func (s createQueue) Execute(launch *Launch) {
-	var (
-		queue *sqs.CreateQueueOutput
-		err   error
-	)
+func (s createTopic) Execute(ctx context.Context, launch *Launch) error {
+	topic, err := launch.snsClient.CreateTopic(&sns.CreateTopicInput{
+		Name: aws.String(launch.stackName()),
+	})
 
-	err = backoff.Retry(func() error {
-		queue, err = launch.sqsClient.CreateQueue(&sqs.CreateQueueInput{
-			QueueName: aws.String("opsee-bastion-launch-sqs" + launch.Bastion.ID),
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed creating topic",
 		})
+	}
 
-		if err != nil {
-			return err
-		}
+	launch.setOutput(func() {
+		launch.createTopicOutput = topic
+	})
 
-		return nil
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("created sns topic: %s", *topic.TopicArn),
+	})
+
+	return nil
+}
+
+type createQueue struct{}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
+func (s createQueue) Execute(ctx context.Context, launch *Launch) error {
+	queue, err := launch.sqsClient.CreateQueue(&sqs.CreateQueueInput{
+		QueueName: aws.String("opsee-bastion-launch-sqs" + launch.Bastion.ID),
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed creating sqs queue",
 		})
-	} else {
-		launch.createQueueOutput = queue
-		launch.event(&bus.Message{
-			State:   stateInProgress,
-			Command: commandLaunchBastion,
-			Message: fmt.Sprintf("created sqs queue: %s", *queue.QueueUrl),
-		})
 	}
-}
 
-type getQueueAttributes struct{}
-
-func (s getQueueAttributes) Execute(launch *Launch) {
-	var (
-		queueAttributes *sqs.GetQueueAttributesOutput
-		err             error
-	)
+	launch.setOutput(func() {
+		launch.createQueueOutput = queue
+	})
 
-	err = backoff.Retry(func() error {
-		queueAttributes, err = launch.sqsClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
-			QueueUrl: launch.createQueueOutput.QueueUrl,
-			AttributeNames: []*string{
-				aws.String("QueueArn"),
-			},
-		})
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("created sqs queue: %s", *queue.QueueUrl),
+	})
 
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		return nil
+type getQueueAttributes struct{}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
+func (s getQueueAttributes) Execute(ctx context.Context, launch *Launch) error {
+	queueAttributes, err := launch.sqsClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl: launch.createQueueOutput.QueueUrl,
+		AttributeNames: []*string{
+			aws.String("QueueArn"),
+		},
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed to get sqs queue attributes",
 		})
-		return
 	}
 
 	_, ok := queueAttributes.Attributes["QueueArn"]
 	if !ok {
-		launch.error(
+		return stageFailure(
 			fmt.Errorf("no queue ARN found in queue attributes"),
 			&bus.Message{
-				Command: commandLaunchBastion,
+				Command: launch.command,
 				Message: "failed to get queue attributes",
 			},
 		)
-		return
 	}
 
-	launch.getQueueAttributesOutput = queueAttributes
+	launch.setOutput(func() {
+		launch.getQueueAttributesOutput = queueAttributes
+	})
+
 	launch.event(&bus.Message{
 		State:   stateInProgress,
-		Command: commandLaunchBastion,
+		Command: launch.command,
 		Message: "got sqs queue attributes",
 	})
+
+	return nil
 }
 
 const policyStr = `{
@@ -304,7 +254,7 @@ var policyTmpl = template.Must(template.New("policy").Parse(policyStr))
 
 type setQueueAttributes struct{}
 
-func (s setQueueAttributes) Execute(launch *Launch) {
+func (s setQueueAttributes) Execute(ctx context.Context, launch *Launch) error {
 	buf := bytes.NewBuffer([]byte{})
 	err := policyTmpl.Execute(buf, map[string]string{
 		"policyID": launch.Bastion.ID,
@@ -313,119 +263,152 @@ func (s setQueueAttributes) Execute(launch *Launch) {
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed to generate sqs policy",
 		})
-		return
 	}
 
-	var (
-		sqa *sqs.SetQueueAttributesOutput
-	)
+	sqa, err := launch.sqsClient.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: launch.createQueueOutput.QueueUrl,
+		Attributes: map[string]*string{
+			"Policy": aws.String(buf.String()),
+		},
+	})
 
-	err = backoff.Retry(func() error {
-		sqa, err = launch.sqsClient.SetQueueAttributes(&sqs.SetQueueAttributesInput{
-			QueueUrl: launch.createQueueOutput.QueueUrl,
-			Attributes: map[string]*string{
-				"Policy": aws.String(buf.String()),
-			},
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed setting sqs queue attributes",
 		})
+	}
 
-		if err != nil {
-			return err
-		}
+	launch.setQueueAttributesOutput = sqa
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: "set sqs queue attributes",
+	})
 
-		return nil
+	return nil
+}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
+type subscribe struct{}
+
+func (s subscribe) Execute(ctx context.Context, launch *Launch) error {
+	subscribeOutput, err := launch.snsClient.Subscribe(&sns.SubscribeInput{
+		Protocol: aws.String("sqs"),
+		TopicArn: launch.createTopicOutput.TopicArn,
+		Endpoint: launch.getQueueAttributesOutput.Attributes["QueueArn"],
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
-			Message: "failed setting sqs queue attributes",
-		})
-	} else {
-		launch.setQueueAttributesOutput = sqa
-		launch.event(&bus.Message{
-			State:   stateInProgress,
-			Command: commandLaunchBastion,
-			Message: "set sqs queue attributes",
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed subscribing to sns topic",
 		})
 	}
+
+	launch.subscribeOutput = subscribeOutput
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: "subscribed to sns topic",
+	})
+
+	return nil
 }
 
-type subscribe struct{}
+// stackParameters are the parameters a workflow sends with a template,
+// in the order they're sent
+type stackParameters []string
+
+var bastionStackParameters = stackParameters{
+	"ImageId",
+	"InstanceType",
+	"UserData",
+	"VpcId",
+	"SubnetId",
+	"AssociatePublicIpAddress",
+	"CustomerId",
+	"BastionId",
+	"KeyName",
+	"AllowSSH",
+	"BastionIngressTemplateUrl",
+}
 
-func (s subscribe) Execute(launch *Launch) {
-	var (
-		subscribeOutput *sns.SubscribeOutput
-		err             error
-	)
+// bastionHAStackParameters are sent with the HA template, whose autoscaling
+// group spans a list of subnets rather than a single one
+var bastionHAStackParameters = stackParameters{
+	"ImageId",
+	"InstanceType",
+	"UserData",
+	"VpcId",
+	"SubnetIds",
+	"AssociatePublicIpAddress",
+	"CustomerId",
+	"BastionId",
+	"KeyName",
+	"AllowSSH",
+	"BastionIngressTemplateUrl",
+}
 
-	err = backoff.Retry(func() error {
-		subscribeOutput, err = launch.snsClient.Subscribe(&sns.SubscribeInput{
-			Protocol: aws.String("sqs"),
-			TopicArn: launch.createTopicOutput.TopicArn,
-			Endpoint: launch.getQueueAttributesOutput.Attributes["QueueArn"],
-		})
+func (p stackParameters) build(values map[string]string) ([]*cloudformation.Parameter, error) {
+	if len(values) != len(p) {
+		return nil, fmt.Errorf("expected %d stack parameters, got %d", len(p), len(values))
+	}
 
-		if err != nil {
-			return err
+	params := make([]*cloudformation.Parameter, len(p))
+	for i, key := range p {
+		value, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("missing stack parameter %s", key)
 		}
 
-		return nil
+		params[i] = &cloudformation.Parameter{
+			ParameterKey:   aws.String(key),
+			ParameterValue: aws.String(value),
+		}
+	}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
-	})
+	return params, nil
+}
 
+// ValidateTemplates checks the parameters we send against the ones the
+// embedded templates declare, so that a mismatch fails at startup rather
+// than on a customer's launch
+func ValidateTemplates() error {
+	err := templates.Bastion.ValidateParameters(bastionStackParameters)
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
-			Message: "failed subscribing to sns topic",
-		})
-	} else {
-		launch.subscribeOutput = subscribeOutput
-		launch.event(&bus.Message{
-			State:   stateInProgress,
-			Command: commandLaunchBastion,
-			Message: "subscribed to sns topic",
-		})
+		return err
 	}
+
+	return templates.BastionHA.ValidateParameters(bastionHAStackParameters)
+}
+
+// ha is whether the launch spans several subnets
+func (launch *Launch) ha() bool {
+	return len(launch.SubnetIDs) > 1
+}
+
+// template is the template the launch's stack is created from
+func (launch *Launch) template() *templates.Template {
+	if launch.ha() {
+		return templates.BastionHA
+	}
+
+	return templates.Bastion
 }
 
 type createStack struct{}
 
-func (s createStack) Execute(launch *Launch) {
+func (s createStack) Execute(ctx context.Context, launch *Launch) error {
 	userdata, err := launch.GenerateUserData()
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed to generate bastion userdata",
 		})
-		return
-	}
-
-	templateBytes, err := ioutil.ReadFile("/bastion-cf.template")
-	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
-			Message: "failed to read bastion cloudformation template",
-		})
-		return
 	}
 
 	associateIP := "False"
@@ -433,117 +416,120 @@ func (s createStack) Execute(launch *Launch) {
 		associateIP = "True"
 	}
 
-	stackParameters := []*cloudformation.Parameter{
-		{
-			ParameterKey:   aws.String("ImageId"),
-			ParameterValue: aws.String(launch.ImageID),
-		},
-		{
-			ParameterKey:   aws.String("InstanceType"),
-			ParameterValue: aws.String(launch.Bastion.InstanceType),
-		},
-		{
-			ParameterKey:   aws.String("UserData"),
-			ParameterValue: aws.String(base64.StdEncoding.EncodeToString(userdata)),
-		},
-		{
-			ParameterKey:   aws.String("VpcId"),
-			ParameterValue: aws.String(launch.Bastion.VPCID),
-		},
-		{
-			ParameterKey:   aws.String("SubnetId"),
-			ParameterValue: aws.String(launch.Bastion.SubnetID),
-		},
-		{
-			ParameterKey:   aws.String("AssociatePublicIpAddress"),
-			ParameterValue: aws.String(associateIP),
-		},
-		{
-			ParameterKey:   aws.String("CustomerId"),
-			ParameterValue: aws.String(launch.User.CustomerId),
-		},
-		{
-			ParameterKey:   aws.String("BastionId"),
-			ParameterValue: aws.String(launch.Bastion.ID),
-		},
+	allowSSH := "False"
+	if launch.AllowSSH || launch.bastionConfig.AllowSSH {
+		allowSSH = "True"
 	}
 
-	var (
-		stack *cloudformation.CreateStackOutput
-	)
+	values := map[string]string{
+		"ImageId":                   launch.ImageID,
+		"InstanceType":              launch.Bastion.InstanceType,
+		"UserData":                  base64.StdEncoding.EncodeToString(userdata),
+		"VpcId":                     launch.Bastion.VPCID,
+		"SubnetId":                  launch.Bastion.SubnetID,
+		"AssociatePublicIpAddress":  associateIP,
+		"CustomerId":                launch.User.CustomerId,
+		"BastionId":                 launch.Bastion.ID,
+		"KeyName":                   launch.KeyName,
+		"AllowSSH":                  allowSSH,
+		"BastionIngressTemplateUrl": launch.ingressTemplateURL(),
+	}
 
-	err = backoff.Retry(func() error {
-		stack, err = launch.cloudformationClient.CreateStack(&cloudformation.CreateStackInput{
-			StackName:    aws.String("opsee-stack-" + launch.User.CustomerId),
-			TemplateBody: aws.String(string(templateBytes)),
-			Capabilities: []*string{
-				aws.String("CAPABILITY_IAM"),
-			},
-			Parameters: stackParameters,
-			Tags: []*cloudformation.Tag{
-				{
-					Key:   aws.String("Name"),
-					Value: aws.String("Opsee Stack"),
-				},
-				{
-					Key:   aws.String("vendor"),
-					Value: aws.String("Opsee"),
-				},
-				{
-					Key:   aws.String("opsee:customer-id"),
-					Value: aws.String(launch.User.CustomerId),
-				},
-			},
-			NotificationARNs: []*string{
-				launch.createTopicOutput.TopicArn,
-			},
-		})
+	parameters := bastionStackParameters
+	if launch.ha() {
+		parameters = bastionHAStackParameters
+		delete(values, "SubnetId")
+		values["SubnetIds"] = strings.Join(launch.SubnetIDs, ",")
+	}
 
-		if err != nil {
-			return err
-		}
+	stackParameters, err := parameters.build(values)
 
-		return nil
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed to build cloudformation stack parameters",
+		})
+	}
 
-	}, &backoff.ExponentialBackOff{
-		InitialInterval:     100 * time.Millisecond,
-		RandomizationFactor: 0.5,
-		Multiplier:          1.5,
-		MaxInterval:         time.Second,
-		MaxElapsedTime:      5 * time.Second,
-		Clock:               &systemClock{},
+	tags, err := launch.stackTags()
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed to get customer tags",
+		})
+	}
+
+	stack, err := launch.cloudformationClient.CreateStack(&cloudformation.CreateStackInput{
+		StackName:    aws.String(launch.stackName()),
+		TemplateBody: aws.String(launch.template().Body),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_IAM"),
+		},
+		Parameters: stackParameters,
+		Tags:       tags,
+		NotificationARNs: []*string{
+			launch.createTopicOutput.TopicArn,
+		},
 	})
 
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed creating cloudformation stack",
 		})
-	} else {
-		launch.createStackOutput = stack
-		launch.event(&bus.Message{
-			State:   stateInProgress,
-			Command: commandLaunchBastion,
-			Message: "launched cloudformation stack",
-		})
 	}
+
+	launch.setOutput(func() {
+		launch.createStackOutput = stack
+	})
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: "launched cloudformation stack",
+	})
+
+	return nil
 }
 
 type bastionLaunchingState struct{}
 
-func (s bastionLaunchingState) Execute(launch *Launch) {
+func (s bastionLaunchingState) Execute(ctx context.Context, launch *Launch) error {
 	err := launch.db.UpdateBastion(launch.Bastion.Launch(*launch.createStackOutput.StackId, launch.ImageID))
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed saving bastion object",
 		})
 	}
+
+	err = launch.db.UpdateBastionTemplateVersion(launch.Bastion.ID, launch.template().Version)
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed saving bastion template version",
+		})
+	}
+
+	return nil
 }
 
-type consumeSQS struct{}
+// consumeSQS follows the stack's events through the launch's sns topic and sqs
+// queue until the stack reaches a terminal state. The messages published along
+// the way are set by the workflow that uses it.
+type consumeSQS struct {
+	inProgress string
+	complete   string
+	failed     string
+}
 
-func (s consumeSQS) Execute(launch *Launch) {
+var launchStackMessages = consumeSQS{
+	inProgress: "launching cloudformation stack",
+	complete:   "cloudformation stack launch complete",
+	failed:     "cloudformation failed to launch",
+}
+
+func (s consumeSQS) Execute(ctx context.Context, launch *Launch) error {
 	var (
 		state  = "launching"
 		reason string
@@ -555,13 +541,20 @@ func (s consumeSQS) Execute(launch *Launch) {
 	}
 
 	for {
+		// a receive can long poll for up to 20 seconds, so this is as
+		// soon as we'll notice a cancelled launch
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
 		messages, err := launch.sqsClient.ReceiveMessage(msgInput)
 		if err != nil {
-			launch.error(err, &bus.Message{
-				Command: commandLaunchBastion,
+			return stageFailure(err, &bus.Message{
+				Command: launch.command,
 				Message: "failed receiving messages from sqs queue",
 			})
-			return
 		}
 
 		for _, message := range messages.Messages {
@@ -569,11 +562,10 @@ func (s consumeSQS) Execute(launch *Launch) {
 			err = json.Unmarshal([]byte(*message.Body), &msg)
 
 			if err != nil {
-				launch.error(err, &bus.Message{
-					Command: commandLaunchBastion,
+				return stageFailure(err, &bus.Message{
+					Command: launch.command,
 					Message: "failed decoding message from sqs queue",
 				})
-				return
 			}
 
 			_, err = launch.sqsClient.DeleteMessage(&sqs.DeleteMessageInput{
@@ -582,11 +574,10 @@ func (s consumeSQS) Execute(launch *Launch) {
 			})
 
 			if err != nil {
-				launch.error(err, &bus.Message{
-					Command: commandLaunchBastion,
+				return stageFailure(err, &bus.Message{
+					Command: launch.command,
 					Message: "failed deleting message from sqs queue",
 				})
-				return
 			}
 
 			m, ok := msg["Message"].(string)
@@ -597,44 +588,49 @@ func (s consumeSQS) Execute(launch *Launch) {
 			cfMessage := make(cfMessage)
 			err = parseCloudFormation(&cfMessage, m)
 			if err != nil {
-				launch.error(err, &bus.Message{
-					Command: commandLaunchBastion,
+				return stageFailure(err, &bus.Message{
+					Command: launch.command,
 					Message: "failed parsing cloudformation message",
 				})
-				return
 			}
 
-			state = cfMessage.state("opsee-stack-" + launch.User.CustomerId)
-			if state == cfFailed {
+			// the first failure is the cause, the ones after it are
+			// mostly resources cancelled because of it
+			state = cfMessage.state(launch.stackName())
+			if (state == cfFailed || state == cfRollback) && reason == "" {
 				reason, _ = cfMessage["ResourceStatusReason"].(string)
 			}
 
 			if state == cfRollback {
-				launch.error(
+				err = launch.db.UpdateBastionFailureReason(launch.Bastion.ID, reason)
+				if err != nil {
+					launch.logger.WithError(err).Error("failed saving bastion failure reason")
+				}
+
+				return stageFailure(
 					fmt.Errorf(reason),
 					&bus.Message{
-						Command:    commandLaunchBastion,
-						Message:    "cloudformation failed to launch",
+						Command:    launch.command,
+						Message:    s.failed,
 						Attributes: cfMessage,
 					},
 				)
-				return
 			}
 
 			if state == cfComplete {
 				launch.event(&bus.Message{
 					State:      stateInProgress,
-					Command:    commandLaunchBastion,
-					Message:    "cloudformation stack launch complete",
+					Command:    launch.command,
+					Message:    s.complete,
 					Attributes: cfMessage,
 				})
-				return
+				return nil
 			}
 
 			launch.event(&bus.Message{
 				State:      stateInProgress,
-				Command:    commandLaunchBastion,
-				Message:    "launching cloudformation stack",
+				Command:    launch.command,
+				Message:    s.inProgress,
 				Attributes: cfMessage,
 			})
 		}
@@ -661,19 +657,22 @@ func (cf cfMessage) state(stackName string) string {
 	typ, _ = cf["ResourceType"].(string)
 	id, _ = cf["LogicalResourceId"].(string)
 
-	if status == "CREATE_FAILED" {
-		return cfFailed
-	}
-
+	// a stack that fails to delete doesn't roll back, so DELETE_FAILED on the
+	// stack itself is as final as a completed rollback
 	if typ == "AWS::CloudFormation::Stack" && id == stackName {
 		switch status {
-		case "CREATE_COMPLETE":
+		case "CREATE_COMPLETE", "DELETE_COMPLETE", "UPDATE_COMPLETE":
 			return cfComplete
-		case "ROLLBACK_COMPLETE":
+		case "ROLLBACK_COMPLETE", "DELETE_FAILED", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED":
 			return cfRollback
 		}
 	}
 
+	switch status {
+	case "CREATE_FAILED", "DELETE_FAILED", "UPDATE_FAILED":
+		return cfFailed
+	}
+
 	return cfLaunching
 }
 
@@ -697,7 +696,7 @@ func parseCloudFormation(cf *cfMessage, message string) error {
 
 type bastionActiveState struct{}
 
-func (s bastionActiveState) Execute(launch *Launch) {
+func (s bastionActiveState) Execute(ctx context.Context, launch *Launch) error {
 	var (
 		instanceID *string
 		groupID    *string
@@ -706,16 +705,15 @@ func (s bastionActiveState) Execute(launch *Launch) {
 
 	for {
 		stackResourcesOutput, err := launch.cloudformationClient.ListStackResources(&cloudformation.ListStackResourcesInput{
-			StackName: aws.String("opsee-stack-" + launch.User.CustomerId),
+			StackName: aws.String(launch.stackName()),
 			NextToken: nextToken,
 		})
 
 		if err != nil {
-			launch.error(err, &bus.Message{
-				Command: commandLaunchBastion,
+			return stageFailure(err, &bus.Message{
+				Command: launch.command,
 				Message: "failed retrieving launched stack info",
 			})
-			return
 		}
 
 		for _, s := range stackResourcesOutput.StackResourceSummaries {
@@ -734,16 +732,17 @@ func (s bastionActiveState) Execute(launch *Launch) {
 	instanceID = aws.String("not used")
 	err := launch.db.UpdateBastion(launch.Bastion.Activate(*instanceID, *groupID))
 	if err != nil {
-		launch.error(err, &bus.Message{
-			Command: commandLaunchBastion,
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
 			Message: "failed saving bastion object",
 		})
-		return
 	}
 
 	launch.event(&bus.Message{
 		State:   stateComplete,
-		Command: commandLaunchBastion,
+		Command: launch.command,
 		Message: "bastion activation complete",
 	})
+
+	return nil
 }
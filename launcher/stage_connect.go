@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/opsee/basic/com"
 	"github.com/opsee/keelhaul/bus"
+	"golang.org/x/net/context"
 	"math"
 	"time"
 )
@@ -16,18 +17,16 @@ const (
 
 type waitConnect struct{}
 
-func (s waitConnect) Execute(launch *Launch) {
+func (s waitConnect) Execute(ctx context.Context, launch *Launch) error {
 	for {
 		if launch.connectAttempts > connectAttempts {
-			launch.error(
+			return stageFailure(
 				fmt.Errorf("timed out waiting for bastion to connect"),
 				&bus.Message{
 					Command: commandConnectBastion,
 					Message: "timed out waiting for bastion to connect",
 				},
 			)
-
-			return
 		}
 
 		services, _ := launch.router.GetServices(launch.Bastion)
@@ -40,7 +39,7 @@ func (s waitConnect) Execute(launch *Launch) {
 				Message: "bastion active and connected",
 			})
 
-			return
+			return nil
 		}
 
 		launch.event(&bus.Message{
@@ -49,7 +48,12 @@ func (s waitConnect) Execute(launch *Launch) {
 			Message: "waiting for bastion connection",
 		})
 
-		time.Sleep(decay(launch.connectAttempts))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(decay(launch.connectAttempts)):
+		}
+
 		launch.connectAttempts = launch.connectAttempts + float64(1)
 	}
 }
@@ -1,12 +1,17 @@
 package launcher
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/aws/aws-sdk-go/service/rds"
 	"github.com/opsee/awscan"
 	opsee_cloudwatch "github.com/opsee/basic/schema/aws/cloudwatch"
 	"github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/autocheck"
 	"github.com/opsee/keelhaul/bus"
 	opsee_types "github.com/opsee/protobuf/opseeproto/types"
 	"golang.org/x/net/context"
@@ -22,7 +27,7 @@ const (
 	maxPages               = 10
 )
 
-func (s vpcDiscovery) Execute(launch *Launch) {
+func (s vpcDiscovery) Execute(ctx context.Context, launch *Launch) error {
 	var (
 		instances   = make(map[string]bool)
 		dbInstances = make(map[string]bool)
@@ -38,6 +43,12 @@ func (s vpcDiscovery) Execute(launch *Launch) {
 	// fetch all cloudwatch alarms up-front for use in autocheck creation
 	cwAlarms := fetchAlarms(launch)
 
+	// instances only get autochecks for customers that have them turned on,
+	// either by us or in their policy
+	policy := launch.autocheckPolicy()
+	launch.Autochecks.Policy = policy
+	ec2Autochecks := policy.Instances.IsEnabled(launch.bastionConfig != nil && launch.bastionConfig.EC2Autochecks[launch.User.CustomerId])
+
 	for event := range disco.Discover() {
 		if event.Err != nil {
 			switch event.Err.(*awscan.DiscoveryError).Type {
@@ -63,10 +74,13 @@ func (s vpcDiscovery) Execute(launch *Launch) {
 					continue
 				}
 
+				// instances are discovered through each of their security groups
+				seen := instances[*i.InstanceId]
 				instances[*i.InstanceId] = true
 				launch.VPCEnvironment.InstanceCount = card(instances)
-				// disable until frontend is ready (mike)
-				//launch.Autochecks.AddTarget(event.Result)
+				if ec2Autochecks && !seen {
+					launch.Autochecks.AddTargetWithAlarms(event.Result, filterAlarms(cwAlarms, "AWS/EC2"))
+				}
 
 			case awscan.DBInstanceType:
 				// we'll have to de-dupe instances so use a ghetto set (map)
@@ -103,12 +117,18 @@ func (s vpcDiscovery) Execute(launch *Launch) {
 		}
 	}
 
+	s.discoverTargetGroups(ctx, launch)
+
+	// the results don't matter to a cancelled launch
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	if launch.VPCEnvironment.tooManyErrors() {
-		launch.error(launch.VPCEnvironment.LastError, &bus.Message{
+		return stageFailure(launch.VPCEnvironment.LastError, &bus.Message{
 			Command: commandDiscovery,
 			Message: "too many discovery errors",
 		})
-		return
 	}
 
 	launch.event(&bus.Message{
@@ -116,6 +136,48 @@ func (s vpcDiscovery) Execute(launch *Launch) {
 		Command: commandDiscovery,
 		Message: "vpc environment discovery complete",
 	})
+
+	return nil
+}
+
+// discoverTargetGroups finds the vpc's application load balancers and the
+// target groups they send traffic to, awscan only knows classic load balancers.
+// customers' policies may not allow describing them, so errors don't count
+// against the discovery.
+func (s vpcDiscovery) discoverTargetGroups(ctx context.Context, launch *Launch) {
+	client := elbv2.New(launch.session)
+
+	err := client.DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, lb := range page.LoadBalancers {
+			if aws.StringValue(lb.VpcId) == launch.Bastion.VPCID && aws.StringValue(lb.Type) == elbv2.LoadBalancerTypeEnumApplication {
+				launch.VPCEnvironment.ApplicationLoadBalancerCount++
+			}
+		}
+
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		s.handleError(err, launch)
+		return
+	}
+
+	err = client.DescribeTargetGroupsPages(&elbv2.DescribeTargetGroupsInput{}, func(page *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+		for _, group := range page.TargetGroups {
+			// groups that no load balancer uses don't get health checked
+			if aws.StringValue(group.VpcId) != launch.Bastion.VPCID || len(group.LoadBalancerArns) == 0 {
+				continue
+			}
+
+			launch.VPCEnvironment.TargetGroupCount++
+			launch.Autochecks.AddTarget(group)
+			launch.logger.WithField("resource-type", "TargetGroup").Info("resource discovery")
+		}
+
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		s.handleError(err, launch)
+	}
 }
 
 // we have a custom error handler for this stage, since errors may be recoverable
@@ -142,6 +204,29 @@ func card(m map[string]bool) int {
 	return i
 }
 
+// autocheckPolicy is the customer's policy for autochecks, the defaults when
+// they don't have one or it can't be read
+func (launch *Launch) autocheckPolicy() *autocheck.Policy {
+	policy := &autocheck.Policy{}
+
+	record, err := launch.db.GetAutocheckPolicy(launch.User.CustomerId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			launch.logger.WithError(err).Error("couldn't get autocheck policy, using the defaults")
+		}
+
+		return policy
+	}
+
+	err = json.Unmarshal(record.Policy, policy)
+	if err != nil {
+		launch.logger.WithError(err).Error("couldn't decode autocheck policy, using the defaults")
+		return &autocheck.Policy{}
+	}
+
+	return policy
+}
+
 func fetchAlarms(launch *Launch) []*opsee_cloudwatch.MetricAlarm {
 	var (
 		next      *string
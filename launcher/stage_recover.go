@@ -0,0 +1,138 @@
+package launcher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/keelhaul/bus"
+	"golang.org/x/net/context"
+)
+
+// stacks left in these states can't be launched over and only go away when
+// they're deleted
+var failedStackStatuses = map[string]bool{
+	cloudformation.StackStatusRollbackComplete: true,
+	cloudformation.StackStatusDeleteFailed:     true,
+}
+
+// recoverPollInterval is how often a deleted stack is checked on, the stacks
+// we delete were launched with topics that are gone by now
+var recoverPollInterval = 5 * time.Second
+
+type recoverStacks struct{}
+
+// Execute deletes the bastion's stack when an earlier attempt at the launch
+// left it behind in a failed state, so that the launch can create it again.
+// Other bastions' failed stacks are left to the reaper.
+func (s recoverStacks) Execute(ctx context.Context, launch *Launch) error {
+	output, err := launch.cloudformationClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(launch.stackName()),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" && strings.HasSuffix(awsErr.Message(), "does not exist") {
+			return nil
+		}
+
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed retrieving cloudformation stack",
+		})
+	}
+
+	if len(output.Stacks) == 0 || !failedStackStatuses[aws.StringValue(output.Stacks[0].StackStatus)] {
+		return nil
+	}
+
+	stack := output.Stacks[0]
+	err = launch.deleteFailedStack(ctx, stack)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: fmt.Sprintf("failed deleting failed cloudformation stack %s", aws.StringValue(stack.StackName)),
+		})
+	}
+
+	return nil
+}
+
+// deleteFailedStack deletes the stack and follows it until it's gone,
+// publishing its status as it changes
+func (launch *Launch) deleteFailedStack(ctx context.Context, stack *cloudformation.Stack) error {
+	var (
+		name   = aws.StringValue(stack.StackName)
+		status = aws.StringValue(stack.StackStatus)
+	)
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("deleting failed cloudformation stack: %s", name),
+		Attributes: map[string]interface{}{
+			"stack_name":   name,
+			"stack_status": status,
+			"reason":       aws.StringValue(stack.StackStatusReason),
+		},
+	})
+
+	// stacks are deleted by id, their names can be reused once they're gone
+	_, err := launch.cloudformationClient.DeleteStack(&cloudformation.DeleteStackInput{
+		StackName: stack.StackId,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	status = cloudformation.StackStatusDeleteInProgress
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(recoverPollInterval):
+		}
+
+		output, err := launch.cloudformationClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+			StackName: stack.StackId,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(output.Stacks) == 0 {
+			return fmt.Errorf("stack %s not found", aws.StringValue(stack.StackId))
+		}
+
+		current := aws.StringValue(output.Stacks[0].StackStatus)
+		if current == status {
+			continue
+		}
+
+		status = current
+		launch.event(&bus.Message{
+			State:   stateInProgress,
+			Command: launch.command,
+			Message: fmt.Sprintf("failed cloudformation stack %s: %s", name, status),
+			Attributes: map[string]interface{}{
+				"stack_name":   name,
+				"stack_status": status,
+			},
+		})
+
+		switch status {
+		case cloudformation.StackStatusDeleteComplete:
+			return nil
+		case cloudformation.StackStatusDeleteFailed:
+			return fmt.Errorf("%s", aws.StringValue(output.Stacks[0].StackStatusReason))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package launcher
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/keelhaul/bus"
+	"golang.org/x/net/context"
+)
+
+var deleteStackMessages = consumeSQS{
+	inProgress: "deleting cloudformation stack",
+	complete:   "cloudformation stack delete complete",
+	failed:     "cloudformation failed to delete",
+}
+
+type bastionDisabledState struct{}
+
+func (s bastionDisabledState) Execute(ctx context.Context, launch *Launch) error {
+	launch.Bastion.State = com.BastionStateDisabled
+	err := launch.db.UpdateBastion(launch.Bastion)
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed saving bastion object",
+		})
+	}
+
+	err = launch.db.UpdateTrackingState(launch.Bastion.ID, com.BastionStateDisabled)
+	if err != nil {
+		// bastions that never connected have no tracking state
+		launch.logger.WithError(err).Warn("failed updating bastion tracking state")
+	}
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: "disabled bastion",
+	})
+
+	return nil
+}
+
+type deleteStack struct{}
+
+func (s deleteStack) Execute(ctx context.Context, launch *Launch) error {
+	// the stack id outlives the stack itself, so prefer it to the name
+	stackName := launch.stackName()
+	if launch.Bastion.StackID.Valid {
+		stackName = launch.Bastion.StackID.String
+	}
+
+	stacksOutput, err := launch.cloudformationClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
+			launch.event(&bus.Message{
+				State:   stateInProgress,
+				Command: launch.command,
+				Message: "no cloudformation stack found",
+			})
+			return nil
+		}
+
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed retrieving cloudformation stack",
+		})
+	}
+
+	if len(stacksOutput.Stacks) == 0 || aws.StringValue(stacksOutput.Stacks[0].StackStatus) == cloudformation.StackStatusDeleteComplete {
+		launch.event(&bus.Message{
+			State:   stateInProgress,
+			Command: launch.command,
+			Message: "no cloudformation stack found",
+		})
+		return nil
+	}
+
+	// the stack still notifies the topic it was launched with, and since topic
+	// arns are derived from their names, our new topic receives its events
+	stack, err := launch.cloudformationClient.DeleteStack(&cloudformation.DeleteStackInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed deleting cloudformation stack",
+		})
+	}
+
+	launch.deleteStackOutput = stack
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: "deleting cloudformation stack",
+	})
+
+	return nil
+}
+
+type waitStackDelete struct{}
+
+func (s waitStackDelete) Execute(ctx context.Context, launch *Launch) error {
+	// nothing to wait on if there was no stack to delete
+	if launch.deleteStackOutput == nil {
+		return nil
+	}
+
+	return deleteStackMessages.Execute(ctx, launch)
+}
+
+type bastionDeletedState struct{}
+
+func (s bastionDeletedState) Execute(ctx context.Context, launch *Launch) error {
+	launch.Bastion.State = com.BastionStateDeleted
+	err := launch.db.UpdateBastion(launch.Bastion)
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed saving bastion object",
+		})
+	}
+
+	err = launch.db.UpdateTrackingState(launch.Bastion.ID, com.BastionStateDeleted)
+	if err != nil {
+		launch.logger.WithError(err).Warn("failed updating bastion tracking state")
+	}
+
+	launch.event(&bus.Message{
+		State:   stateComplete,
+		Command: launch.command,
+		Message: "bastion deletion complete",
+	})
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package launcher
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/keelhaul/bus"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+var updateStackMessages = consumeSQS{
+	inProgress: "updating cloudformation stack",
+	complete:   "cloudformation stack update complete",
+	failed:     "cloudformation failed to update",
+}
+
+type updateStack struct{}
+
+func (s updateStack) Execute(ctx context.Context, launch *Launch) error {
+	stacksOutput, err := launch.cloudformationClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(launch.stackName()),
+	})
+
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed retrieving cloudformation stack",
+		})
+	}
+
+	if len(stacksOutput.Stacks) == 0 {
+		return stageFailure(fmt.Errorf("stack %s not found", launch.stackName()), &bus.Message{
+			Command: launch.command,
+			Message: "failed retrieving cloudformation stack",
+		})
+	}
+
+	// the userdata carries the vpn password and we only keep its hash, so the
+	// new instance gets a new password. its hash is saved once the update is
+	// issued, since the new instance can come up before the update completes.
+	password, passwordHash, err := generatePassword()
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed creating bastion credentials",
+		})
+	}
+
+	launch.Bastion.Password = password
+	userdata, err := launch.GenerateUserData()
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed to generate bastion userdata",
+		})
+	}
+
+	stackParameters := make([]*cloudformation.Parameter, 0, len(stacksOutput.Stacks[0].Parameters))
+	for _, p := range stacksOutput.Stacks[0].Parameters {
+		switch aws.StringValue(p.ParameterKey) {
+		case "ImageId":
+			stackParameters = append(stackParameters, &cloudformation.Parameter{
+				ParameterKey:   p.ParameterKey,
+				ParameterValue: aws.String(launch.ImageID),
+			})
+		case "UserData":
+			stackParameters = append(stackParameters, &cloudformation.Parameter{
+				ParameterKey:   p.ParameterKey,
+				ParameterValue: aws.String(base64.StdEncoding.EncodeToString(userdata)),
+			})
+		default:
+			stackParameters = append(stackParameters, &cloudformation.Parameter{
+				ParameterKey:     p.ParameterKey,
+				UsePreviousValue: aws.Bool(true),
+			})
+		}
+	}
+
+	// the customer's tags may have changed since the stack was created
+	tags, err := launch.stackTags()
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed to get customer tags",
+		})
+	}
+
+	stack, err := launch.cloudformationClient.UpdateStack(&cloudformation.UpdateStackInput{
+		StackName:           aws.String(launch.stackName()),
+		UsePreviousTemplate: aws.Bool(true),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_IAM"),
+		},
+		Parameters: stackParameters,
+		Tags:       tags,
+		NotificationARNs: []*string{
+			launch.createTopicOutput.TopicArn,
+		},
+	})
+
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed updating cloudformation stack",
+		})
+	}
+
+	launch.setOutput(func() {
+		launch.updateStackOutput = stack
+		launch.previousPasswordHash = launch.Bastion.PasswordHash
+	})
+
+	launch.Bastion.PasswordHash = passwordHash
+	err = launch.db.UpdateBastion(launch.Bastion)
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed saving bastion object",
+		})
+	}
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("updating cloudformation stack to image: %s", launch.ImageID),
+	})
+
+	return nil
+}
+
+type currentImageID struct{}
+
+func (s currentImageID) Execute(ctx context.Context, launch *Launch) error {
+	if !launch.Bastion.ImageID.Valid {
+		return stageFailure(fmt.Errorf("bastion %s has no image", launch.Bastion.ID), &bus.Message{
+			Command: launch.command,
+			Message: "failed to get bastion image",
+		})
+	}
+
+	launch.setOutput(func() {
+		launch.ImageID = launch.Bastion.ImageID.String
+	})
+
+	launch.event(&bus.Message{
+		State:   stateInProgress,
+		Command: launch.command,
+		Message: fmt.Sprintf("keeping bastion image: %s", launch.ImageID),
+	})
+
+	return nil
+}
+
+type bastionUpgradedState struct{}
+
+func (s bastionUpgradedState) Execute(ctx context.Context, launch *Launch) error {
+	launch.Bastion.ImageID = sql.NullString{String: launch.ImageID, Valid: launch.ImageID != ""}
+	err := launch.db.UpdateBastion(launch.Bastion)
+	if err != nil {
+		return stageFailure(err, &bus.Message{
+			Command: launch.command,
+			Message: "failed saving bastion object",
+		})
+	}
+
+	launch.event(&bus.Message{
+		State:   stateComplete,
+		Command: launch.command,
+		Message: "bastion upgrade complete",
+	})
+
+	return nil
+}
+
+func generatePassword() (string, string, error) {
+	pwbytes := make([]byte, 18)
+	if _, err := rand.Read(pwbytes); err != nil {
+		return "", "", err
+	}
+
+	pw := base64.StdEncoding.EncodeToString(pwbytes)
+	pwhash, err := bcrypt.GenerateFromPassword([]byte(pw), 10)
+	if err != nil {
+		return "", "", err
+	}
+
+	return pw, string(pwhash), nil
+}
@@ -0,0 +1,104 @@
+package launcher
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/keelhaul/store"
+)
+
+const (
+	// stacks take 50 tags, opsee's own need a few of them
+	MaxCustomerTags = 40
+
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+var (
+	ErrTooManyTags  = errors.New("customers can have at most 40 tags.")
+	ErrInvalidTag   = errors.New("tag keys must be 1 to 128 characters and values at most 256, of letters, digits, spaces and _.:/=+-@.")
+	ErrReservedTag  = errors.New("Name, vendor and tags starting with opsee: or aws: are reserved.")
+	ErrDuplicateTag = errors.New("tag keys must be unique.")
+
+	tagRegexp = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+)
+
+// IsReservedTag is whether the tag is one opsee or aws sets, which customers
+// can't override
+func IsReservedTag(key string) bool {
+	lower := strings.ToLower(key)
+	return key == "Name" || key == "vendor" || strings.HasPrefix(lower, "opsee:") || strings.HasPrefix(lower, "aws:")
+}
+
+// ValidateTags checks customer tags against aws' limits on tags
+func ValidateTags(tags []*store.CustomerTag) error {
+	if len(tags) > MaxCustomerTags {
+		return ErrTooManyTags
+	}
+
+	keys := make(map[string]bool)
+	for _, t := range tags {
+		keyLength := utf8.RuneCountInString(t.Key)
+		if keyLength == 0 || keyLength > maxTagKeyLength || utf8.RuneCountInString(t.Value) > maxTagValueLength {
+			return ErrInvalidTag
+		}
+
+		if !tagRegexp.MatchString(t.Key) || !tagRegexp.MatchString(t.Value) {
+			return ErrInvalidTag
+		}
+
+		if IsReservedTag(t.Key) {
+			return ErrReservedTag
+		}
+
+		if keys[t.Key] {
+			return ErrDuplicateTag
+		}
+
+		keys[t.Key] = true
+	}
+
+	return nil
+}
+
+// stackTags are the customer's tags and opsee's. cloudformation puts stack
+// tags on every resource in the stack that can be tagged.
+func (launch *Launch) stackTags() ([]*cloudformation.Tag, error) {
+	customerTags, err := launch.db.ListCustomerTags(launch.User.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]*cloudformation.Tag, 0, len(customerTags)+3)
+	for _, t := range customerTags {
+		// tags from before a key was reserved
+		if IsReservedTag(t.Key) {
+			continue
+		}
+
+		tags = append(tags, &cloudformation.Tag{
+			Key:   aws.String(t.Key),
+			Value: aws.String(t.Value),
+		})
+	}
+
+	return append(tags,
+		&cloudformation.Tag{
+			Key:   aws.String("Name"),
+			Value: aws.String("Opsee Stack"),
+		},
+		&cloudformation.Tag{
+			Key:   aws.String("vendor"),
+			Value: aws.String("Opsee"),
+		},
+		&cloudformation.Tag{
+			Key:   aws.String("opsee:customer-id"),
+			Value: aws.String(launch.User.CustomerId),
+		},
+	), nil
+}
@@ -0,0 +1,88 @@
+package launcher
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func tags(kv ...string) []*store.CustomerTag {
+	t := make([]*store.CustomerTag, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		t = append(t, &store.CustomerTag{Key: kv[i], Value: kv[i+1]})
+	}
+
+	return t
+}
+
+func manyTags(n int) []*store.CustomerTag {
+	t := make([]*store.CustomerTag, n)
+	for i := range t {
+		t[i] = &store.CustomerTag{Key: fmt.Sprintf("tag-%d", i)}
+	}
+
+	return t
+}
+
+var validateTagsTests = []struct {
+	tags []*store.CustomerTag
+	err  error
+}{
+	{tags(), nil},
+	{tags("cost-center", "1234", "owner", "ops@example.com", "env", ""), nil},
+	{tags("team name", "Ünicode/ok:+=_.-"), nil},
+	{manyTags(MaxCustomerTags), nil},
+	{manyTags(MaxCustomerTags + 1), ErrTooManyTags},
+	{tags("", "empty"), ErrInvalidTag},
+	{tags(strings.Repeat("k", 129), ""), ErrInvalidTag},
+	{tags("env", strings.Repeat("v", 257)), ErrInvalidTag},
+	{tags("env", "prod!"), ErrInvalidTag},
+	{tags("env#", "prod"), ErrInvalidTag},
+	{tags("Name", "my bastion"), ErrReservedTag},
+	{tags("vendor", "acme"), ErrReservedTag},
+	{tags("opsee:customer-id", "x"), ErrReservedTag},
+	{tags("Opsee:id", "x"), ErrReservedTag},
+	{tags("aws:cloudformation:stack-name", "x"), ErrReservedTag},
+	{tags("env", "prod", "env", "dev"), ErrDuplicateTag},
+}
+
+func TestValidateTags(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, tt := range validateTagsTests {
+		assert.Equal(tt.err, ValidateTags(tt.tags), fmt.Sprintf("test %d", i))
+	}
+}
+
+func TestStackTags(t *testing.T) {
+	assert := assert.New(t)
+
+	lt := newLaunchTest()
+	defer lt.close()
+
+	// a tag stored before its key was reserved doesn't override opsee's
+	lt.db.tags = tags("cost-center", "1234", "vendor", "acme")
+
+	err := lt.start()
+	assert.NoError(err)
+
+	stackTags, err := lt.launch.stackTags()
+	assert.NoError(err)
+
+	got := make(map[string]string)
+	for _, tag := range stackTags {
+		got[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	assert.Equal(map[string]string{
+		"cost-center":       "1234",
+		"Name":              "Opsee Stack",
+		"vendor":            "Opsee",
+		"opsee:customer-id": lt.launch.User.CustomerId,
+	}, got)
+	assert.Len(stackTags, 4)
+}
@@ -0,0 +1,402 @@
+package launcher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/opsee/keelhaul/bus"
+	"golang.org/x/net/context"
+)
+
+// retryPolicy is how the engine retries a failed stage
+type retryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+// shortRetry is for stages that make a quick api call or two
+var shortRetry = &retryPolicy{
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     time.Second,
+	MaxElapsedTime:  5 * time.Second,
+}
+
+func (p *retryPolicy) backOff() backoff.BackOff {
+	if p == nil {
+		return &backoff.StopBackOff{}
+	}
+
+	b := &backoff.ExponentialBackOff{
+		InitialInterval:     p.InitialInterval,
+		RandomizationFactor: 0.5,
+		Multiplier:          1.5,
+		MaxInterval:         p.MaxInterval,
+		MaxElapsedTime:      p.MaxElapsedTime,
+		Clock:               &systemClock{},
+	}
+	b.Reset()
+
+	return b
+}
+
+// stageSpec declares how a workflow runs a stage
+type stageSpec struct {
+	Stage Stage
+
+	// stages that have to finish first
+	After []Stage
+
+	// zero is no timeout. stages only notice it through their context, so
+	// a stage stuck in an api call times out once the call returns.
+	Timeout time.Duration
+
+	// nil is no retries. timed out stages aren't retried.
+	Retry *retryPolicy
+
+	// the workflow completes once its required stages have. optional
+	// stages that fail are reported without failing the launch.
+	Required bool
+
+	// stages whose output isn't checkpointed run again on resume
+	Rerun bool
+}
+
+func (s *stageSpec) name() string {
+	return stageName(s.Stage)
+}
+
+// workflow is a set of stages and their dependencies, stages run as soon as
+// the stages they depend on have finished
+type workflow struct {
+	specs  []*stageSpec
+	byName map[string]*stageSpec
+}
+
+// newWorkflow checks the specs, panicking on duplicate stages, unknown or
+// cyclic dependencies, and required stages that depend on optional ones.
+// workflows are package vars, so this happens at startup.
+func newWorkflow(specs ...*stageSpec) *workflow {
+	wf := &workflow{
+		specs:  specs,
+		byName: make(map[string]*stageSpec, len(specs)),
+	}
+
+	for _, spec := range specs {
+		if _, ok := wf.byName[spec.name()]; ok {
+			panic(fmt.Sprintf("workflow: duplicate stage %s", spec.name()))
+		}
+
+		wf.byName[spec.name()] = spec
+	}
+
+	for _, spec := range specs {
+		for _, dep := range spec.After {
+			d, ok := wf.byName[stageName(dep)]
+			if !ok {
+				panic(fmt.Sprintf("workflow: stage %s depends on unknown stage %s", spec.name(), stageName(dep)))
+			}
+
+			if spec.Required && !d.Required {
+				panic(fmt.Sprintf("workflow: required stage %s depends on optional stage %s", spec.name(), d.name()))
+			}
+		}
+
+		if wf.ancestors(spec.name())[spec.name()] {
+			panic(fmt.Sprintf("workflow: stage %s depends on itself", spec.name()))
+		}
+	}
+
+	return wf
+}
+
+// ancestors is every stage the named stage depends on, directly or not
+func (wf *workflow) ancestors(name string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := []string{name}
+
+	for len(queue) > 0 {
+		spec, ok := wf.byName[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+
+		for _, dep := range spec.After {
+			n := stageName(dep)
+			if !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	return seen
+}
+
+// completed is the set of stages a checkpoint at the named stage implies
+// have finished: the stage itself and the stages it depends on. stages that
+// ran alongside it may have finished too, so they have to be safe to repeat.
+// a checkpoint at a stage the workflow doesn't have leaves nothing to do.
+func (wf *workflow) completed(name string) map[string]bool {
+	if name == "" {
+		return make(map[string]bool)
+	}
+
+	if _, ok := wf.byName[name]; !ok {
+		done := make(map[string]bool, len(wf.specs))
+		for _, spec := range wf.specs {
+			done[spec.name()] = true
+		}
+
+		return done
+	}
+
+	done := wf.ancestors(name)
+	done[name] = true
+
+	return done
+}
+
+// stageError is a stage's failure and the message it's reported with
+type stageError struct {
+	err error
+	msg *bus.Message
+}
+
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+// stageFailure is what stages return when they fail, msg is published
+// with the failed state once the engine gives up on the stage
+func stageFailure(err error, msg *bus.Message) error {
+	return &stageError{err: err, msg: msg}
+}
+
+type stageResult struct {
+	spec *stageSpec
+	err  error
+}
+
+// run executes the workflow's stages that aren't done yet, and completes the
+// launch once all of its required stages have. a failed required stage fails
+// the launch and stops the stages still running. the launch is only failed or
+// cancelled once they've returned, so that winding it down doesn't race them
+// for the stack, topic and queue.
+func (launch *Launch) run(wf *workflow, done map[string]bool) {
+	ctx, stop := context.WithCancel(launch.ctx)
+	defer stop()
+
+	finished := make(map[string]bool)
+	for _, spec := range wf.specs {
+		if done[spec.name()] && !spec.Rerun {
+			finished[spec.name()] = true
+		}
+	}
+
+	var (
+		started = make(map[string]bool)
+		running = 0
+		results = make(chan *stageResult, len(wf.specs))
+		failure *stageResult
+	)
+
+	for {
+		if launch.ctx.Err() != nil || failure != nil {
+			stop()
+			for ; running > 0; running-- {
+				<-results
+			}
+
+			if launch.ctx.Err() != nil {
+				launch.cancelOnce.Do(launch.cancelled)
+			} else {
+				launch.stageFailed(failure.spec, failure.err)
+			}
+
+			return
+		}
+
+		if launch.State() == stateFailed {
+			return
+		}
+
+		if wf.requiredFinished(finished) {
+			launch.finish()
+			return
+		}
+
+		for _, spec := range wf.specs {
+			if finished[spec.name()] || started[spec.name()] || !wf.ready(spec, finished) {
+				continue
+			}
+
+			started[spec.name()] = true
+			running++
+
+			go func(spec *stageSpec) {
+				results <- &stageResult{spec: spec, err: launch.runStage(ctx, spec)}
+			}(spec)
+		}
+
+		if running == 0 {
+			launch.error(fmt.Errorf("no stages left to run"), &bus.Message{
+				Command: launch.command,
+				Message: "launch workflow stalled",
+			})
+			return
+		}
+
+		result := <-results
+		running--
+
+		// a cancelled launch is wound down at the top of the loop
+		if launch.ctx.Err() != nil {
+			continue
+		}
+
+		if result.err != nil {
+			if result.spec.Required {
+				failure = result
+			} else {
+				launch.stageFailed(result.spec, result.err)
+			}
+
+			continue
+		}
+
+		finished[result.spec.name()] = true
+		if launch.State() == stateInProgress {
+			launch.checkpoint(wf.frontier(finished), stateInProgress)
+		}
+	}
+}
+
+func (wf *workflow) ready(spec *stageSpec, finished map[string]bool) bool {
+	for _, dep := range spec.After {
+		if !finished[stageName(dep)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (wf *workflow) requiredFinished(finished map[string]bool) bool {
+	for _, spec := range wf.specs {
+		if spec.Required && !finished[spec.name()] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// frontier is the finished stage that the most other stages lead up to,
+// which is what gets checkpointed
+func (wf *workflow) frontier(finished map[string]bool) string {
+	var (
+		name string
+		most = -1
+	)
+
+	for _, spec := range wf.specs {
+		if !finished[spec.name()] {
+			continue
+		}
+
+		if n := len(wf.ancestors(spec.name())); n > most {
+			name, most = spec.name(), n
+		}
+	}
+
+	return name
+}
+
+// runStage executes the stage, retrying it according to its policy
+func (launch *Launch) runStage(ctx context.Context, spec *stageSpec) error {
+	var (
+		name  = spec.name()
+		b     = spec.Retry.backOff()
+		start = time.Now()
+	)
+
+	launch.stageEvent(name, "starting stage", 1)
+
+	for attempt := 1; ; attempt++ {
+		stageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if spec.Timeout > 0 {
+			stageCtx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		}
+
+		err := spec.Stage.Execute(stageCtx, launch)
+		timedOut := stageCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if timedOut {
+			return stageFailure(fmt.Errorf("%s timed out after %s", name, spec.Timeout), &bus.Message{
+				Command: launch.command,
+				Message: fmt.Sprintf("timed out waiting for %s", name),
+			})
+		}
+
+		if err == nil {
+			launch.stageEvent(name, fmt.Sprintf("finished stage in %s", time.Since(start)), attempt)
+			return nil
+		}
+
+		next := b.NextBackOff()
+		if next == backoff.Stop {
+			return err
+		}
+
+		launch.logger.WithError(err).WithField("stage", name).Warn("stage failed, retrying")
+		launch.stageEvent(name, fmt.Sprintf("retrying stage in %s", next), attempt+1)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(next):
+		}
+	}
+}
+
+// stageFailed fails the launch if the stage was required, otherwise the
+// failure is only reported
+func (launch *Launch) stageFailed(spec *stageSpec, err error) {
+	se, ok := err.(*stageError)
+	if !ok {
+		se = &stageError{err: err, msg: &bus.Message{
+			Command: launch.command,
+			Message: fmt.Sprintf("%s failed", spec.name()),
+		}}
+	}
+
+	if spec.Required {
+		launch.error(se.err, se.msg)
+		return
+	}
+
+	launch.logger.WithError(se.err).WithField("stage", spec.name()).Warn(se.msg.Message)
+	launch.stageEvent(spec.name(), fmt.Sprintf("optional stage failed: %s", se.msg.Message), 0)
+}
+
+func (launch *Launch) stageEvent(name, message string, attempt int) {
+	attrs := map[string]interface{}{"stage": name}
+	if attempt > 0 {
+		attrs["attempt"] = attempt
+	}
+
+	launch.event(&bus.Message{
+		State:      stateInProgress,
+		Command:    launch.command,
+		Message:    fmt.Sprintf("%s: %s", name, message),
+		Attributes: attrs,
+	})
+}
@@ -0,0 +1,133 @@
+package leader
+
+import (
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	log "github.com/opsee/logrus"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const (
+	checkRate = time.Duration(30) * time.Second
+	lockTTL   = time.Duration(60) * time.Second
+)
+
+// Run elects one keelhaul process to do some work, with a lock at key. Every
+// 30 seconds until ctx is done, it takes the lock if it's free, or keeps it
+// with compare-and-swap if it's ours, and calls fn with whether it's ours.
+// (a watcher would be more responsive, but the delay here is acceptable)
+//
+// while fn runs as leader the lock keeps being renewed on the same schedule,
+// and the context fn gets is cancelled if the lock is lost, so work that
+// outlasts the lock's ttl stops before another process starts doing it too.
+func Run(ctx context.Context, kapi etcd.KeysAPI, key string, fn func(ctx context.Context, leading bool)) {
+	e := &elector{
+		etcd: kapi,
+		key:  key,
+		id:   uuid.NewV4().String(),
+		rate: checkRate,
+	}
+
+	ticker := time.NewTicker(e.rate)
+	defer ticker.Stop()
+
+	for {
+		if e.offer(ctx) {
+			e.lead(ctx, fn)
+		} else {
+			fn(ctx, false)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lead calls fn as leader, renewing the lock in the background until fn
+// returns, and cancelling fn's context if a renewal fails
+func (e *elector) lead(ctx context.Context, fn func(ctx context.Context, leading bool)) {
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		done    = make(chan struct{})
+		renewed = make(chan struct{})
+	)
+
+	go func() {
+		defer close(renewed)
+
+		ticker := time.NewTicker(e.rate)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !e.offer(leadCtx) {
+					log.WithFields(log.Fields{"id": e.id, "key": e.key}).Warn("lost leader lock")
+					cancel()
+					return
+				}
+			case <-done:
+				return
+			case <-leadCtx.Done():
+				return
+			}
+		}
+	}()
+
+	fn(leadCtx, true)
+	close(done)
+	<-renewed
+}
+
+type elector struct {
+	etcd    etcd.KeysAPI
+	key     string
+	id      string
+	rate    time.Duration
+	leading bool
+}
+
+func (e *elector) offer(ctx context.Context) bool {
+	opts := &etcd.SetOptions{
+		PrevExist: etcd.PrevNoExist,
+		TTL:       lockTTL,
+	}
+
+	if e.leading {
+		opts = &etcd.SetOptions{
+			PrevValue: e.id,
+			TTL:       lockTTL,
+		}
+	}
+
+	_, err := e.etcd.Set(ctx, e.key, e.id, opts)
+	if err == nil {
+		if !e.leading {
+			log.WithFields(log.Fields{"id": e.id, "key": e.key}).Info("took leader lock")
+		}
+
+		e.leading = true
+		return true
+	}
+
+	e.leading = false
+	if etcdErr, ok := err.(etcd.Error); ok {
+		switch etcdErr.Code {
+		case etcd.ErrorCodeTestFailed, etcd.ErrorCodeNodeExist:
+			return false
+		}
+	}
+
+	if err != context.Canceled {
+		log.WithError(err).WithField("key", e.key).Error("unexpected etcd error")
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+package leader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// fakeEtcd holds a single key, honouring the set options the elector uses
+type fakeEtcd struct {
+	etcd.KeysAPI
+	sync.Mutex
+	value string
+	err   error
+}
+
+func (e *fakeEtcd) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	if opts.PrevExist == etcd.PrevNoExist && e.value != "" {
+		return nil, etcd.Error{Code: etcd.ErrorCodeNodeExist}
+	}
+
+	if opts.PrevValue != "" && opts.PrevValue != e.value {
+		return nil, etcd.Error{Code: etcd.ErrorCodeTestFailed}
+	}
+
+	e.value = value
+	return &etcd.Response{Node: &etcd.Node{Key: key, Value: value}}, nil
+}
+
+func TestOffer(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ctx    = context.Background()
+		e      = &fakeEtcd{}
+		first  = &elector{etcd: e, key: "lock", id: "first"}
+		second = &elector{etcd: e, key: "lock", id: "second"}
+	)
+
+	assert.True(first.offer(ctx))
+	assert.False(second.offer(ctx))
+	assert.True(first.offer(ctx))
+
+	// the lock expires while the first process can't reach etcd
+	e.err = errors.New("connection refused")
+	assert.False(first.offer(ctx))
+
+	e.err = nil
+	e.value = ""
+	assert.True(second.offer(ctx))
+	assert.False(first.offer(ctx))
+	assert.True(second.offer(ctx))
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &fakeEtcd{value: "someone else"}
+
+	calls := make([]bool, 0)
+	Run(ctx, e, "lock", func(ctx context.Context, leading bool) {
+		calls = append(calls, leading)
+		cancel()
+	})
+
+	// it returns once the context is done
+	assert.Equal([]bool{false}, calls)
+}
+
+func TestLead(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ctx = context.Background()
+		e   = &fakeEtcd{}
+		el  = &elector{etcd: e, key: "lock", id: "first", rate: time.Millisecond}
+	)
+
+	assert.True(el.offer(ctx))
+
+	// the lock is renewed while fn runs
+	el.lead(ctx, func(ctx context.Context, leading bool) {
+		time.Sleep(10 * time.Millisecond)
+		assert.True(leading)
+		assert.NoError(ctx.Err())
+	})
+	assert.True(el.leading)
+
+	// and fn's context is cancelled once it's lost
+	el.lead(ctx, func(ctx context.Context, leading bool) {
+		e.Lock()
+		e.value = "second"
+		e.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+
+		assert.Equal(context.Canceled, ctx.Err())
+	})
+	assert.False(el.leading)
+}
@@ -0,0 +1,23 @@
+create table launches (
+    id UUID primary key not null,
+    bastion_id UUID not null,
+    customer_id UUID not null,
+    command character varying(32) not null,
+    stage character varying(64) not null default '',
+    state character varying(24) not null,
+    region character varying(24) not null,
+    user_json jsonb not null,
+    image_tag character varying(64) not null default '',
+    image_id character varying(256) not null default '',
+    topic_arn character varying(256) not null default '',
+    queue_url character varying(256) not null default '',
+    queue_arn character varying(256) not null default '',
+    stack_id character varying(256) not null default '',
+    password_hash character varying(60) not null default '',
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create index idx_launches_bastions on launches (bastion_id);
+create index idx_launches_state on launches (state);
+create trigger update_launches before update on launches for each row execute procedure update_time();
@@ -0,0 +1,20 @@
+-- bastions from before region was stored get it from their stack or launch
+update bastions set region = split_part(stack_id, ':', 4)
+ where region = '' and stack_id like 'arn:aws:cloudformation:%';
+update bastions set region = launches.region from launches
+ where bastions.region = '' and launches.bastion_id = bastions.id;
+
+-- launches that stopped long ago aren't coming back
+update bastions set state = 'failed'
+ where state in ('new', 'launching') and updated_at < now() - interval '1 hour';
+
+-- whatever is left over keeps the vpc's active bastion, or its newest
+update bastions set state = 'failed' where id in (
+  select id from (
+    select id, row_number() over (partition by customer_id, region, vpc_id
+     order by state = 'active' desc, created_at desc) as rank
+    from bastions where state in ('new', 'launching', 'active')
+  ) running where rank > 1
+);
+
+create unique index idx_bastions_running_vpc on bastions (customer_id, region, vpc_id) where state in ('new', 'launching', 'active');
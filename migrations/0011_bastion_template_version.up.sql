@@ -0,0 +1 @@
+alter table bastions add column template_version character varying(64) not null default '';
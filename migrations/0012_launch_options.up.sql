@@ -0,0 +1,2 @@
+alter table launches add column key_name character varying(256) not null default '';
+alter table launches add column allow_ssh boolean not null default false;
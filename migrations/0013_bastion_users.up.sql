@@ -0,0 +1,7 @@
+create table bastion_users (
+    customer_id UUID not null,
+    username character varying(32) not null,
+    key text not null,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    primary key (customer_id, username, key)
+);
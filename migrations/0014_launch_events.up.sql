@@ -0,0 +1,14 @@
+create table launch_events (
+    id bigserial primary key,
+    launch_id UUID not null,
+    bastion_id UUID not null,
+    customer_id UUID not null,
+    command character varying(32) not null,
+    state character varying(24) not null,
+    message text not null default '',
+    attributes jsonb,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create index idx_launch_events_bastions on launch_events (bastion_id, created_at);
+create index idx_launch_events_launches on launch_events (launch_id);
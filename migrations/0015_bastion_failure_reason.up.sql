@@ -0,0 +1 @@
+alter table bastions add column failure_reason text not null default '';
@@ -0,0 +1,12 @@
+create table reaper_actions (
+    id bigserial primary key,
+    customer_id UUID not null,
+    region character varying(24) not null,
+    resource_type character varying(32) not null,
+    resource text not null,
+    action character varying(32) not null,
+    error text not null default '',
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create index idx_reaper_actions_customers on reaper_actions (customer_id, created_at);
@@ -0,0 +1,25 @@
+create table heal_policies (
+    customer_id UUID primary key not null,
+    enabled boolean not null default false,
+    inactive_minutes integer not null,
+    max_per_day integer not null,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create trigger update_heal_policies before update on heal_policies for each row execute procedure update_time();
+
+create table heal_actions (
+    id UUID primary key not null,
+    bastion_id UUID not null,
+    customer_id UUID not null,
+    instance_id character varying(32) not null,
+    state character varying(24) not null,
+    error text not null default '',
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create index idx_heal_actions_customers on heal_actions (customer_id, created_at);
+create index idx_heal_actions_states on heal_actions (state);
+create trigger update_heal_actions before update on heal_actions for each row execute procedure update_time();
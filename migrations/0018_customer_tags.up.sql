@@ -0,0 +1,7 @@
+create table customer_tags (
+    customer_id UUID not null,
+    key character varying(128) not null,
+    value character varying(256) not null default '',
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    primary key (customer_id, key)
+);
@@ -0,0 +1 @@
+alter table launches add column subnet_ids text not null default '';
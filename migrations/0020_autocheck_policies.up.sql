@@ -0,0 +1,8 @@
+create table autocheck_policies (
+    customer_id UUID primary key not null,
+    policy jsonb not null default '{}',
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+create trigger update_autocheck_policies before update on autocheck_policies for each row execute procedure update_time();
@@ -0,0 +1,4 @@
+alter table launches add column owner character varying(64) not null default '';
+alter table launches add column heartbeat timestamp with time zone default now() not null;
+
+create index idx_launches_owner on launches (owner);
@@ -0,0 +1,9 @@
+-- only the newest of a bastion's launches in progress is still running
+update launches set state = 'failed' where id in (
+  select id from (
+    select id, row_number() over (partition by bastion_id order by created_at desc) as rank
+    from launches where state = 'in-progress'
+  ) running where rank > 1
+);
+
+create unique index idx_launches_in_progress on launches (bastion_id) where state = 'in-progress';
@@ -0,0 +1 @@
+alter table launches add column cancel_requested boolean not null default false;
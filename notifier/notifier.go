@@ -18,6 +18,8 @@ type Notifier interface {
 	NotifyError(int, interface{}) error
 	NotifySuccess(int, interface{}) error
 	NotifySlackBastionState(bool, string, map[string]interface{}) error
+	NotifySlackBastionDrift(string, map[string]interface{}) error
+	NotifySlackBastionHealFailed(string, map[string]interface{}) error
 }
 
 type notifier struct {
@@ -31,13 +33,114 @@ type notifier struct {
 const (
 	emailLaunchTemplate = "discovery-completion"
 	emailErrorTemplate  = "discovery-failure"
+
+	// there's no drift template upstream yet, this follows bastion-offline
+	bastionDriftTemplate = `{
+  "text": "*BASTION DRIFT*",
+  "username": "BastionTracker",
+  "icon_url": "https://s3-us-west-1.amazonaws.com/opsee-public-images/slack-avi-48-red.png",
+  "attachments": [
+    {
+      "color": "#ff9800",
+      "fields": [
+        {
+          "title": "User",
+          "value": "{{name}}",
+          "short": true
+        },
+        {
+          "title": "Email",
+          "value": "{{email}}",
+          "short": true
+        },
+        {
+          "title": "Customer ID",
+          "value": "{{customer_id}}",
+          "short": true
+        },
+        {
+          "title": "Bastion ID",
+          "value": "{{bastion_id}}",
+          "short": true
+        },
+        {
+          "title": "Previous State",
+          "value": "{{previous_state}}",
+          "short": true
+        },
+        {
+          "title": "Current State",
+          "value": "{{current_state}}",
+          "short": true
+        },
+        {
+          "title": "Drift",
+          "value": "{{drift}}",
+          "short": false
+        }
+      ]
+    }
+  ]
+}
+`
+
+	bastionHealFailedTemplate = `{
+  "text": "*BASTION HEAL FAILED*",
+  "username": "BastionTracker",
+  "icon_url": "https://s3-us-west-1.amazonaws.com/opsee-public-images/slack-avi-48-red.png",
+  "attachments": [
+    {
+      "color": "#f44336",
+      "fields": [
+        {
+          "title": "User",
+          "value": "{{name}}",
+          "short": true
+        },
+        {
+          "title": "Email",
+          "value": "{{email}}",
+          "short": true
+        },
+        {
+          "title": "Customer ID",
+          "value": "{{customer_id}}",
+          "short": true
+        },
+        {
+          "title": "Bastion ID",
+          "value": "{{bastion_id}}",
+          "short": true
+        },
+        {
+          "title": "Instance ID",
+          "value": "{{instance_id}}",
+          "short": true
+        },
+        {
+          "title": "Last Seen",
+          "value": "{{last_seen}}",
+          "short": true
+        },
+        {
+          "title": "Error",
+          "value": "{{error}}",
+          "short": false
+        }
+      ]
+    }
+  ]
+}
+`
 )
 
 var (
-	slackLaunchTemplate      *mustache.Template
-	slackErrorTemplate       *mustache.Template
-	slackBastionUpTemplate   *mustache.Template
-	slackBastionDownTemplate *mustache.Template
+	slackLaunchTemplate       *mustache.Template
+	slackErrorTemplate        *mustache.Template
+	slackBastionUpTemplate    *mustache.Template
+	slackBastionDownTemplate  *mustache.Template
+	slackBastionDriftTemplate *mustache.Template
+	slackBastionHealTemplate  *mustache.Template
 )
 
 func New(c *config.Config) *notifier {
@@ -74,9 +177,56 @@ func init() {
 		panic(err)
 	}
 	slackBastionDownTemplate = tmpl
+
+	tmpl, err = mustache.ParseString(bastionDriftTemplate)
+	if err != nil {
+		panic(err)
+	}
+	slackBastionDriftTemplate = tmpl
+
+	tmpl, err = mustache.ParseString(bastionHealFailedTemplate)
+	if err != nil {
+		panic(err)
+	}
+	slackBastionHealTemplate = tmpl
 }
 
 func (n *notifier) NotifySlackBastionState(isUp bool, custID string, notifyVars map[string]interface{}) error {
+	err := n.addUserInfo(custID, notifyVars)
+	if err != nil {
+		return err
+	}
+
+	if isUp {
+		return n.notifySlack(notifyVars, slackBastionUpTemplate, n.TrackerSlackEndpoint)
+	}
+	return n.notifySlack(notifyVars, slackBastionDownTemplate, n.TrackerSlackEndpoint)
+}
+
+// NotifySlackBastionDrift tells the tracker channel that a bastion's
+// resources no longer match what keelhaul launched
+func (n *notifier) NotifySlackBastionDrift(custID string, notifyVars map[string]interface{}) error {
+	err := n.addUserInfo(custID, notifyVars)
+	if err != nil {
+		return err
+	}
+
+	return n.notifySlack(notifyVars, slackBastionDriftTemplate, n.TrackerSlackEndpoint)
+}
+
+// NotifySlackBastionHealFailed tells the launch error channel that replacing
+// an inactive bastion's instance didn't bring it back
+func (n *notifier) NotifySlackBastionHealFailed(custID string, notifyVars map[string]interface{}) error {
+	err := n.addUserInfo(custID, notifyVars)
+	if err != nil {
+		return err
+	}
+
+	return n.notifySlack(notifyVars, slackBastionHealTemplate, n.LaunchesErrorSlackEndpoint)
+}
+
+// addUserInfo adds the customer's name and email from vape to the vars
+func (n *notifier) addUserInfo(custID string, notifyVars map[string]interface{}) error {
 	u, err := url.Parse(n.VapeUserInfoEndpoint)
 	if err != nil {
 		return err
@@ -110,10 +260,7 @@ func (n *notifier) NotifySlackBastionState(isUp bool, custID string, notifyVars
 	notifyVars["email"] = response["email"]
 	notifyVars["name"] = response["name"]
 
-	if isUp {
-		return n.notifySlack(notifyVars, slackBastionUpTemplate, n.TrackerSlackEndpoint)
-	}
-	return n.notifySlack(notifyVars, slackBastionDownTemplate, n.TrackerSlackEndpoint)
+	return nil
 }
 
 func (n *notifier) NotifySuccess(userID int, notifyVars interface{}) error {
@@ -0,0 +1,490 @@
+package reaper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/leader"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+)
+
+const (
+	reaperKeyPath = "/opsee.co/config/keelhaul/reaperLock"
+	minReapDelay  = time.Duration(1) * time.Hour
+
+	// launches are given up on after an hour, so anything older than this
+	// that no launch knows about isn't going to be cleaned up
+	gracePeriod = time.Duration(2) * time.Hour
+
+	commandReap      = "reap-launch-resources"
+	launchInProgress = "in-progress"
+
+	resourceTopic = "sns-topic"
+	resourceQueue = "sqs-queue"
+	resourceStack = "cloudformation-stack"
+	actionDelete  = "delete"
+
+	bastionTopicPrefix = "opsee-bastion-"
+	queuePrefix        = "opsee-bastion-launch-sqs"
+)
+
+// launch topics are named after the bastion's stack, which used to be
+// named per customer
+var topicPrefixes = []string{bastionTopicPrefix, "opsee-stack-"}
+
+// stacks left in these states by failed launches only go away when they're
+// deleted
+var failedStackStatuses = []*string{
+	aws.String(cloudformation.StackStatusRollbackComplete),
+	aws.String(cloudformation.StackStatusDeleteFailed),
+}
+
+type clients struct {
+	sns            snsiface.SNSAPI
+	sqs            sqsiface.SQSAPI
+	cloudformation cloudformationiface.CloudFormationAPI
+}
+
+type reaper struct {
+	db           store.Store
+	etcd         etcd.KeysAPI
+	bus          bus.Bus
+	spanx        service.SpanxClient
+	stop         context.CancelFunc
+	isServing    bool
+	lastReapTime time.Time
+	gracePeriod  time.Duration
+
+	// topics don't know when they were created, so their age is
+	// counted from when we first saw them orphaned
+	firstSeen map[string]time.Time
+	clients   func(*store.BastionRegion) *clients
+}
+
+// New returns a reaper that deletes the sns topics and sqs queues launches
+// leave behind in customer accounts when keelhaul dies before cleaning up,
+// and the stacks of launches that failed
+func New(db store.Store, etcdKAPI etcd.KeysAPI, bus bus.Bus, spanx service.SpanxClient) *reaper {
+	r := &reaper{
+		db:          db,
+		etcd:        etcdKAPI,
+		bus:         bus,
+		spanx:       spanx,
+		gracePeriod: gracePeriod,
+		firstSeen:   make(map[string]time.Time),
+	}
+
+	r.clients = r.regionClients
+	return r
+}
+
+func (r *reaper) Start() {
+	var ctx context.Context
+	ctx, r.stop = context.WithCancel(context.Background())
+
+	go leader.Run(ctx, r.etcd, reaperKeyPath, r.lead)
+}
+
+func (r *reaper) Stop() {
+	r.stop()
+}
+
+func (r *reaper) lead(ctx context.Context, leading bool) {
+	if !leading {
+		if r.isServing {
+			// whoever has the lock now keeps track of orphans themselves
+			r.firstSeen = make(map[string]time.Time)
+		}
+
+		r.isServing = false
+		return
+	}
+
+	r.isServing = true
+	if r.isTimeToReap() {
+		r.reap(ctx)
+		r.lastReapTime = time.Now()
+	}
+}
+
+func (r *reaper) isTimeToReap() bool {
+	return time.Now().Sub(r.lastReapTime) >= minReapDelay
+}
+
+func (r *reaper) regionClients(region *store.BastionRegion) *clients {
+	user := &schema.User{
+		Id:         int32(region.UserID),
+		CustomerId: region.CustomerID,
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(user, r.spanx),
+		Region:      aws.String(region.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	return &clients{
+		sns:            sns.New(sess),
+		sqs:            sqs.New(sess),
+		cloudformation: cloudformation.New(sess),
+	}
+}
+
+func (r *reaper) reap(ctx context.Context) {
+	log.Info("reaper thought leader")
+
+	launches, err := r.db.ListLaunches(&store.ListLaunchesRequest{State: []string{launchInProgress}})
+	if err != nil {
+		log.WithError(err).Error("failed to list in-flight launches")
+		return
+	}
+
+	regions, err := r.db.ListBastionRegions()
+	if err != nil {
+		log.WithError(err).Error("failed to list bastion regions")
+		return
+	}
+
+	var (
+		inFlight = inFlightResources(launches.Launches)
+		seen     = make(map[string]bool)
+	)
+
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			// the topics not seen yet weren't looked for
+			return
+		}
+
+		c := r.clients(region)
+		r.reapRegion(region, c.sns, c.sqs, inFlight, seen)
+		r.reapStacks(region, c.cloudformation, inFlight)
+	}
+
+	// topics that went away on their own don't need remembering
+	for arn := range r.firstSeen {
+		if !seen[arn] {
+			delete(r.firstSeen, arn)
+		}
+	}
+}
+
+// inFlightResources are the names of the topics, queues and stacks that
+// launches in progress use or will use. a launch only checkpoints its topic and
+// queue once it has created them, so the names its bastion id implies are
+// included. topics are named after their stacks.
+func inFlightResources(launches []*store.Launch) map[string]bool {
+	names := make(map[string]bool)
+	for _, launch := range launches {
+		names[bastionTopicPrefix+launch.BastionID] = true
+		names[queuePrefix+launch.BastionID] = true
+
+		if launch.TopicARN != "" {
+			names[topicName(launch.TopicARN)] = true
+		}
+
+		if launch.QueueURL != "" {
+			names[queueName(launch.QueueURL)] = true
+		}
+	}
+
+	return names
+}
+
+// arn:aws:sns:<region>:<account>:<name>
+func topicName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
+
+// https://sqs.<region>.amazonaws.com/<account>/<name>
+func queueName(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+func hasPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *reaper) reapRegion(region *store.BastionRegion, snsClient snsiface.SNSAPI, sqsClient sqsiface.SQSAPI, inFlight, seen map[string]bool) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": region.CustomerID,
+		"region":      region.Region,
+	})
+
+	var nextToken *string
+	for {
+		topics, err := snsClient.ListTopics(&sns.ListTopicsInput{NextToken: nextToken})
+		if err != nil {
+			logger.WithError(err).Error("failed to list sns topics")
+			break
+		}
+
+		for _, topic := range topics.Topics {
+			arn := aws.StringValue(topic.TopicArn)
+			name := topicName(arn)
+			if !hasPrefix(name, topicPrefixes) || inFlight[name] {
+				continue
+			}
+
+			seen[arn] = true
+			first, ok := r.firstSeen[arn]
+			if !ok {
+				r.firstSeen[arn] = time.Now()
+				continue
+			}
+
+			if time.Since(first) < r.gracePeriod {
+				continue
+			}
+
+			_, err = snsClient.DeleteTopic(&sns.DeleteTopicInput{TopicArn: topic.TopicArn})
+			if err == nil {
+				delete(r.firstSeen, arn)
+			}
+
+			r.report(region, resourceTopic, arn, bastionID(name), err)
+		}
+
+		nextToken = topics.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	queues, err := sqsClient.ListQueues(&sqs.ListQueuesInput{QueueNamePrefix: aws.String(queuePrefix)})
+	if err != nil {
+		logger.WithError(err).Error("failed to list sqs queues")
+		return
+	}
+
+	for _, url := range queues.QueueUrls {
+		name := queueName(aws.StringValue(url))
+		if inFlight[name] {
+			continue
+		}
+
+		created, err := queueCreatedAt(sqsClient, url)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to get sqs queue age: %s", aws.StringValue(url))
+			continue
+		}
+
+		if time.Since(created) < r.gracePeriod {
+			continue
+		}
+
+		_, err = sqsClient.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: url})
+		r.report(region, resourceQueue, aws.StringValue(url), strings.TrimPrefix(name, queuePrefix), err)
+	}
+}
+
+// reapStacks deletes the opsee stacks failed launches left behind, and marks
+// the failed or disabled bastions launched into them deleted. stacks are left
+// alone for the grace period after they last changed, which also spaces out
+// the retries of stacks whose deletion failed.
+func (r *reaper) reapStacks(region *store.BastionRegion, cfClient cloudformationiface.CloudFormationAPI, inFlight map[string]bool) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": region.CustomerID,
+		"region":      region.Region,
+	})
+
+	var nextToken *string
+	for {
+		stacks, err := cfClient.ListStacks(&cloudformation.ListStacksInput{
+			StackStatusFilter: failedStackStatuses,
+			NextToken:         nextToken,
+		})
+
+		if err != nil {
+			logger.WithError(err).Error("failed to list cloudformation stacks")
+			return
+		}
+
+		for _, stack := range stacks.StackSummaries {
+			name := aws.StringValue(stack.StackName)
+			if !hasPrefix(name, topicPrefixes) || inFlight[name] {
+				continue
+			}
+
+			if time.Since(stackChanged(stack)) < r.gracePeriod {
+				continue
+			}
+
+			// stacks are deleted by id, their names can be reused once they're gone
+			_, err = cfClient.DeleteStack(&cloudformation.DeleteStackInput{StackName: stack.StackId})
+			r.report(region, resourceStack, aws.StringValue(stack.StackId), bastionID(name), err)
+			if err == nil {
+				r.deleteStackBastions(region, stack)
+			}
+		}
+
+		nextToken = stacks.NextToken
+		if nextToken == nil {
+			return
+		}
+	}
+}
+
+func (r *reaper) deleteStackBastions(region *store.BastionRegion, stack *cloudformation.StackSummary) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": region.CustomerID,
+		"region":      region.Region,
+		"stack_id":    aws.StringValue(stack.StackId),
+	})
+
+	resp, err := r.db.ListBastions(&store.ListBastionsRequest{
+		CustomerID: region.CustomerID,
+		State:      []string{com.BastionStateFailed, com.BastionStateDisabled},
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to list failed and disabled bastions")
+		return
+	}
+
+	for _, bastion := range resp.Bastions {
+		// bastions that failed before saving their stack id have the stack's name
+		if bastion.Region != region.Region ||
+			(bastion.StackID.String != aws.StringValue(stack.StackId) && bastion.StackName() != aws.StringValue(stack.StackName)) {
+			continue
+		}
+
+		deleted, err := r.db.UpdateBastionState(bastion.ID, bastion.State, com.BastionStateDeleted)
+		if err != nil {
+			logger.WithError(err).WithField("bastion_id", bastion.ID).Error("failed to mark bastion deleted")
+			continue
+		}
+
+		if !deleted {
+			continue
+		}
+
+		err = r.db.UpdateTrackingState(bastion.ID, com.BastionStateDeleted)
+		if err != nil {
+			logger.WithError(err).WithField("bastion_id", bastion.ID).Warn("failed updating bastion tracking state")
+		}
+	}
+}
+
+// stackChanged is when the stack was last created, updated or deleted
+func stackChanged(stack *cloudformation.StackSummary) time.Time {
+	changed := aws.TimeValue(stack.CreationTime)
+	for _, t := range []*time.Time{stack.LastUpdatedTime, stack.DeletionTime} {
+		if t != nil && t.After(changed) {
+			changed = *t
+		}
+	}
+
+	return changed
+}
+
+func queueCreatedAt(sqsClient sqsiface.SQSAPI, url *string) (time.Time, error) {
+	output, err := sqsClient.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       url,
+		AttributeNames: []*string{aws.String("CreatedTimestamp")},
+	})
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seconds, err := strconv.ParseInt(aws.StringValue(output.Attributes["CreatedTimestamp"]), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+// bastionID is the bastion a topic was created for, legacy topics are per customer
+func bastionID(topicName string) string {
+	if strings.HasPrefix(topicName, bastionTopicPrefix) {
+		return strings.TrimPrefix(topicName, bastionTopicPrefix)
+	}
+
+	return ""
+}
+
+// report writes what the reaper did to the audit log and the customer's bus
+func (r *reaper) report(region *store.BastionRegion, resourceType, resource, bastionID string, err error) {
+	var (
+		state   = "complete"
+		message = fmt.Sprintf("deleted orphaned %s: %s", resourceType, resource)
+		errMsg  string
+		logger  = log.WithFields(log.Fields{
+			"customer_id":   region.CustomerID,
+			"region":        region.Region,
+			"resource_type": resourceType,
+			"resource":      resource,
+		})
+	)
+
+	if err != nil {
+		state = "failed"
+		message = fmt.Sprintf("failed deleting orphaned %s: %s", resourceType, resource)
+		errMsg = err.Error()
+		logger.WithError(err).Error(message)
+	} else {
+		logger.Info(message)
+	}
+
+	auditErr := r.db.PutReaperAction(&store.ReaperAction{
+		CustomerID:   region.CustomerID,
+		Region:       region.Region,
+		ResourceType: resourceType,
+		Resource:     resource,
+		Action:       actionDelete,
+		Error:        errMsg,
+	})
+
+	if auditErr != nil {
+		logger.WithError(auditErr).Error("failed to record reaper action")
+	}
+
+	attrs := map[string]interface{}{
+		"region":        region.Region,
+		"resource_type": resourceType,
+		"resource":      resource,
+	}
+
+	if errMsg != "" {
+		attrs["error"] = errMsg
+	}
+
+	busErr := r.bus.Publish(&bus.Message{
+		Command:    commandReap,
+		State:      state,
+		Message:    message,
+		Attributes: attrs,
+		CustomerID: region.CustomerID,
+		BastionID:  bastionID,
+	})
+
+	if busErr != nil {
+		logger.WithError(busErr).Error("failed to publish reaper action")
+	}
+}
@@ -0,0 +1,385 @@
+package reaper
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+const (
+	customerID = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+	region     = "us-west-2"
+)
+
+type fakeSNS struct {
+	snsiface.SNSAPI
+	topics  []string
+	deleted []string
+}
+
+func (f *fakeSNS) ListTopics(input *sns.ListTopicsInput) (*sns.ListTopicsOutput, error) {
+	output := &sns.ListTopicsOutput{}
+	for _, name := range f.topics {
+		output.Topics = append(output.Topics, &sns.Topic{
+			TopicArn: aws.String(fmt.Sprintf("arn:aws:sns:%s:123456789012:%s", region, name)),
+		})
+	}
+
+	return output, nil
+}
+
+func (f *fakeSNS) DeleteTopic(input *sns.DeleteTopicInput) (*sns.DeleteTopicOutput, error) {
+	name := topicName(aws.StringValue(input.TopicArn))
+	for i, topic := range f.topics {
+		if topic == name {
+			f.topics = append(f.topics[:i], f.topics[i+1:]...)
+			break
+		}
+	}
+
+	f.deleted = append(f.deleted, name)
+	return &sns.DeleteTopicOutput{}, nil
+}
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	// queue name -> created
+	queues  map[string]time.Time
+	deleted []string
+}
+
+func (f *fakeSQS) ListQueues(input *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	output := &sqs.ListQueuesOutput{}
+	for name := range f.queues {
+		output.QueueUrls = append(output.QueueUrls, aws.String(fmt.Sprintf("https://sqs.%s.amazonaws.com/123456789012/%s", region, name)))
+	}
+
+	return output, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(input *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	created := f.queues[queueName(aws.StringValue(input.QueueUrl))]
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]*string{
+			"CreatedTimestamp": aws.String(strconv.FormatInt(created.Unix(), 10)),
+		},
+	}, nil
+}
+
+func (f *fakeSQS) DeleteQueue(input *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	name := queueName(aws.StringValue(input.QueueUrl))
+	delete(f.queues, name)
+	f.deleted = append(f.deleted, name)
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+type fakeCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	// stack name -> status
+	stacks map[string]string
+	// stack name -> when it was created, and when deleting it last failed
+	created        map[string]time.Time
+	deleteAttempts map[string]time.Time
+	deleted        []string
+}
+
+func (f *fakeCloudFormation) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	output := &cloudformation.ListStacksOutput{}
+	for name, status := range f.stacks {
+		for _, filter := range input.StackStatusFilter {
+			if aws.StringValue(filter) == status {
+				summary := &cloudformation.StackSummary{
+					StackId:      aws.String(stackID(name)),
+					StackName:    aws.String(name),
+					StackStatus:  aws.String(status),
+					CreationTime: aws.Time(f.created[name]),
+				}
+
+				if deleted, ok := f.deleteAttempts[name]; ok {
+					summary.DeletionTime = aws.Time(deleted)
+				}
+
+				output.StackSummaries = append(output.StackSummaries, summary)
+			}
+		}
+	}
+
+	return output, nil
+}
+
+func (f *fakeCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	for name := range f.stacks {
+		if stackID(name) == aws.StringValue(input.StackName) {
+			delete(f.stacks, name)
+			f.deleted = append(f.deleted, name)
+		}
+	}
+
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func stackID(name string) string {
+	return fmt.Sprintf("arn:aws:cloudformation:%s:123456789012:stack/%s/id", region, name)
+}
+
+type fakeStore struct {
+	store.Store
+	launches []*store.Launch
+	bastions []*com.Bastion
+	tracking map[string]string
+	actions  []*store.ReaperAction
+}
+
+func (s *fakeStore) ListBastions(request *store.ListBastionsRequest) (*store.ListBastionsResponse, error) {
+	bastions := make([]*com.Bastion, 0)
+	for _, bastion := range s.bastions {
+		for _, state := range request.State {
+			if bastion.CustomerID == request.CustomerID && bastion.State == state {
+				bastions = append(bastions, bastion)
+			}
+		}
+	}
+
+	return &store.ListBastionsResponse{Bastions: bastions}, nil
+}
+
+func (s *fakeStore) UpdateBastionState(bastionID, from, to string) (bool, error) {
+	for _, bastion := range s.bastions {
+		if bastion.ID == bastionID && bastion.State == from {
+			bastion.State = to
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *fakeStore) UpdateTrackingState(bastionID, state string) error {
+	s.tracking[bastionID] = state
+	return nil
+}
+
+func (s *fakeStore) ListLaunches(request *store.ListLaunchesRequest) (*store.ListLaunchesResponse, error) {
+	return &store.ListLaunchesResponse{Launches: s.launches}, nil
+}
+
+func (s *fakeStore) ListBastionRegions() ([]*store.BastionRegion, error) {
+	return []*store.BastionRegion{{CustomerID: customerID, UserID: 13, Region: region}}, nil
+}
+
+func (s *fakeStore) PutReaperAction(action *store.ReaperAction) error {
+	s.actions = append(s.actions, action)
+	return nil
+}
+
+type fakeBus struct {
+	bus.Bus
+	messages []*bus.Message
+}
+
+func (b *fakeBus) Publish(msg *bus.Message) error {
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+var reapTests = []struct {
+	topics          []string
+	queues          map[string]time.Duration
+	stacks          map[string]string
+	youngStacks     map[string]string
+	retriedStacks   map[string]string
+	launches        []*store.Launch
+	bastions        []*com.Bastion
+	deletedTopics   []string
+	deletedQueues   []string
+	deletedStacks   []string
+	deletedBastions []string
+}{
+	{
+		topics: []string{
+			"opsee-bastion-orphan",
+			"opsee-stack-" + customerID,
+			"customer-alarms",
+		},
+		queues: map[string]time.Duration{
+			"opsee-bastion-launch-sqsorphan": 3 * time.Hour,
+			"opsee-bastion-launch-sqsyoung":  time.Minute,
+		},
+		deletedTopics:   []string{"opsee-bastion-orphan", "opsee-stack-" + customerID},
+		deletedQueues:   []string{"opsee-bastion-launch-sqsorphan"},
+		deletedStacks:   []string{},
+		deletedBastions: []string{},
+	},
+	{
+		stacks: map[string]string{
+			"opsee-bastion-failed":      cloudformation.StackStatusRollbackComplete,
+			"opsee-bastion-nostack":     cloudformation.StackStatusDeleteFailed,
+			"opsee-bastion-active":      cloudformation.StackStatusCreateComplete,
+			"opsee-bastion-disabled":    cloudformation.StackStatusDeleteFailed,
+			"opsee-stack-" + customerID: cloudformation.StackStatusRollbackComplete,
+			"customer-stack":            cloudformation.StackStatusRollbackComplete,
+		},
+		bastions: []*com.Bastion{
+			{
+				ID:         "failed",
+				CustomerID: customerID,
+				Region:     region,
+				State:      com.BastionStateFailed,
+				StackID:    sql.NullString{String: stackID("opsee-bastion-failed"), Valid: true},
+			},
+			// failed before its stack id was saved
+			{ID: "nostack", CustomerID: customerID, Region: region, State: com.BastionStateFailed},
+			{ID: "active", CustomerID: customerID, Region: region, State: com.BastionStateActive},
+			// the legacy stack isn't named after the bastion
+			{
+				ID:         "legacy",
+				CustomerID: customerID,
+				Region:     region,
+				State:      com.BastionStateFailed,
+				StackID:    sql.NullString{String: stackID("opsee-stack-" + customerID), Valid: true},
+			},
+			{ID: "elsewhere", CustomerID: customerID, Region: "us-east-1", State: com.BastionStateFailed},
+			// its deletion failed, leaving it disabled
+			{ID: "disabled", CustomerID: customerID, Region: region, State: com.BastionStateDisabled},
+			{ID: "young", CustomerID: customerID, Region: region, State: com.BastionStateFailed},
+			{ID: "retried", CustomerID: customerID, Region: region, State: com.BastionStateDisabled},
+		},
+		youngStacks: map[string]string{
+			"opsee-bastion-young": cloudformation.StackStatusRollbackComplete,
+		},
+		retriedStacks: map[string]string{
+			"opsee-bastion-retried": cloudformation.StackStatusDeleteFailed,
+		},
+		deletedTopics:   []string{},
+		deletedQueues:   []string{},
+		deletedStacks:   []string{"opsee-bastion-disabled", "opsee-bastion-failed", "opsee-bastion-nostack", "opsee-stack-" + customerID},
+		deletedBastions: []string{"disabled", "failed", "legacy", "nostack"},
+	},
+	{
+		topics: []string{
+			"opsee-bastion-inflight",
+			"opsee-bastion-checkpointed",
+			"opsee-stack-" + customerID,
+		},
+		queues: map[string]time.Duration{
+			"opsee-bastion-launch-sqsinflight": 3 * time.Hour,
+		},
+		stacks: map[string]string{
+			"opsee-bastion-inflight": cloudformation.StackStatusRollbackComplete,
+		},
+		launches: []*store.Launch{
+			// a launch that hasn't checkpointed its topic and queue yet
+			{BastionID: "inflight"},
+			// a legacy launch with the per customer topic
+			{
+				BastionID: "checkpointed",
+				TopicARN:  fmt.Sprintf("arn:aws:sns:%s:123456789012:opsee-stack-%s", region, customerID),
+			},
+		},
+		deletedTopics:   []string{},
+		deletedQueues:   []string{},
+		deletedStacks:   []string{},
+		deletedBastions: []string{},
+	},
+}
+
+func TestReap(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range reapTests {
+		var (
+			db        = &fakeStore{launches: tt.launches, bastions: tt.bastions, tracking: make(map[string]string)}
+			b         = &fakeBus{}
+			snsClient = &fakeSNS{topics: tt.topics}
+			sqsClient = &fakeSQS{queues: make(map[string]time.Time)}
+			cfClient  = &fakeCloudFormation{
+				stacks:         make(map[string]string),
+				created:        make(map[string]time.Time),
+				deleteAttempts: make(map[string]time.Time),
+			}
+		)
+
+		for name, age := range tt.queues {
+			sqsClient.queues[name] = time.Now().Add(-age)
+		}
+
+		for name, status := range tt.stacks {
+			cfClient.stacks[name] = status
+			cfClient.created[name] = time.Now().Add(-3 * time.Hour)
+		}
+
+		// stacks are left alone for the grace period after they change
+		for name, status := range tt.youngStacks {
+			cfClient.stacks[name] = status
+			cfClient.created[name] = time.Now().Add(-time.Minute)
+		}
+
+		for name, status := range tt.retriedStacks {
+			cfClient.stacks[name] = status
+			cfClient.created[name] = time.Now().Add(-3 * time.Hour)
+			cfClient.deleteAttempts[name] = time.Now().Add(-time.Minute)
+		}
+
+		r := New(db, nil, b, nil)
+		r.clients = func(*store.BastionRegion) *clients {
+			return &clients{sns: snsClient, sqs: sqsClient, cloudformation: cfClient}
+		}
+
+		// topics are only deleted once they've been orphaned for the grace period,
+		// failed stacks that are old enough right away
+		r.reap(context.Background())
+		assert.Empty(snsClient.deleted)
+
+		sort.Strings(cfClient.deleted)
+		assert.Equal(tt.deletedStacks, append([]string{}, cfClient.deleted...))
+
+		deletedBastions := []string{}
+		for _, bastion := range db.bastions {
+			if bastion.State == com.BastionStateDeleted {
+				deletedBastions = append(deletedBastions, bastion.ID)
+				assert.Equal(com.BastionStateDeleted, db.tracking[bastion.ID])
+			}
+		}
+
+		sort.Strings(deletedBastions)
+		assert.Equal(tt.deletedBastions, deletedBastions)
+
+		for arn := range r.firstSeen {
+			r.firstSeen[arn] = time.Now().Add(-3 * time.Hour)
+		}
+
+		r.reap(context.Background())
+
+		sort.Strings(snsClient.deleted)
+		sort.Strings(tt.deletedTopics)
+		assert.Equal(tt.deletedTopics, append([]string{}, snsClient.deleted...))
+		assert.Equal(tt.deletedQueues, append([]string{}, sqsClient.deleted...))
+		assert.Empty(r.firstSeen)
+
+		// every deletion is audited and published
+		deletions := len(tt.deletedTopics) + len(tt.deletedQueues) + len(tt.deletedStacks)
+		assert.Len(db.actions, deletions)
+		assert.Len(b.messages, deletions)
+		for _, msg := range b.messages {
+			assert.Equal(commandReap, msg.Command)
+			assert.Equal(customerID, msg.CustomerID)
+			assert.Equal("complete", msg.State)
+		}
+	}
+}
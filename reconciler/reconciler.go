@@ -0,0 +1,410 @@
+package reconciler
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/leader"
+	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+)
+
+const (
+	reconcilerKeyPath    = "/opsee.co/config/keelhaul/reconcilerLock"
+	minReconcileDelay    = time.Duration(10) * time.Minute
+	commandReconcile     = "reconcile-bastion"
+	stackResourceDeleted = "DELETE_COMPLETE"
+)
+
+// the stack is being changed by a launch, or by the customer, so what we
+// find now doesn't mean much
+var stackBusyStatuses = map[string]bool{
+	cloudformation.StackStatusCreateInProgress:                        true,
+	cloudformation.StackStatusUpdateInProgress:                        true,
+	cloudformation.StackStatusUpdateCompleteCleanupInProgress:         true,
+	cloudformation.StackStatusUpdateRollbackInProgress:                true,
+	cloudformation.StackStatusUpdateRollbackCompleteCleanupInProgress: true,
+	cloudformation.StackStatusRollbackInProgress:                      true,
+}
+
+// stacks in these states don't have a working bastion in them
+var stackFailedStatuses = map[string]bool{
+	cloudformation.StackStatusCreateFailed:         true,
+	cloudformation.StackStatusRollbackComplete:     true,
+	cloudformation.StackStatusRollbackFailed:       true,
+	cloudformation.StackStatusUpdateRollbackFailed: true,
+	cloudformation.StackStatusDeleteInProgress:     true,
+	cloudformation.StackStatusDeleteFailed:         true,
+}
+
+// the sdk we vendor has no interfaces for these, these are the calls we make
+type autoscalingAPI interface {
+	DescribeAutoScalingGroups(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+}
+
+type ec2API interface {
+	DescribeSecurityGroups(*ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error)
+}
+
+type clients struct {
+	cloudformation cloudformationiface.CloudFormationAPI
+	autoscaling    autoscalingAPI
+	ec2            ec2API
+}
+
+type reconciler struct {
+	db                store.Store
+	etcd              etcd.KeysAPI
+	bus               bus.Bus
+	notifier          notifier.Notifier
+	spanx             service.SpanxClient
+	stop              context.CancelFunc
+	lastReconcileTime time.Time
+	clients           func(*com.Bastion) *clients
+}
+
+// New returns a reconciler that checks active bastions' stacks against what
+// the bastions table says about them, and updates the table when they differ
+func New(db store.Store, etcdKAPI etcd.KeysAPI, bus bus.Bus, notifier notifier.Notifier, spanx service.SpanxClient) *reconciler {
+	r := &reconciler{
+		db:       db,
+		etcd:     etcdKAPI,
+		bus:      bus,
+		notifier: notifier,
+		spanx:    spanx,
+	}
+
+	r.clients = r.bastionClients
+	return r
+}
+
+func (r *reconciler) Start() {
+	var ctx context.Context
+	ctx, r.stop = context.WithCancel(context.Background())
+
+	go leader.Run(ctx, r.etcd, reconcilerKeyPath, func(ctx context.Context, leading bool) {
+		if leading && r.isTimeToReconcile() {
+			r.reconcile(ctx)
+			r.lastReconcileTime = time.Now()
+		}
+	})
+}
+
+func (r *reconciler) Stop() {
+	r.stop()
+}
+
+func (r *reconciler) isTimeToReconcile() bool {
+	return time.Now().Sub(r.lastReconcileTime) >= minReconcileDelay
+}
+
+func (r *reconciler) bastionClients(bastion *com.Bastion) *clients {
+	user := &schema.User{
+		Id:         int32(bastion.UserID),
+		CustomerId: bastion.CustomerID,
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(user, r.spanx),
+		Region:      aws.String(bastion.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	return &clients{
+		cloudformation: cloudformation.New(sess),
+		autoscaling:    autoscaling.New(sess),
+		ec2:            ec2.New(sess),
+	}
+}
+
+func (r *reconciler) reconcile(ctx context.Context) {
+	log.Info("reconciler thought leader")
+
+	bastions, err := r.db.ListBastionsByState(com.BastionStateActive)
+	if err != nil {
+		log.WithError(err).Error("failed to list active bastions")
+		return
+	}
+
+	for _, bastion := range bastions {
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.reconcileBastion(bastion)
+	}
+}
+
+// observation is what a bastion's stack says about it
+type observation struct {
+	state      string
+	instanceID string
+	groupID    string
+	drift      []string
+}
+
+func (r *reconciler) reconcileBastion(bastion *com.Bastion) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": bastion.CustomerID,
+		"bastion_id":  bastion.ID,
+	})
+
+	obs, err := observe(r.clients(bastion), bastion)
+	if err != nil {
+		logger.WithError(err).Error("failed to observe bastion stack")
+		return
+	}
+
+	// there's nothing to go on while the stack is changing
+	if obs == nil {
+		return
+	}
+
+	previousState := bastion.State
+	changed := bastion.State != obs.state ||
+		bastion.InstanceID.String != obs.instanceID ||
+		bastion.GroupID.String != obs.groupID
+
+	if !changed {
+		return
+	}
+
+	bastion.State = obs.state
+	bastion.InstanceID = sql.NullString{String: obs.instanceID, Valid: obs.instanceID != ""}
+	bastion.GroupID = sql.NullString{String: obs.groupID, Valid: obs.groupID != ""}
+
+	// launches and deletes change bastions too, theirs are the newer changes
+	active, err := r.db.UpdateActiveBastion(bastion)
+	if err != nil {
+		logger.WithError(err).Error("failed to update reconciled bastion")
+		return
+	}
+
+	if !active {
+		logger.Info("bastion changed while being reconciled")
+		return
+	}
+
+	if obs.state == com.BastionStateDeleted {
+		err = r.db.UpdateTrackingState(bastion.ID, com.BastionStateDeleted)
+		if err != nil {
+			logger.WithError(err).Warn("failed updating bastion tracking state")
+		}
+	}
+
+	// ids filling in or an instance being replaced are business as usual
+	if len(obs.drift) == 0 {
+		logger.Info("updated bastion instance and group ids")
+		return
+	}
+
+	drift := strings.Join(obs.drift, ", ")
+	logger.WithField("drift", drift).Warn("bastion stack has drifted")
+
+	err = r.bus.Publish(&bus.Message{
+		Command: commandReconcile,
+		State:   "complete",
+		Message: fmt.Sprintf("bastion stack has drifted: %s", drift),
+		Attributes: map[string]interface{}{
+			"previous_state": previousState,
+			"current_state":  obs.state,
+			"instance_id":    obs.instanceID,
+			"group_id":       obs.groupID,
+			"drift":          obs.drift,
+		},
+		CustomerID: bastion.CustomerID,
+		BastionID:  bastion.ID,
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to publish bastion drift")
+	}
+
+	err = r.notifier.NotifySlackBastionDrift(bastion.CustomerID, map[string]interface{}{
+		"bastion_id":     bastion.ID,
+		"customer_id":    bastion.CustomerID,
+		"previous_state": previousState,
+		"current_state":  obs.state,
+		"drift":          drift,
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to notify bastion drift")
+	}
+}
+
+// observe looks at the bastion's stack and the resources in it. cloudformation's
+// own drift detection would catch changed security group rules as well, but
+// it isn't in the sdk we vendor, so we only notice resources that are gone.
+// observe returns nil when the stack is in the middle of changing.
+func observe(c *clients, bastion *com.Bastion) (*observation, error) {
+	obs := &observation{
+		state:      bastion.State,
+		instanceID: bastion.InstanceID.String,
+		groupID:    bastion.GroupID.String,
+	}
+
+	// the stack id outlives the stack itself, so prefer it to the name
+	stackName := bastion.StackName()
+	if bastion.StackID.Valid {
+		stackName = bastion.StackID.String
+	}
+
+	stacks, err := c.cloudformation.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		if stackMissing(err) {
+			obs.state = com.BastionStateDeleted
+			obs.drift = append(obs.drift, "cloudformation stack was deleted")
+			return obs, nil
+		}
+
+		return nil, err
+	}
+
+	if len(stacks.Stacks) == 0 {
+		return nil, fmt.Errorf("stack %s not found", stackName)
+	}
+
+	status := aws.StringValue(stacks.Stacks[0].StackStatus)
+	switch {
+	case status == cloudformation.StackStatusDeleteComplete:
+		obs.state = com.BastionStateDeleted
+		obs.drift = append(obs.drift, "cloudformation stack was deleted")
+		return obs, nil
+
+	case stackFailedStatuses[status]:
+		obs.state = com.BastionStateFailed
+		obs.drift = append(obs.drift, fmt.Sprintf("cloudformation stack is %s", status))
+		return obs, nil
+
+	case stackBusyStatuses[status]:
+		return nil, nil
+	}
+
+	resources, err := c.cloudformation.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groupName, groupID string
+	for _, resource := range resources.StackResources {
+		if aws.StringValue(resource.ResourceStatus) == stackResourceDeleted {
+			continue
+		}
+
+		switch aws.StringValue(resource.ResourceType) {
+		case "AWS::AutoScaling::AutoScalingGroup":
+			groupName = aws.StringValue(resource.PhysicalResourceId)
+		case "AWS::EC2::SecurityGroup":
+			groupID = aws.StringValue(resource.PhysicalResourceId)
+		}
+	}
+
+	err = observeAutoScalingGroup(c, groupName, obs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = observeSecurityGroup(c, groupID, obs)
+	if err != nil {
+		return nil, err
+	}
+
+	return obs, nil
+}
+
+// stackMissing is whether describing the stack failed because it doesn't
+// exist, cloudformation reports other bad requests as validation errors too
+func stackMissing(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ValidationError" && strings.HasSuffix(awsErr.Message(), "does not exist")
+}
+
+func observeAutoScalingGroup(c *clients, name string, obs *observation) error {
+	if name == "" {
+		obs.state = com.BastionStateFailed
+		obs.drift = append(obs.drift, "autoscaling group is missing from the stack")
+		return nil
+	}
+
+	groups, err := c.autoscaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(name)},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(groups.AutoScalingGroups) == 0 || groups.AutoScalingGroups[0].Status != nil {
+		// a status is only set on groups being deleted
+		obs.state = com.BastionStateFailed
+		obs.instanceID = ""
+		obs.drift = append(obs.drift, fmt.Sprintf("autoscaling group %s was deleted", name))
+		return nil
+	}
+
+	// the group replaces the instance now and then, which is fine
+	for _, instance := range groups.AutoScalingGroups[0].Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			obs.instanceID = aws.StringValue(instance.InstanceId)
+			return nil
+		}
+	}
+
+	obs.instanceID = ""
+	return nil
+}
+
+func observeSecurityGroup(c *clients, id string, obs *observation) error {
+	if id == "" {
+		obs.state = com.BastionStateFailed
+		obs.drift = append(obs.drift, "security group is missing from the stack")
+		return nil
+	}
+
+	// an id that isn't the one we launched with is the stack being changed
+	// outside of keelhaul
+	if obs.groupID != "" && obs.groupID != id {
+		obs.drift = append(obs.drift, fmt.Sprintf("security group changed from %s to %s", obs.groupID, id))
+	}
+
+	obs.groupID = id
+	_, err := c.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(id)},
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "InvalidGroup.NotFound" {
+			obs.state = com.BastionStateFailed
+			obs.drift = append(obs.drift, fmt.Sprintf("security group %s was deleted", id))
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
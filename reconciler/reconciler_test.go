@@ -0,0 +1,304 @@
+package reconciler
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	customerID  = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+	bastionID   = "5d8a1a0e-0d8a-11e6-b9d1-3b8f0a2b6b1c"
+	groupName   = "opsee-bastion-asg"
+	instanceID  = "i-abcdef"
+	groupID     = "sg-abcdef"
+	stackStatus = cloudformation.StackStatusCreateComplete
+)
+
+type fakeCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	status  string
+	missing bool
+	err     error
+}
+
+func (f *fakeCloudFormation) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	if f.missing {
+		return nil, awserr.New("ValidationError", "Stack with id opsee-bastion-"+bastionID+" does not exist", nil)
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &cloudformation.DescribeStacksOutput{
+		Stacks: []*cloudformation.Stack{
+			{StackName: input.StackName, StackStatus: aws.String(f.status)},
+		},
+	}, nil
+}
+
+func (f *fakeCloudFormation) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
+	return &cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{
+				ResourceType:       aws.String("AWS::AutoScaling::AutoScalingGroup"),
+				ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+				PhysicalResourceId: aws.String(groupName),
+			},
+			{
+				ResourceType:       aws.String("AWS::EC2::SecurityGroup"),
+				ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+				PhysicalResourceId: aws.String(groupID),
+			},
+		},
+	}, nil
+}
+
+type fakeAutoScaling struct {
+	deleted   bool
+	instances []string
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	if f.deleted {
+		return output, nil
+	}
+
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String(groupName)}
+	for _, id := range f.instances {
+		group.Instances = append(group.Instances, &autoscaling.Instance{
+			InstanceId:     aws.String(id),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+	return output, nil
+}
+
+type fakeEC2 struct {
+	deleted bool
+}
+
+func (f *fakeEC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if f.deleted {
+		return nil, awserr.New("InvalidGroup.NotFound", "The security group does not exist", nil)
+	}
+
+	return &ec2.DescribeSecurityGroupsOutput{
+		SecurityGroups: []*ec2.SecurityGroup{{GroupId: input.GroupIds[0]}},
+	}, nil
+}
+
+type fakeStore struct {
+	store.Store
+	inactive bool
+	updated  []*com.Bastion
+	tracking []string
+}
+
+func (s *fakeStore) UpdateActiveBastion(bastion *com.Bastion) (bool, error) {
+	if s.inactive {
+		return false, nil
+	}
+
+	s.updated = append(s.updated, bastion)
+	return true, nil
+}
+
+func (s *fakeStore) UpdateTrackingState(id, state string) error {
+	s.tracking = append(s.tracking, state)
+	return nil
+}
+
+type fakeBus struct {
+	bus.Bus
+	messages []*bus.Message
+}
+
+func (b *fakeBus) Publish(msg *bus.Message) error {
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+type fakeNotifier struct {
+	notifier.Notifier
+	drifts []map[string]interface{}
+}
+
+func (n *fakeNotifier) NotifySlackBastionDrift(customerID string, vars map[string]interface{}) error {
+	n.drifts = append(n.drifts, vars)
+	return nil
+}
+
+var reconcileTests = []struct {
+	stackStatus   string
+	stackMissing  bool
+	stackErr      error
+	inactive      bool
+	groupDeleted  bool
+	sgDeleted     bool
+	instances     []string
+	instanceID    string
+	updated       bool
+	state         string
+	newInstanceID string
+	drifted       bool
+}{
+	// nothing has changed
+	{
+		stackStatus:   stackStatus,
+		instances:     []string{instanceID},
+		instanceID:    instanceID,
+		state:         com.BastionStateActive,
+		newInstanceID: instanceID,
+	},
+	// the instance id fills in once the group has an instance in service,
+	// launches store a placeholder until then
+	{
+		stackStatus:   stackStatus,
+		instances:     []string{instanceID},
+		instanceID:    "not used",
+		updated:       true,
+		state:         com.BastionStateActive,
+		newInstanceID: instanceID,
+	},
+	// the stack is being updated, so it's left alone
+	{
+		stackStatus:   cloudformation.StackStatusUpdateInProgress,
+		instanceID:    instanceID,
+		state:         com.BastionStateActive,
+		newInstanceID: instanceID,
+	},
+	// the stack was deleted outside of keelhaul
+	{
+		stackMissing:  true,
+		instanceID:    instanceID,
+		updated:       true,
+		state:         com.BastionStateDeleted,
+		newInstanceID: instanceID,
+		drifted:       true,
+	},
+	// other validation errors don't mean the stack is gone
+	{
+		stackErr:      awserr.New("ValidationError", "1 validation error detected", nil),
+		instanceID:    instanceID,
+		state:         com.BastionStateActive,
+		newInstanceID: instanceID,
+	},
+	// a delete got to the bastion first, so the drift is old news
+	{
+		stackMissing:  true,
+		inactive:      true,
+		instanceID:    instanceID,
+		state:         com.BastionStateDeleted,
+		newInstanceID: instanceID,
+	},
+	{
+		stackStatus:   cloudformation.StackStatusRollbackComplete,
+		instanceID:    instanceID,
+		updated:       true,
+		state:         com.BastionStateFailed,
+		newInstanceID: instanceID,
+		drifted:       true,
+	},
+	// the autoscaling group was deleted
+	{
+		stackStatus:  stackStatus,
+		groupDeleted: true,
+		instanceID:   instanceID,
+		updated:      true,
+		state:        com.BastionStateFailed,
+		drifted:      true,
+	},
+	// the security group was deleted
+	{
+		stackStatus:   stackStatus,
+		sgDeleted:     true,
+		instances:     []string{instanceID},
+		instanceID:    instanceID,
+		updated:       true,
+		state:         com.BastionStateFailed,
+		newInstanceID: instanceID,
+		drifted:       true,
+	},
+}
+
+func TestReconcile(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range reconcileTests {
+		var (
+			db = &fakeStore{inactive: tt.inactive}
+			b  = &fakeBus{}
+			n  = &fakeNotifier{}
+			c  = &clients{
+				cloudformation: &fakeCloudFormation{status: tt.stackStatus, missing: tt.stackMissing, err: tt.stackErr},
+				autoscaling:    &fakeAutoScaling{deleted: tt.groupDeleted, instances: tt.instances},
+				ec2:            &fakeEC2{deleted: tt.sgDeleted},
+			}
+			bastion = &com.Bastion{
+				ID:         bastionID,
+				CustomerID: customerID,
+				State:      com.BastionStateActive,
+				InstanceID: sql.NullString{String: tt.instanceID, Valid: tt.instanceID != ""},
+				GroupID:    sql.NullString{String: groupID, Valid: true},
+			}
+		)
+
+		r := New(db, nil, b, n, nil)
+		r.clients = func(*com.Bastion) *clients {
+			return c
+		}
+
+		r.reconcileBastion(bastion)
+
+		assert.Equal(tt.state, bastion.State)
+		assert.Equal(tt.newInstanceID, bastion.InstanceID.String)
+
+		if !tt.updated {
+			assert.Empty(db.updated)
+			assert.Empty(db.tracking)
+			assert.Empty(b.messages)
+			assert.Empty(n.drifts)
+			continue
+		}
+
+		assert.Len(db.updated, 1)
+		if tt.state == com.BastionStateDeleted {
+			assert.Equal([]string{com.BastionStateDeleted}, db.tracking)
+		} else {
+			assert.Empty(db.tracking)
+		}
+
+		if !tt.drifted {
+			assert.Empty(b.messages)
+			assert.Empty(n.drifts)
+			continue
+		}
+
+		if assert.Len(b.messages, 1) {
+			assert.Equal(commandReconcile, b.messages[0].Command)
+			assert.Equal(bastionID, b.messages[0].BastionID)
+			assert.Equal(com.BastionStateActive, b.messages[0].Attributes["previous_state"])
+			assert.Equal(tt.state, b.messages[0].Attributes["current_state"])
+		}
+
+		if assert.Len(n.drifts, 1) {
+			assert.Equal(tt.state, n.drifts[0]["current_state"])
+		}
+	}
+}
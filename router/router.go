@@ -74,14 +74,44 @@ func (c *router) getServices(bastion *com.Bastion) (map[string]interface{}, erro
 		return nil, remapErrors(err)
 	}
 
-	services := make(map[string]interface{})
+	return NodeServices(response.Node)
+}
 
-	err = json.Unmarshal([]byte(response.Node.Value), &services)
-	if err != nil {
-		return nil, err
+// NodeServices reads the services a bastion registered. HA bastions run
+// several instances that each register under the bastion's key, by instance,
+// and the services of one whose checker is up are the bastion's.
+func NodeServices(node *etcd.Node) (map[string]interface{}, error) {
+	if !node.Dir {
+		services := make(map[string]interface{})
+		err := json.Unmarshal([]byte(node.Value), &services)
+		if err != nil {
+			return nil, err
+		}
+
+		return services, nil
 	}
 
-	return services, nil
+	var (
+		services map[string]interface{}
+		err      = ErrNotFound
+	)
+
+	for _, instanceNode := range node.Nodes {
+		instanceServices, instanceErr := NodeServices(instanceNode)
+		if instanceErr != nil {
+			continue
+		}
+
+		if _, ok := instanceServices["checker"]; ok {
+			return instanceServices, nil
+		}
+
+		if services == nil {
+			services, err = instanceServices, nil
+		}
+	}
+
+	return services, err
 }
 
 type systemClock struct{}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"testing"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/stretchr/testify/assert"
+)
+
+var nodeServicesTests = []struct {
+	node     *etcd.Node
+	services map[string]interface{}
+	err      error
+}{
+	{&etcd.Node{Value: `{"checker": "10.0.0.1:4000"}`}, map[string]interface{}{"checker": "10.0.0.1:4000"}, nil},
+	// an ha bastion's instances register separately, the one with a checker wins
+	{
+		&etcd.Node{Dir: true, Nodes: etcd.Nodes{
+			{Key: "i-aaaaaa", Value: `{"monitor": "10.0.0.1:4001"}`},
+			{Key: "i-bbbbbb", Value: `{"checker": "10.0.1.1:4000"}`},
+		}},
+		map[string]interface{}{"checker": "10.0.1.1:4000"},
+		nil,
+	},
+	{
+		&etcd.Node{Dir: true, Nodes: etcd.Nodes{
+			{Key: "i-aaaaaa", Value: `not json`},
+			{Key: "i-bbbbbb", Value: `{"monitor": "10.0.1.1:4001"}`},
+		}},
+		map[string]interface{}{"monitor": "10.0.1.1:4001"},
+		nil,
+	},
+	{&etcd.Node{Dir: true}, nil, ErrNotFound},
+}
+
+func TestNodeServices(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, tt := range nodeServicesTests {
+		services, err := NodeServices(tt.node)
+		assert.Equal(tt.err, err, "test %d", i)
+		assert.Equal(tt.services, services, "test %d", i)
+	}
+
+	_, err := NodeServices(&etcd.Node{Value: "not json"})
+	assert.Error(err)
+}
@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -150,6 +151,86 @@ func ScanRegion(region string, session *session.Session) (*schema.Region, error)
 	}, nil
 }
 
+// SubnetRouting determines the routing state of a single subnet, it only
+// looks at the resources in the subnet's vpc.
+func SubnetRouting(session *session.Session, subnetID string) (*ec2.Subnet, string, error) {
+	ec2Client := ec2.New(session)
+
+	subnetOutput, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(subnetID)},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(subnetOutput.Subnets) == 0 {
+		return nil, "", fmt.Errorf("subnet %s not found", subnetID)
+	}
+
+	var (
+		subnet      = subnetOutput.Subnets[0]
+		vpcFilter   = []*ec2.Filter{{Name: aws.String("vpc-id"), Values: []*string{subnet.VpcId}}}
+		nextToken   *string
+		instanceIPs = make([]string, 0)
+		gateways    = make([]*ec2.InternetGateway, 0)
+	)
+
+	for {
+		instancesOutput, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
+			Filters:    vpcFilter,
+			MaxResults: aws.Int64(100),
+			NextToken:  nextToken,
+		})
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		nextToken = instancesOutput.NextToken
+		for _, res := range instancesOutput.Reservations {
+			for _, instance := range res.Instances {
+				if aws.StringValue(instance.State.Name) != ec2.InstanceStateNameTerminated {
+					instanceIPs = append(instanceIPs, aws.StringValue(instance.PrivateIpAddress))
+				}
+			}
+		}
+
+		if nextToken == nil {
+			break
+		}
+	}
+
+	internetGatewaysOutput, err := ec2Client.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.vpc-id"), Values: []*string{subnet.VpcId}}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, igw := range internetGatewaysOutput.InternetGateways {
+		for _, igwatt := range igw.Attachments {
+			st := aws.StringValue(igwatt.State)
+			if st == attachmentStatusAvailable || st == ec2.AttachmentStatusAttached {
+				gateways = append(gateways, igw)
+			}
+		}
+	}
+
+	routeTablesOutput, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: vpcFilter,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	routing, err := determineRouting(subnet, routeTablesOutput.RouteTables, instanceIPs, gateways)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return subnet, routing, nil
+}
+
 func determineRouting(subnet *ec2.Subnet, routeTables []*ec2.RouteTable, instanceIPs []string, gateways []*ec2.InternetGateway) (string, error) {
 	var (
 		associatedTable *ec2.RouteTable
@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"errors"
+
+	"github.com/opsee/basic/schema"
+)
+
+var ErrNoHASubnets = errors.New("not enough subnets in different availability zones can reach the internet and every instance in the vpc.")
+
+// PickSubnets picks the best n subnets of the vpc for an HA bastion, each in
+// a different availability zone. The stack sets one public ip policy, so
+// public subnets only pair with public ones. The region's subnets are already
+// in order of preference, so the best subnets of each kind are picked and the
+// kind whose picks rank best wins, a pair of public subnets is picked when the
+// best subnet has nothing to pair with. When subnetIDs are given the subnets
+// are picked from them.
+func PickSubnets(region *schema.Region, vpcID string, subnetIDs []string, n int) ([]*schema.Subnet, error) {
+	allowed := make(map[string]bool)
+	for _, id := range subnetIDs {
+		allowed[id] = true
+	}
+
+	var (
+		public  = &subnetPicks{zones: make(map[string]bool)}
+		private = &subnetPicks{zones: make(map[string]bool)}
+	)
+
+	for rank, subnet := range region.Subnets {
+		if subnet.VpcId != vpcID || (len(allowed) > 0 && !allowed[subnet.SubnetId]) {
+			continue
+		}
+
+		// bastions in these can't do their job
+		if subnet.Routing == schema.RoutingStatePrivate || subnet.Routing == schema.RoutingStateOccluded {
+			continue
+		}
+
+		picks := private
+		if subnet.Routing == schema.RoutingStatePublic {
+			picks = public
+		}
+
+		if len(picks.subnets) < n && !picks.zones[subnet.AvailabilityZone] {
+			picks.add(subnet, rank)
+		}
+	}
+
+	var best *subnetPicks
+	for _, picks := range []*subnetPicks{private, public} {
+		if len(picks.subnets) == n && (best == nil || picks.outranks(best)) {
+			best = picks
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoHASubnets
+	}
+
+	return best.subnets, nil
+}
+
+// subnetPicks are subnets that can share a stack, in order of preference
+type subnetPicks struct {
+	subnets []*schema.Subnet
+	ranks   []int
+	zones   map[string]bool
+}
+
+func (p *subnetPicks) add(subnet *schema.Subnet, rank int) {
+	p.subnets = append(p.subnets, subnet)
+	p.ranks = append(p.ranks, rank)
+	p.zones[subnet.AvailabilityZone] = true
+}
+
+// outranks is whether p's best subnet is better than other's, then its next
+// best and so on
+func (p *subnetPicks) outranks(other *subnetPicks) bool {
+	for i := range p.ranks {
+		if p.ranks[i] != other.ranks[i] {
+			return p.ranks[i] < other.ranks[i]
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRegion() *schema.Region {
+	subnets := []*schema.Subnet{
+		{SubnetId: "subnet-a1", VpcId: "vpc-1", AvailabilityZone: "us-west-2a", Routing: schema.RoutingStateNAT, InstanceCount: 10},
+		{SubnetId: "subnet-a2", VpcId: "vpc-1", AvailabilityZone: "us-west-2a", Routing: schema.RoutingStateNAT, InstanceCount: 5},
+		{SubnetId: "subnet-b1", VpcId: "vpc-1", AvailabilityZone: "us-west-2b", Routing: schema.RoutingStatePublic, InstanceCount: 20},
+		{SubnetId: "subnet-b2", VpcId: "vpc-1", AvailabilityZone: "us-west-2b", Routing: schema.RoutingStateGateway, InstanceCount: 1},
+		{SubnetId: "subnet-c1", VpcId: "vpc-1", AvailabilityZone: "us-west-2c", Routing: schema.RoutingStatePublic, InstanceCount: 3},
+		{SubnetId: "subnet-c2", VpcId: "vpc-1", AvailabilityZone: "us-west-2c", Routing: schema.RoutingStateOccluded, InstanceCount: 30},
+		{SubnetId: "subnet-c3", VpcId: "vpc-1", AvailabilityZone: "us-west-2c", Routing: schema.RoutingStatePrivate, InstanceCount: 30},
+		{SubnetId: "subnet-d1", VpcId: "vpc-2", AvailabilityZone: "us-west-2d", Routing: schema.RoutingStateNAT, InstanceCount: 50},
+	}
+
+	sort.Sort(schema.SubnetsByPreference(subnets))
+	return &schema.Region{Region: "us-west-2", Subnets: subnets}
+}
+
+var pickSubnetsTests = []struct {
+	vpcID     string
+	subnetIDs []string
+	picked    []string
+	err       error
+}{
+	// the best subnet, then the best in another zone that isn't public
+	{"vpc-1", nil, []string{"subnet-a1", "subnet-b2"}, nil},
+	{"vpc-1", []string{"subnet-a2", "subnet-b2"}, []string{"subnet-a2", "subnet-b2"}, nil},
+	{"vpc-1", []string{"subnet-c1", "subnet-b1"}, []string{"subnet-b1", "subnet-c1"}, nil},
+	// the best subnet has no private subnet to pair with, public ones do
+	{"vpc-1", []string{"subnet-a1", "subnet-b1", "subnet-c1"}, []string{"subnet-b1", "subnet-c1"}, nil},
+	{"vpc-1", []string{"subnet-a1", "subnet-a2", "subnet-b1", "subnet-c1"}, []string{"subnet-b1", "subnet-c1"}, nil},
+	// public subnets don't pair with private ones
+	{"vpc-1", []string{"subnet-a1", "subnet-c1"}, nil, ErrNoHASubnets},
+	// nor do two in the same zone
+	{"vpc-1", []string{"subnet-a1", "subnet-a2"}, nil, ErrNoHASubnets},
+	// nor ones that can't connect
+	{"vpc-1", []string{"subnet-a1", "subnet-c2", "subnet-c3"}, nil, ErrNoHASubnets},
+	{"vpc-2", nil, nil, ErrNoHASubnets},
+	{"vpc-1", []string{"subnet-a1", "subnet-d1"}, nil, ErrNoHASubnets},
+}
+
+func TestPickSubnets(t *testing.T) {
+	assert := assert.New(t)
+
+	for i, tt := range pickSubnetsTests {
+		subnets, err := PickSubnets(testRegion(), tt.vpcID, tt.subnetIDs, 2)
+		assert.Equal(tt.err, err, "test %d", i)
+
+		var picked []string
+		for _, s := range subnets {
+			picked = append(picked, s.SubnetId)
+		}
+
+		assert.Equal(tt.picked, picked, "test %d", i)
+	}
+}
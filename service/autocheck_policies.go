@@ -0,0 +1,87 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/autocheck"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+type GetAutocheckPolicyResponse struct {
+	Policy *autocheck.Policy `json:"policy"`
+}
+
+type PutAutocheckPolicyRequest struct {
+	User   *schema.User      `json:"user"`
+	Policy *autocheck.Policy `json:"policy"`
+}
+
+type PutAutocheckPolicyResponse struct {
+	Policy *autocheck.Policy `json:"policy"`
+}
+
+// GetAutocheckPolicy returns the customer's policy, customers that haven't
+// set one get an empty policy, which has the defaults
+func (s *service) GetAutocheckPolicy(user *schema.User) (*GetAutocheckPolicyResponse, error) {
+	policy := &autocheck.Policy{}
+
+	record, err := s.db.GetAutocheckPolicy(user.CustomerId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.WithError(err).WithField("customer_id", user.CustomerId).Error("error querying database")
+			return nil, err
+		}
+
+		return &GetAutocheckPolicyResponse{Policy: policy}, nil
+	}
+
+	err = json.Unmarshal(record.Policy, policy)
+	if err != nil {
+		log.WithError(err).WithField("customer_id", user.CustomerId).Error("error decoding autocheck policy")
+		return nil, err
+	}
+
+	return &GetAutocheckPolicyResponse{Policy: policy}, nil
+}
+
+// PutAutocheckPolicy replaces the customer's policy. checks that were already
+// generated get the new thresholds on their bastion's next launch.
+func (s *service) PutAutocheckPolicy(ctx context.Context, req *PutAutocheckPolicyRequest) (*PutAutocheckPolicyResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Policy == nil {
+		req.Policy = &autocheck.Policy{}
+	}
+
+	err = req.Policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	policyJSON, err := json.Marshal(req.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.PutAutocheckPolicy(&store.AutocheckPolicy{
+		CustomerID: req.User.CustomerId,
+		Policy:     policyJSON,
+	})
+	if err != nil {
+		log.WithError(err).WithField("customer_id", req.User.CustomerId).Error("error saving autocheck policy")
+		return nil, err
+	}
+
+	return &PutAutocheckPolicyResponse{Policy: req.Policy}, nil
+}
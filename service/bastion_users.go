@@ -0,0 +1,231 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"golang.org/x/net/context"
+)
+
+const maxBastionUserKeys = 10
+
+var (
+	usernameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+	// users the bastion image already has
+	reservedUsernames = map[string]bool{
+		"root":  true,
+		"core":  true,
+		"opsee": true,
+	}
+
+	sshKeyTypes = map[string]bool{
+		"ssh-rsa":             true,
+		"ssh-ed25519":         true,
+		"ecdsa-sha2-nistp256": true,
+		"ecdsa-sha2-nistp384": true,
+		"ecdsa-sha2-nistp521": true,
+	}
+)
+
+type BastionUser struct {
+	Username string   `json:"username"`
+	Keys     []string `json:"keys"`
+}
+
+type ListBastionUsersResponse struct {
+	Users []*BastionUser `json:"users"`
+}
+
+type PutBastionUserRequest struct {
+	User     *schema.User `json:"user"`
+	Username string       `json:"username"`
+	Keys     []string     `json:"keys"`
+}
+
+type PutBastionUserResponse struct {
+	User *BastionUser `json:"user"`
+}
+
+type DeleteBastionUserRequest struct {
+	User     *schema.User `json:"user"`
+	Username string       `json:"username"`
+}
+
+type RotateBastionKeysRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+}
+
+type RotateBastionKeysResponse struct {
+	Bastion *com.Bastion `json:"bastion"`
+}
+
+func (s *service) ListBastionUsers(user *schema.User) (*ListBastionUsersResponse, error) {
+	entries, err := s.db.ListBastionUsers(user.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*BastionUser, 0)
+	for _, e := range entries {
+		if len(users) == 0 || users[len(users)-1].Username != e.Username {
+			users = append(users, &BastionUser{Username: e.Username})
+		}
+
+		u := users[len(users)-1]
+		u.Keys = append(u.Keys, e.Key)
+	}
+
+	return &ListBastionUsersResponse{Users: users}, nil
+}
+
+// PutBastionUser replaces the user's keys. bastions only pick them up when
+// they're launched or their keys are rotated.
+func (s *service) PutBastionUser(ctx context.Context, req *PutBastionUserRequest) (*PutBastionUserResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateUsername(req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Keys) == 0 || len(req.Keys) > maxBastionUserKeys {
+		return nil, errBadKeyCount
+	}
+
+	keys := make([]string, len(req.Keys))
+	for i, k := range req.Keys {
+		keys[i], err = normalizeAuthorizedKey(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.PutBastionUser(req.User.CustomerId, req.Username, keys)
+	if err != nil {
+		log.WithError(err).WithField("customer_id", req.User.CustomerId).Error("error saving bastion user")
+		return nil, err
+	}
+
+	return &PutBastionUserResponse{User: &BastionUser{Username: req.Username, Keys: keys}}, nil
+}
+
+func (s *service) DeleteBastionUser(ctx context.Context, req *DeleteBastionUserRequest) error {
+	if req.User == nil {
+		return errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = validateUsername(req.Username)
+	if err != nil {
+		return err
+	}
+
+	return s.db.DeleteBastionUser(req.User.CustomerId, req.Username)
+}
+
+func (s *service) RotateBastionKeys(ctx context.Context, req *RotateBastionKeysRequest) (*RotateBastionKeysResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.BastionId == "" {
+		return nil, errMissingBastion
+	}
+
+	response, err := s.db.GetBastion(&store.GetBastionRequest{
+		ID:         req.BastionId,
+		AnyState:   true,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errBastionNotFound
+		}
+
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	bastion := response.Bastion
+	if bastion.State != com.BastionStateActive {
+		return nil, errBastionNotUpgradable
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(req.User, s.spanx),
+		Region:      aws.String(bastion.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	_, err = s.launcher.RotateBastionKeys(sess, req.User, bastion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RotateBastionKeysResponse{Bastion: bastion}, nil
+}
+
+func validateUsername(username string) error {
+	if !usernameRegexp.MatchString(username) || reservedUsernames[username] {
+		return errInvalidUsername
+	}
+
+	return nil
+}
+
+// normalizeAuthorizedKey checks that the key is a single authorized_keys entry
+// without options, whose blob matches its type, and trims the whitespace
+// around it. keys end up quoted in the cloud-config, so quotes aren't allowed.
+func normalizeAuthorizedKey(key string) (string, error) {
+	if strings.ContainsAny(key, "\"\\\r\n") {
+		return "", errInvalidKey
+	}
+
+	fields := strings.Fields(key)
+	if len(fields) < 2 || !sshKeyTypes[fields[0]] {
+		return "", errInvalidKey
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(blob) < 4 {
+		return "", errInvalidKey
+	}
+
+	// the blob starts with the length prefixed key type
+	n := binary.BigEndian.Uint32(blob[:4])
+	if uint32(len(blob)-4) < n || !bytes.Equal(blob[4:4+n], []byte(fields[0])) {
+		return "", errInvalidKey
+	}
+
+	return strings.Join(fields, " "), nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyBlob = "AAAAC3NzaC1lZDI1NTE5AAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4f"
+
+var authorizedKeyTests = []struct {
+	key        string
+	normalized string
+	err        error
+}{
+	{"ssh-ed25519 " + testKeyBlob, "ssh-ed25519 " + testKeyBlob, nil},
+	{"ssh-ed25519 " + testKeyBlob + " me@example.com", "ssh-ed25519 " + testKeyBlob + " me@example.com", nil},
+	{"  ssh-ed25519\t" + testKeyBlob + "   me@example.com ", "ssh-ed25519 " + testKeyBlob + " me@example.com", nil},
+	// quotes and newlines would break out of the cloud-config
+	{"ssh-ed25519 " + testKeyBlob + " \"me\"", "", errInvalidKey},
+	{"ssh-ed25519 " + testKeyBlob + " me\\", "", errInvalidKey},
+	{"ssh-ed25519 " + testKeyBlob + "\nssh-ed25519 " + testKeyBlob, "", errInvalidKey},
+	{"ssh-ed25519 " + testKeyBlob + "\r", "", errInvalidKey},
+	// options aren't allowed
+	{"no-pty ssh-ed25519 " + testKeyBlob, "", errInvalidKey},
+	{"command=/bin/sh ssh-ed25519 " + testKeyBlob, "", errInvalidKey},
+	// the blob has to be the type it says it is
+	{"ssh-rsa " + testKeyBlob, "", errInvalidKey},
+	{"ssh-ed25519 AAAAB3NzaC1yc2EAAAADAQABAAABAQ==", "", errInvalidKey},
+	{"ssh-ed25519 AAAAC3NzaC1lZDI1", "", errInvalidKey},
+	{"ssh-ed25519 AAAA", "", errInvalidKey},
+	{"ssh-ed25519 not-base64", "", errInvalidKey},
+	{"ssh-dss " + testKeyBlob, "", errInvalidKey},
+	{"ssh-ed25519", "", errInvalidKey},
+	{"", "", errInvalidKey},
+}
+
+func TestNormalizeAuthorizedKey(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range authorizedKeyTests {
+		normalized, err := normalizeAuthorizedKey(tt.key)
+		assert.Equal(tt.err, err, tt.key)
+		assert.Equal(tt.normalized, normalized, tt.key)
+	}
+}
+
+var usernameTests = []struct {
+	username string
+	err      error
+}{
+	{"deploy", nil},
+	{"_svc", nil},
+	{"ops-2_a", nil},
+	{"abcdefghijklmnopqrstuvwxyz012345", nil},
+	{"abcdefghijklmnopqrstuvwxyz0123456", errInvalidUsername},
+	{"", errInvalidUsername},
+	{"Deploy", errInvalidUsername},
+	{"2ops", errInvalidUsername},
+	{"-ops", errInvalidUsername},
+	{"ops user", errInvalidUsername},
+	{"ops\n", errInvalidUsername},
+	// users the bastion image already has
+	{"root", errInvalidUsername},
+	{"core", errInvalidUsername},
+	{"opsee", errInvalidUsername},
+}
+
+func TestValidateUsername(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range usernameTests {
+		assert.Equal(tt.err, validateUsername(tt.username), tt.username)
+	}
+}
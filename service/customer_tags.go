@@ -0,0 +1,61 @@
+package service
+
+import (
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/launcher"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+type ListCustomerTagsResponse struct {
+	Tags []*store.CustomerTag `json:"tags"`
+}
+
+type PutCustomerTagsRequest struct {
+	User *schema.User         `json:"user"`
+	Tags []*store.CustomerTag `json:"tags"`
+}
+
+type PutCustomerTagsResponse struct {
+	Tags []*store.CustomerTag `json:"tags"`
+}
+
+func (s *service) ListCustomerTags(user *schema.User) (*ListCustomerTagsResponse, error) {
+	tags, err := s.db.ListCustomerTags(user.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListCustomerTagsResponse{Tags: tags}, nil
+}
+
+// PutCustomerTags replaces the customer's tags. bastions only pick them up
+// when they're launched or upgraded.
+func (s *service) PutCustomerTags(ctx context.Context, req *PutCustomerTagsRequest) (*PutCustomerTagsResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Tags == nil {
+		req.Tags = make([]*store.CustomerTag, 0)
+	}
+
+	err = launcher.ValidateTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.PutCustomerTags(req.User.CustomerId, req.Tags)
+	if err != nil {
+		log.WithError(err).WithField("customer_id", req.User.CustomerId).Error("error saving customer tags")
+		return nil, err
+	}
+
+	return &PutCustomerTagsResponse{Tags: req.Tags}, nil
+}
@@ -9,6 +9,17 @@ var (
 	errMissingVpc           = errors.New("no vpc id provided")
 	errMissingSubnet        = errors.New("no subnet id provided")
 	errMissingSubnetRouting = errors.New("no subnet routing provided")
+	errMissingBastion       = errors.New("no bastion id provided")
+	errBastionNotFound      = errors.New("bastion not found.")
+	errBastionNotDeletable  = errors.New("bastion can't be deleted in its current state.")
+	errBastionNotUpgradable = errors.New("only active bastions can be upgraded.")
+	errInvalidUsername      = errors.New("username must be lowercase letters, digits, - and _, and not a system user.")
+	errInvalidKey           = errors.New("keys must be openssh public keys.")
+	errBadKeyCount          = errors.New("users must have between 1 and 10 keys.")
+	errBadHealPolicy        = errors.New("inactive_minutes must be at least 10 and max_per_day between 1 and 10.")
+	errLaunchNotFound       = errors.New("bastion has no launch in progress.")
+	errLaunchNotCancellable = errors.New("bastion deletions can't be cancelled.")
+	errNoLaunches           = errors.New("bastion has no launches.")
 	errUnauthorized         = errors.New("unauthorized.")
 	errAWSUnauthorized      = errors.New("Your AWS credentials could not be validated, please check to ensure they are correct.")
 	errMissingAccessKey     = errors.New("missing access_key.")
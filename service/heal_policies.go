@@ -0,0 +1,92 @@
+package service
+
+import (
+	"database/sql"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultHealInactiveMinutes = 30
+	minHealInactiveMinutes     = 10
+	defaultHealMaxPerDay       = 1
+	maxHealMaxPerDay           = 10
+)
+
+type GetHealPolicyResponse struct {
+	Policy *store.HealPolicy `json:"policy"`
+}
+
+type PutHealPolicyRequest struct {
+	User            *schema.User `json:"user"`
+	Enabled         bool         `json:"enabled"`
+	InactiveMinutes int          `json:"inactive_minutes"`
+	MaxPerDay       int          `json:"max_per_day"`
+}
+
+type PutHealPolicyResponse struct {
+	Policy *store.HealPolicy `json:"policy"`
+}
+
+// GetHealPolicy returns the customer's policy, customers that haven't set one
+// get the defaults with healing disabled
+func (s *service) GetHealPolicy(user *schema.User) (*GetHealPolicyResponse, error) {
+	policy, err := s.db.GetHealPolicy(user.CustomerId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.WithError(err).WithField("customer_id", user.CustomerId).Error("error querying database")
+			return nil, err
+		}
+
+		policy = &store.HealPolicy{
+			CustomerID:      user.CustomerId,
+			InactiveMinutes: defaultHealInactiveMinutes,
+			MaxPerDay:       defaultHealMaxPerDay,
+		}
+	}
+
+	return &GetHealPolicyResponse{Policy: policy}, nil
+}
+
+// PutHealPolicy sets whether and how often the customer's bastions get their
+// instances replaced when they stay inactive
+func (s *service) PutHealPolicy(ctx context.Context, req *PutHealPolicyRequest) (*PutHealPolicyResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.InactiveMinutes == 0 {
+		req.InactiveMinutes = defaultHealInactiveMinutes
+	}
+
+	if req.MaxPerDay == 0 {
+		req.MaxPerDay = defaultHealMaxPerDay
+	}
+
+	if req.InactiveMinutes < minHealInactiveMinutes || req.MaxPerDay < 0 || req.MaxPerDay > maxHealMaxPerDay {
+		return nil, errBadHealPolicy
+	}
+
+	policy := &store.HealPolicy{
+		CustomerID:      req.User.CustomerId,
+		Enabled:         req.Enabled,
+		InactiveMinutes: req.InactiveMinutes,
+		MaxPerDay:       req.MaxPerDay,
+	}
+
+	err = s.db.PutHealPolicy(policy)
+	if err != nil {
+		log.WithError(err).WithField("customer_id", req.User.CustomerId).Error("error saving heal policy")
+		return nil, err
+	}
+
+	return &PutHealPolicyResponse{Policy: policy}, nil
+}
@@ -2,10 +2,16 @@ package service
 
 import (
 	"crypto/tls"
+	"github.com/julienschmidt/httprouter"
 	"github.com/opsee/basic/grpcutil"
 	"github.com/opsee/basic/schema"
 	opsee "github.com/opsee/basic/service"
 	"github.com/opsee/basic/tp"
+	"github.com/opsee/keelhaul/autocheck"
+	"github.com/opsee/keelhaul/catalog"
+	"github.com/opsee/keelhaul/launcher"
+	"github.com/opsee/keelhaul/scanner"
+	"github.com/opsee/keelhaul/store"
 	"golang.org/x/net/context"
 	"golang.org/x/net/http2"
 	"net/http"
@@ -32,6 +38,24 @@ func (s *service) StartMux(addr, certfile, certkeyfile string) error {
 
 	// json api
 	router.Handle("GET", "/vpcs/bastions", decoders(schema.User{}, ListBastionsRequest{}), s.listBastions())
+	router.Handle("POST", "/vpcs/launch", decoders(schema.User{}, LaunchBastionRequest{}), s.launchBastion())
+	router.Handle("GET", "/vpcs/launch-options", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{})}, s.listLaunchOptions())
+	router.Handle("POST", "/vpcs/preflight", decoders(schema.User{}, PreflightLaunchRequest{}), s.preflightLaunch())
+	router.Handle("DELETE", "/vpcs/bastions/:id", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.deleteBastion())
+	router.Handle("POST", "/vpcs/bastions/:id/upgrade", append(decoders(schema.User{}, UpgradeBastionRequest{}), tp.ParamsDecoder(paramsKey)), s.upgradeBastion())
+	router.Handle("POST", "/vpcs/bastions/:id/cancel", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.cancelLaunch())
+	router.Handle("GET", "/vpcs/bastions/:id/launch", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.getLaunch())
+	router.Handle("GET", "/vpcs/bastions/:id/events", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.listLaunchEvents())
+	router.Handle("POST", "/vpcs/bastions/:id/rotate-keys", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.rotateBastionKeys())
+	router.Handle("GET", "/bastion-users", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{})}, s.listBastionUsers())
+	router.Handle("PUT", "/bastion-users/:username", append(decoders(schema.User{}, PutBastionUserRequest{}), tp.ParamsDecoder(paramsKey)), s.putBastionUser())
+	router.Handle("DELETE", "/bastion-users/:username", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{}), tp.ParamsDecoder(paramsKey)}, s.deleteBastionUser())
+	router.Handle("GET", "/customer-tags", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{})}, s.listCustomerTags())
+	router.Handle("PUT", "/customer-tags", decoders(schema.User{}, PutCustomerTagsRequest{}), s.putCustomerTags())
+	router.Handle("GET", "/heal-policy", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{})}, s.getHealPolicy())
+	router.Handle("PUT", "/heal-policy", decoders(schema.User{}, PutHealPolicyRequest{}), s.putHealPolicy())
+	router.Handle("GET", "/autocheck-policy", []tp.DecodeFunc{tp.AuthorizationDecodeFunc(userKey, schema.User{})}, s.getAutocheckPolicy())
+	router.Handle("PUT", "/autocheck-policy", decoders(schema.User{}, PutAutocheckPolicyRequest{}), s.putAutocheckPolicy())
 	router.Handle("POST", "/bastions/authenticate", []tp.DecodeFunc{tp.RequestDecodeFunc(requestKey, opsee.AuthenticateBastionRequest{})}, s.authenticateBastion())
 
 	// websocket
@@ -87,6 +111,477 @@ func (s *service) listBastions() tp.HandleFunc {
 	}
 }
 
+func (s *service) launchBastion() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*LaunchBastionRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		resp, err := s.LaunchBastion(ctx, request)
+		if err != nil {
+			switch err {
+			case errMissingRegion, errMissingVpc, errMissingSubnet, errMissingSubnetRouting,
+				catalog.ErrUnsupportedRegion, catalog.ErrUnsupportedInstanceType, scanner.ErrNoHASubnets:
+				return nil, http.StatusBadRequest, err
+			case store.ErrBastionExists, launcher.ErrBastionLimit:
+				return nil, http.StatusConflict, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) listLaunchOptions() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		_, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.ListLaunchOptions(ctx)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) preflightLaunch() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*PreflightLaunchRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		resp, err := s.PreflightLaunch(ctx, request)
+		if err != nil {
+			switch err {
+			case errMissingRegion, errMissingVpc, errMissingSubnet,
+				catalog.ErrUnsupportedRegion, catalog.ErrUnsupportedInstanceType:
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) deleteBastion() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.DeleteBastion(ctx, &DeleteBastionRequest{
+			User:      user,
+			BastionId: params.ByName("id"),
+		})
+
+		if err != nil {
+			switch err {
+			case errBastionNotFound:
+				return nil, http.StatusNotFound, err
+			case errBastionNotDeletable, launcher.ErrBastionBusy:
+				return nil, http.StatusConflict, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) cancelLaunch() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.CancelLaunch(ctx, &CancelLaunchRequest{
+			User:      user,
+			BastionId: params.ByName("id"),
+		})
+
+		if err != nil {
+			switch err {
+			case errBastionNotFound, errLaunchNotFound:
+				return nil, http.StatusNotFound, err
+			case errLaunchNotCancellable:
+				return nil, http.StatusConflict, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) rotateBastionKeys() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.RotateBastionKeys(ctx, &RotateBastionKeysRequest{
+			User:      user,
+			BastionId: params.ByName("id"),
+		})
+
+		if err != nil {
+			switch err {
+			case errBastionNotFound:
+				return nil, http.StatusNotFound, err
+			case errBastionNotUpgradable, launcher.ErrBastionBusy:
+				return nil, http.StatusConflict, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) getLaunch() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.GetLaunch(ctx, &GetLaunchRequest{
+			User:      user,
+			BastionId: params.ByName("id"),
+		})
+
+		if err != nil {
+			switch err {
+			case errBastionNotFound, errNoLaunches:
+				return nil, http.StatusNotFound, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) listLaunchEvents() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.ListLaunchEvents(ctx, &ListLaunchEventsRequest{
+			User:      user,
+			BastionId: params.ByName("id"),
+		})
+
+		if err != nil {
+			switch err {
+			case errBastionNotFound:
+				return nil, http.StatusNotFound, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) listBastionUsers() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.ListBastionUsers(user)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) putBastionUser() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*PutBastionUserRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		request.Username = params.ByName("username")
+		resp, err := s.PutBastionUser(ctx, request)
+		if err != nil {
+			switch err {
+			case errInvalidUsername, errInvalidKey, errBadKeyCount:
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) deleteBastionUser() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		err := s.DeleteBastionUser(ctx, &DeleteBastionUserRequest{
+			User:     user,
+			Username: params.ByName("username"),
+		})
+
+		if err != nil {
+			if err == errInvalidUsername {
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return nil, http.StatusOK, nil
+	}
+}
+
+func (s *service) listCustomerTags() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.ListCustomerTags(user)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) putCustomerTags() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*PutCustomerTagsRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		resp, err := s.PutCustomerTags(ctx, request)
+		if err != nil {
+			switch err {
+			case launcher.ErrTooManyTags, launcher.ErrInvalidTag, launcher.ErrReservedTag, launcher.ErrDuplicateTag:
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) getHealPolicy() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.GetHealPolicy(user)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) putHealPolicy() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*PutHealPolicyRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		resp, err := s.PutHealPolicy(ctx, request)
+		if err != nil {
+			if err == errBadHealPolicy {
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) getAutocheckPolicy() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		resp, err := s.GetAutocheckPolicy(user)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) putAutocheckPolicy() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*PutAutocheckPolicyRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		resp, err := s.PutAutocheckPolicy(ctx, request)
+		if err != nil {
+			if err == autocheck.ErrBadPolicy {
+				return nil, http.StatusBadRequest, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
+func (s *service) upgradeBastion() tp.HandleFunc {
+	return func(ctx context.Context) (interface{}, int, error) {
+		request, ok := ctx.Value(requestKey).(*UpgradeBastionRequest)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		params, ok := ctx.Value(paramsKey).(httprouter.Params)
+		if !ok {
+			return nil, http.StatusBadRequest, errBadRequest
+		}
+
+		user, ok := ctx.Value(userKey).(*schema.User)
+		if !ok {
+			return nil, http.StatusUnauthorized, errUnauthorized
+		}
+
+		request.User = user
+		request.BastionId = params.ByName("id")
+
+		resp, err := s.UpgradeBastion(ctx, request)
+		if err != nil {
+			switch err {
+			case errBastionNotFound:
+				return nil, http.StatusNotFound, err
+			case errBastionNotUpgradable, launcher.ErrBastionBusy:
+				return nil, http.StatusConflict, err
+			}
+
+			return nil, http.StatusInternalServerError, err
+		}
+
+		return resp, http.StatusOK, nil
+	}
+}
+
 func (s *service) authenticateBastion() tp.HandleFunc {
 	return func(ctx context.Context) (interface{}, int, error) {
 		request, ok := ctx.Value(requestKey).(*opsee.AuthenticateBastionRequest)
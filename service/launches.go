@@ -0,0 +1,118 @@
+package service
+
+import (
+	"database/sql"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"golang.org/x/net/context"
+)
+
+type GetLaunchRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+}
+
+type GetLaunchResponse struct {
+	Launch *store.Launch        `json:"launch"`
+	Events []*store.LaunchEvent `json:"events"`
+}
+
+type ListLaunchEventsRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+}
+
+type ListLaunchEventsResponse struct {
+	Events []*store.LaunchEvent `json:"events"`
+}
+
+// GetLaunch returns the bastion's most recent launch, upgrade or deletion and
+// the events it emitted, cloudformation's included
+func (s *service) GetLaunch(ctx context.Context, req *GetLaunchRequest) (*GetLaunchResponse, error) {
+	err := s.validateBastionRequest(req.User, req.BastionId)
+	if err != nil {
+		return nil, err
+	}
+
+	launch, err := s.db.GetLaunch(&store.GetLaunchRequest{
+		BastionID:  req.BastionId,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errNoLaunches
+		}
+
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	events, err := s.db.ListLaunchEvents(&store.ListLaunchEventsRequest{
+		BastionID:  req.BastionId,
+		CustomerID: req.User.CustomerId,
+		LaunchID:   launch.ID,
+	})
+
+	if err != nil {
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	return &GetLaunchResponse{Launch: launch, Events: events}, nil
+}
+
+// ListLaunchEvents returns the events of every launch of the bastion, oldest first
+func (s *service) ListLaunchEvents(ctx context.Context, req *ListLaunchEventsRequest) (*ListLaunchEventsResponse, error) {
+	err := s.validateBastionRequest(req.User, req.BastionId)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.db.ListLaunchEvents(&store.ListLaunchEventsRequest{
+		BastionID:  req.BastionId,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	return &ListLaunchEventsResponse{Events: events}, nil
+}
+
+// validateBastionRequest checks the user and that the bastion is theirs
+func (s *service) validateBastionRequest(user *schema.User, bastionID string) error {
+	if user == nil {
+		return errMissingUser
+	}
+
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+
+	if bastionID == "" {
+		return errMissingBastion
+	}
+
+	_, err = s.db.GetBastion(&store.GetBastionRequest{
+		ID:         bastionID,
+		AnyState:   true,
+		CustomerID: user.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errBastionNotFound
+		}
+
+		log.WithError(err).WithField("bastion_id", bastionID).Error("error querying database")
+		return err
+	}
+
+	return nil
+}
@@ -1,14 +1,109 @@
 package service
 
 import (
+	"database/sql"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
 	opsee "github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/catalog"
+	"github.com/opsee/keelhaul/launcher"
+	"github.com/opsee/keelhaul/scanner"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
 	"github.com/opsee/spanx/spanxcreds"
 	"golang.org/x/net/context"
 )
 
+// ha bastions run an instance in each of this many availability zones
+const haSubnets = 2
+
+type LaunchBastionRequest struct {
+	User             *schema.User `json:"user"`
+	Region           string       `json:"region"`
+	VpcId            string       `json:"vpc_id"`
+	SubnetId         string       `json:"subnet_id"`
+	InstanceSize     string       `json:"instance_size"`
+	SubnetRouting    string       `json:"subnet_routing"`
+	ExecutionGroupId string       `json:"execution_group_id"`
+	KeyName          string       `json:"key_name"`
+	AllowSSH         bool         `json:"allow_ssh"`
+
+	// HA launches the bastion in the best two of the subnets, or of the
+	// vpc's subnets when there are none, in different availability zones
+	HA        bool     `json:"ha"`
+	SubnetIds []string `json:"subnet_ids"`
+}
+
+type LaunchBastionResponse struct {
+	Bastion *com.Bastion `json:"bastion"`
+}
+
+type PreflightLaunchRequest struct {
+	User         *schema.User `json:"user"`
+	Region       string       `json:"region"`
+	VpcId        string       `json:"vpc_id"`
+	SubnetId     string       `json:"subnet_id"`
+	InstanceSize string       `json:"instance_size"`
+	ImageTag     string       `json:"image_tag"`
+	KeyName      string       `json:"key_name"`
+}
+
+type PreflightLaunchResponse struct {
+	Ok       bool                `json:"ok"`
+	Findings []*launcher.Finding `json:"findings"`
+}
+
+type DeleteBastionRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+}
+
+type DeleteBastionResponse struct {
+	Bastion *com.Bastion `json:"bastion"`
+}
+
+type CancelLaunchRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+}
+
+type CancelLaunchResponse struct {
+	Bastion *com.Bastion `json:"bastion"`
+}
+
+type UpgradeBastionRequest struct {
+	User      *schema.User `json:"user"`
+	BastionId string       `json:"bastion_id"`
+	ImageTag  string       `json:"image_tag"`
+}
+
+type UpgradeBastionResponse struct {
+	Bastion *com.Bastion `json:"bastion"`
+}
+
 func (s *service) LaunchStack(ctx context.Context, req *opsee.LaunchStackRequest) (*opsee.LaunchStackResponse, error) {
+	_, err := s.LaunchBastion(ctx, &LaunchBastionRequest{
+		User:             req.User,
+		Region:           req.Region,
+		VpcId:            req.VpcId,
+		SubnetId:         req.SubnetId,
+		InstanceSize:     req.InstanceSize,
+		SubnetRouting:    req.SubnetRouting,
+		ExecutionGroupId: req.ExecutionGroupId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &opsee.LaunchStackResponse{}, nil
+}
+
+// LaunchBastion is LaunchStack with the stack options the grpc request lacks
+func (s *service) LaunchBastion(ctx context.Context, req *LaunchBastionRequest) (*LaunchBastionResponse, error) {
 	if req.User == nil {
 		return nil, errMissingUser
 	}
@@ -26,16 +121,18 @@ func (s *service) LaunchStack(ctx context.Context, req *opsee.LaunchStackRequest
 		return nil, errMissingVpc
 	}
 
-	if req.SubnetId == "" {
+	// ha launches pick their own subnets
+	if !req.HA && req.SubnetId == "" {
 		return nil, errMissingSubnet
 	}
 
-	if req.SubnetRouting == "" {
+	if !req.HA && req.SubnetRouting == "" {
 		return nil, errMissingSubnetRouting
 	}
 
-	if req.InstanceSize == "" {
-		req.InstanceSize = "t2.micro"
+	req.InstanceSize, err = s.validateInstanceSize(ctx, req.Region, req.InstanceSize)
+	if err != nil {
+		return nil, err
 	}
 
 	if req.ExecutionGroupId == "" {
@@ -48,10 +145,274 @@ func (s *service) LaunchStack(ctx context.Context, req *opsee.LaunchStackRequest
 		MaxRetries:  aws.Int(11),
 	})
 
-	_, err = s.launcher.LaunchBastion(sess, req.User, req.ExecutionGroupId, req.Region, req.VpcId, req.SubnetId, req.SubnetRouting, req.InstanceSize, "stable")
+	opts := &launcher.LaunchOptions{
+		KeyName:  req.KeyName,
+		AllowSSH: req.AllowSSH,
+	}
+
+	if req.HA {
+		subnets, err := s.pickHASubnets(sess, req)
+		if err != nil {
+			return nil, err
+		}
+
+		// the bastion is recorded in the best of them
+		req.SubnetId = subnets[0].SubnetId
+		req.SubnetRouting = subnets[0].Routing
+		for _, subnet := range subnets {
+			opts.SubnetIDs = append(opts.SubnetIDs, subnet.SubnetId)
+		}
+	}
+
+	launch, err := s.launcher.LaunchBastion(sess, req.User, req.ExecutionGroupId, req.Region, req.VpcId, req.SubnetId, req.SubnetRouting, req.InstanceSize, "stable", opts)
+
 	if err != nil {
 		return nil, err
 	}
 
-	return &opsee.LaunchStackResponse{}, nil
+	return &LaunchBastionResponse{Bastion: launch.Bastion}, nil
+}
+
+// pickHASubnets scans the region for the two subnets an ha bastion
+// launches in
+func (s *service) pickHASubnets(sess *session.Session, req *LaunchBastionRequest) ([]*schema.Subnet, error) {
+	region, err := scanner.ScanRegion(req.Region, sess)
+	if err != nil {
+		log.WithError(err).Error("error scanning region for ha subnets")
+		return nil, err
+	}
+
+	return scanner.PickSubnets(region, req.VpcId, req.SubnetIds, haSubnets)
+}
+
+type ListLaunchOptionsResponse struct {
+	Catalog *catalog.Catalog `json:"catalog"`
+}
+
+// ListLaunchOptions returns the regions and instance sizes bastions can be
+// launched with. like LaunchBastion it isn't in the grpc service we vendor.
+func (s *service) ListLaunchOptions(ctx context.Context) (*ListLaunchOptionsResponse, error) {
+	return &ListLaunchOptionsResponse{Catalog: s.catalog.Catalog(ctx)}, nil
+}
+
+// validateInstanceSize checks the region and instance size against the
+// catalog, and returns the region's default size when there isn't one
+func (s *service) validateInstanceSize(ctx context.Context, region, instanceSize string) (string, error) {
+	c := s.catalog.Catalog(ctx)
+	if instanceSize == "" {
+		return c.DefaultInstanceType(region)
+	}
+
+	return instanceSize, c.Validate(region, instanceSize)
+}
+
+func (s *service) PreflightLaunch(ctx context.Context, req *PreflightLaunchRequest) (*PreflightLaunchResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Region == "" {
+		return nil, errMissingRegion
+	}
+
+	if req.VpcId == "" {
+		return nil, errMissingVpc
+	}
+
+	if req.SubnetId == "" {
+		return nil, errMissingSubnet
+	}
+
+	req.InstanceSize, err = s.validateInstanceSize(ctx, req.Region, req.InstanceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ImageTag == "" {
+		req.ImageTag = "stable"
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(req.User, s.spanx),
+		Region:      aws.String(req.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	findings := s.launcher.PreflightLaunch(sess, req.User, req.VpcId, req.SubnetId, req.InstanceSize, req.ImageTag, &launcher.LaunchOptions{
+		KeyName: req.KeyName,
+	})
+
+	ok := true
+	for _, f := range findings {
+		if f.Status == launcher.FindingFail {
+			ok = false
+		}
+	}
+
+	return &PreflightLaunchResponse{Ok: ok, Findings: findings}, nil
+}
+
+func (s *service) DeleteBastion(ctx context.Context, req *DeleteBastionRequest) (*DeleteBastionResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.BastionId == "" {
+		return nil, errMissingBastion
+	}
+
+	response, err := s.db.GetBastion(&store.GetBastionRequest{
+		ID:         req.BastionId,
+		AnyState:   true,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errBastionNotFound
+		}
+
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	bastion := response.Bastion
+	switch bastion.State {
+	case com.BastionStateActive, com.BastionStateFailed, com.BastionStateDisabled:
+	default:
+		return nil, errBastionNotDeletable
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(req.User, s.spanx),
+		Region:      aws.String(bastion.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	_, err = s.launcher.DeleteBastion(sess, req.User, bastion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeleteBastionResponse{Bastion: bastion}, nil
+}
+
+func (s *service) UpgradeBastion(ctx context.Context, req *UpgradeBastionRequest) (*UpgradeBastionResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.BastionId == "" {
+		return nil, errMissingBastion
+	}
+
+	if req.ImageTag == "" {
+		req.ImageTag = "stable"
+	}
+
+	response, err := s.db.GetBastion(&store.GetBastionRequest{
+		ID:         req.BastionId,
+		AnyState:   true,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errBastionNotFound
+		}
+
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	bastion := response.Bastion
+	if bastion.State != com.BastionStateActive {
+		return nil, errBastionNotUpgradable
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(req.User, s.spanx),
+		Region:      aws.String(bastion.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	_, err = s.launcher.UpgradeBastion(sess, req.User, bastion, req.ImageTag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpgradeBastionResponse{Bastion: bastion}, nil
+}
+
+func (s *service) CancelLaunch(ctx context.Context, req *CancelLaunchRequest) (*CancelLaunchResponse, error) {
+	if req.User == nil {
+		return nil, errMissingUser
+	}
+
+	err := req.User.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if req.BastionId == "" {
+		return nil, errMissingBastion
+	}
+
+	response, err := s.db.GetBastion(&store.GetBastionRequest{
+		ID:         req.BastionId,
+		AnyState:   true,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errBastionNotFound
+		}
+
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	launch, err := s.db.GetLaunch(&store.GetLaunchRequest{
+		BastionID:  req.BastionId,
+		CustomerID: req.User.CustomerId,
+	})
+
+	if err != nil && err != sql.ErrNoRows {
+		log.WithError(err).WithField("bastion_id", req.BastionId).Error("error querying database")
+		return nil, err
+	}
+
+	if launch != nil && !launcher.CanCancel(launch) {
+		return nil, errLaunchNotCancellable
+	}
+
+	err = s.launcher.CancelLaunch(response.Bastion)
+	if err != nil {
+		switch err {
+		case launcher.ErrLaunchNotFound:
+			return nil, errLaunchNotFound
+		case launcher.ErrLaunchNotCancellable:
+			return nil, errLaunchNotCancellable
+		}
+
+		return nil, err
+	}
+
+	return &CancelLaunchResponse{Bastion: response.Bastion}, nil
 }
@@ -57,6 +57,295 @@ var swaggerMap = j{
 				},
 			},
 		},
+		"/vpcs/launch-options": j{
+			"get": j{
+				"tags": []string{
+					"vpcs",
+				},
+				"operationId": "listLaunchOptions",
+				"summary":     "List the regions and instance sizes bastions can be launched with",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/preflight": j{
+			"post": j{
+				"tags": []string{
+					"vpcs",
+				},
+				"operationId": "preflightLaunch",
+				"summary":     "Check whether a bastion launch is likely to succeed",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/bastions/{id}": j{
+			"delete": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "deleteBastion",
+				"summary":     "Delete a bastion and its cloudformation stack",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/bastions/{id}/upgrade": j{
+			"post": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "upgradeBastion",
+				"summary":     "Upgrade a bastion to the latest image for a tag",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/bastions/{id}/launch": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "getLaunch",
+				"summary":     "Get a bastion's most recent launch and its events",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/bastions/{id}/events": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "listLaunchEvents",
+				"summary":     "List the events of all of a bastion's launches",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/vpcs/bastions/{id}/rotate-keys": j{
+			"post": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "rotateBastionKeys",
+				"summary":     "Update a bastion's stack with the customer's current ssh users",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/bastion-users": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "listBastionUsers",
+				"summary":     "List the customer's bastion ssh users",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/bastion-users/{username}": j{
+			"put": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "putBastionUser",
+				"summary":     "Set a bastion ssh user's authorized keys",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+			"delete": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "deleteBastionUser",
+				"summary":     "Remove a bastion ssh user",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/customer-tags": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "listCustomerTags",
+				"summary":     "List the tags put on every resource of the customer's bastions",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+			"put": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "putCustomerTags",
+				"summary":     "Set the tags put on every resource of the customer's bastions",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"400": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/heal-policy": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "getHealPolicy",
+				"summary":     "Get the customer's policy for replacing inactive bastion instances",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+			"put": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "putHealPolicy",
+				"summary":     "Set the customer's policy for replacing inactive bastion instances",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"400": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
+		"/autocheck-policy": j{
+			"get": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "getAutocheckPolicy",
+				"summary":     "Get the customer's policy for the checks generated for their resources",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+			"put": j{
+				"tags": []string{
+					"bastions",
+				},
+				"operationId": "putAutocheckPolicy",
+				"summary":     "Set the customer's policy for the checks generated for their resources",
+				"parameters":  []string{},
+				"responses": j{
+					"200": j{
+						"description": "Description was not specified",
+					},
+					"400": j{
+						"description": "Description was not specified",
+					},
+					"401": j{
+						"description": "Description was not specified",
+					},
+				},
+			},
+		},
 	},
 	"definitions": j{},
 	"consumes":    j{},
@@ -11,6 +11,7 @@ import (
 	"github.com/opsee/keelhaul/bus"
 	log "github.com/opsee/logrus"
 	"github.com/opsee/vaper"
+	"golang.org/x/net/context"
 )
 
 type websocketHandler struct {
@@ -52,6 +53,8 @@ func (handler websocketHandler) readLoop(s *service) {
 		close(handler.closeChan)
 	}()
 
+	var authenticated *schema.User
+
 	for {
 		_, reader, err := handler.ws.NextReader()
 		if err != nil {
@@ -94,8 +97,31 @@ func (handler websocketHandler) readLoop(s *service) {
 				"user-id":     user.Id,
 			}).Info("authenticated user via websocket")
 
+			authenticated = user
 			handler.userChan <- user
 
+		case "cancel-launch":
+			if authenticated == nil {
+				log.Warn("cancel-launch sent on unauthenticated websocket")
+				continue
+			}
+
+			bastionID, ok := message.Attributes["bastion_id"].(string)
+			if !ok {
+				log.Errorf("no bastion_id sent in cancel-launch request: %#v", message.Attributes)
+				continue
+			}
+
+			// the cancelled launch reports back over the bus
+			_, err = s.CancelLaunch(context.Background(), &CancelLaunchRequest{
+				User:      authenticated,
+				BastionId: bastionID,
+			})
+
+			if err != nil {
+				log.WithError(err).WithField("bastion_id", bastionID).Error("failed to cancel launch")
+			}
+
 		case "subscribe":
 		default:
 			log.Warnf("unrecognized command sent on websocket: %s", message.Command)
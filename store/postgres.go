@@ -8,13 +8,17 @@ import (
 	"strings"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/opsee/basic/com"
 	"github.com/opsee/basic/schema"
 	opsee "github.com/opsee/basic/service"
 	log "github.com/opsee/logrus"
 )
 
+// bastionColumns are the columns com.Bastion has fields for
+const bastionColumns = `id, customer_id, user_id, stack_id, image_id, instance_id, group_id, instance_type,
+	subnet_routing, region, vpc_id, subnet_id, state, password_hash, created_at, updated_at`
+
 type Postgres struct {
 	db *sqlx.DB
 }
@@ -40,17 +44,79 @@ func (pg *Postgres) UpdateBastion(bastion *com.Bastion) error {
 	return pg.updateBastion(pg.db, bastion)
 }
 
+func (pg *Postgres) UpdateBastionTemplateVersion(bastionID, version string) error {
+	_, err := pg.db.Exec("update bastions set template_version = $1 where id = $2", version, bastionID)
+	return err
+}
+
+func (pg *Postgres) UpdateBastionFailureReason(bastionID, reason string) error {
+	_, err := pg.db.Exec("update bastions set failure_reason = $1 where id = $2", reason, bastionID)
+	return err
+}
+
+// UpdateActiveBastion sets the bastion's state and its instance and group ids,
+// as long as it is still active. It returns whether it was.
+func (pg *Postgres) UpdateActiveBastion(bastion *com.Bastion) (bool, error) {
+	result, err := pg.db.Exec(
+		"update bastions set (instance_id, group_id, state) = ($1, $2, $3) where id = $4 and state = $5",
+		bastion.InstanceID,
+		bastion.GroupID,
+		bastion.State,
+		bastion.ID,
+		com.BastionStateActive,
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// UpdateBastionState moves the bastion from one state to another, it returns
+// false when the bastion wasn't in the state it's moved from
+func (pg *Postgres) UpdateBastionState(bastionID, from, to string) (bool, error) {
+	result, err := pg.db.Exec("update bastions set state = $1 where id = $2 and state = $3", to, bastionID, from)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
 func (pg *Postgres) PutRegion(region *schema.Region) error {
 	return pg.putRegion(pg.db, region)
 }
 
 func (pg *Postgres) GetBastion(request *GetBastionRequest) (*GetBastionResponse, error) {
+	query := "select " + bastionColumns + " from bastions where id = $1"
+	args := []interface{}{request.ID}
+
+	if !request.AnyState {
+		args = append(args, request.State)
+		query += fmt.Sprintf(" and state = $%d", len(args))
+	}
+
+	if request.CustomerID != "" {
+		args = append(args, request.CustomerID)
+		query += fmt.Sprintf(" and customer_id = $%d", len(args))
+	}
+
 	bastion := &com.Bastion{}
 	err := pg.db.Get(
 		bastion,
-		"select * from bastions where id = $1 and state = $2",
-		request.ID,
-		request.State,
+		query,
+		args...,
 	)
 
 	if err != nil {
@@ -61,7 +127,7 @@ func (pg *Postgres) GetBastion(request *GetBastionRequest) (*GetBastionResponse,
 }
 
 func (pg *Postgres) ListBastions(request *ListBastionsRequest) (*ListBastionsResponse, error) {
-	query := fmt.Sprintf("select * from bastions where customer_id = $1 and state in (%s)", in(2, len(request.State)))
+	query := fmt.Sprintf("select %s from bastions where customer_id = $1 and state in (%s)", bastionColumns, in(2, len(request.State)))
 	args := make([]interface{}, len(request.State)+1)
 	args[0] = request.CustomerID
 	for i, s := range request.State {
@@ -82,6 +148,28 @@ func (pg *Postgres) ListBastions(request *ListBastionsRequest) (*ListBastionsRes
 	return &ListBastionsResponse{Bastions: bastions}, nil
 }
 
+// ListBastionsByState returns every customer's bastions in the states
+func (pg *Postgres) ListBastionsByState(states ...string) ([]*com.Bastion, error) {
+	query := fmt.Sprintf("select %s from bastions where state in (%s) order by customer_id", bastionColumns, in(1, len(states)))
+	args := make([]interface{}, len(states))
+	for i, s := range states {
+		args[i] = s
+	}
+
+	bastions := make([]*com.Bastion, 0)
+	err := pg.db.Select(
+		&bastions,
+		query,
+		args...,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return bastions, nil
+}
+
 func (pg *Postgres) putBastion(q sqlx.Queryer, bastion *com.Bastion) error {
 	var id string
 	err := sqlx.Get(
@@ -94,6 +182,10 @@ func (pg *Postgres) putBastion(q sqlx.Queryer, bastion *com.Bastion) error {
 		bastion.VPCID, bastion.SubnetID, bastion.SubnetRouting, bastion.State, bastion.PasswordHash,
 	)
 
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "idx_bastions_running_vpc" {
+		return ErrBastionExists
+	}
+
 	bastion.ID = id
 	return err
 }
@@ -102,7 +194,8 @@ func (pg *Postgres) updateBastion(x sqlx.Ext, bastion *com.Bastion) error {
 	_, err := sqlx.NamedExec(
 		x,
 		`update bastions set stack_id = :stack_id, image_id = :image_id,
-		 instance_id = :instance_id, group_id = :group_id, state = :state where id = :id`,
+		 instance_id = :instance_id, group_id = :group_id, state = :state,
+		 password_hash = :password_hash where id = :id`,
 		bastion,
 	)
 
@@ -135,6 +228,397 @@ func (pg *Postgres) putRegion(x sqlx.Ext, region *schema.Region) error {
 	return err
 }
 
+// ListBastionUsers returns one entry per key, ordered by username
+func (pg *Postgres) ListBastionUsers(customerID string) ([]*com.BastionUser, error) {
+	users := make([]*com.BastionUser, 0)
+	err := pg.db.Select(
+		&users,
+		"select username, key from bastion_users where customer_id = $1 order by username, created_at",
+		customerID,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// PutBastionUser replaces the user's keys
+func (pg *Postgres) PutBastionUser(customerID, username string, keys []string) error {
+	tx, err := pg.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from bastion_users where customer_id = $1 and username = $2", customerID, username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, key := range keys {
+		_, err = tx.Exec("insert into bastion_users (customer_id, username, key) values ($1, $2, $3)", customerID, username, key)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (pg *Postgres) DeleteBastionUser(customerID, username string) error {
+	_, err := pg.db.Exec("delete from bastion_users where customer_id = $1 and username = $2", customerID, username)
+	return err
+}
+
+// ListCustomerTags returns the customer's tags ordered by key
+func (pg *Postgres) ListCustomerTags(customerID string) ([]*CustomerTag, error) {
+	tags := make([]*CustomerTag, 0)
+	err := pg.db.Select(
+		&tags,
+		"select key, value from customer_tags where customer_id = $1 order by key",
+		customerID,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// PutCustomerTags replaces the customer's tags
+func (pg *Postgres) PutCustomerTags(customerID string, tags []*CustomerTag) error {
+	tx, err := pg.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from customer_tags where customer_id = $1", customerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.Exec("insert into customer_tags (customer_id, key, value) values ($1, $2, $3)", customerID, tag.Key, tag.Value)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (pg *Postgres) PutLaunch(launch *Launch) error {
+	return pg.putLaunch(pg.db, launch)
+}
+
+// GetLaunch returns the bastion's most recent launch
+func (pg *Postgres) GetLaunch(request *GetLaunchRequest) (*Launch, error) {
+	launch := &Launch{}
+	err := pg.db.Get(
+		launch,
+		"select * from launches where bastion_id = $1 and customer_id = $2 order by created_at desc limit 1",
+		request.BastionID,
+		request.CustomerID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return launch, nil
+}
+
+func (pg *Postgres) PutLaunchEvent(event *LaunchEvent) error {
+	var attributes interface{}
+	if len(event.Attributes) > 0 {
+		attributes = []byte(event.Attributes)
+	}
+
+	_, err := pg.db.Exec(
+		`insert into launch_events (launch_id, bastion_id, customer_id, command, state, message, attributes)
+		 values ($1, $2, $3, $4, $5, $6, $7)`,
+		event.LaunchID, event.BastionID, event.CustomerID, event.Command, event.State, event.Message, attributes,
+	)
+
+	return err
+}
+
+// ListLaunchEvents returns a bastion's events, or just one launch's, oldest first
+func (pg *Postgres) ListLaunchEvents(request *ListLaunchEventsRequest) ([]*LaunchEvent, error) {
+	query := "select * from launch_events where bastion_id = $1 and customer_id = $2"
+	args := []interface{}{request.BastionID, request.CustomerID}
+
+	if request.LaunchID != "" {
+		args = append(args, request.LaunchID)
+		query += fmt.Sprintf(" and launch_id = $%d", len(args))
+	}
+
+	events := make([]*LaunchEvent, 0)
+	err := pg.db.Select(&events, query+" order by id", args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (pg *Postgres) ListLaunches(request *ListLaunchesRequest) (*ListLaunchesResponse, error) {
+	query := fmt.Sprintf("select * from launches where state in (%s) order by created_at", in(1, len(request.State)))
+	args := make([]interface{}, len(request.State))
+	for i, s := range request.State {
+		args[i] = s
+	}
+
+	launches := make([]*Launch, 0)
+	err := pg.db.Select(
+		&launches,
+		query,
+		args...,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &ListLaunchesResponse{Launches: launches}, nil
+}
+
+// ClaimLaunches makes the owner the owner of the launches it's allowed to take
+// over, and returns them as they were before the claim. The launches are
+// locked while being claimed, so a launch only ever goes to one owner.
+func (pg *Postgres) ClaimLaunches(request *ClaimLaunchesRequest) (*ListLaunchesResponse, error) {
+	launches := make([]*Launch, 0)
+	err := pg.db.Select(
+		&launches,
+		`with expired as (select id from launches where state = $2 and
+		 (owner = '' or heartbeat < now() - $3 * interval '1 second') for update),
+		 claimed as (update launches set (owner, heartbeat) = ($1, now()) from expired
+		 where launches.id = expired.id returning launches.id)
+		 select launches.* from launches join claimed on launches.id = claimed.id order by launches.created_at`,
+		request.Owner,
+		request.State,
+		int(request.Expiry.Seconds()),
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &ListLaunchesResponse{Launches: launches}, nil
+}
+
+// HeartbeatLaunches renews the owner's claim on its launches in the state, and
+// returns them, so the owner sees the cancellations requested of it
+func (pg *Postgres) HeartbeatLaunches(owner, state string) (*ListLaunchesResponse, error) {
+	launches := make([]*Launch, 0)
+	err := pg.db.Select(
+		&launches,
+		"update launches set heartbeat = now() where owner = $1 and state = $2 returning *",
+		owner,
+		state,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &ListLaunchesResponse{Launches: launches}, nil
+}
+
+// RequestLaunchCancel marks a launch in the state for cancellation by
+// whichever process owns it, returning false if it's no longer in the state
+func (pg *Postgres) RequestLaunchCancel(id, state string) (bool, error) {
+	result, err := pg.db.Exec("update launches set cancel_requested = true where id = $1 and state = $2", id, state)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+func (pg *Postgres) ListBastionRegions() ([]*BastionRegion, error) {
+	regions := make([]*BastionRegion, 0)
+	err := pg.db.Select(
+		&regions,
+		`select distinct on (customer_id, region) customer_id, user_id, region from bastions
+		 order by customer_id, region, created_at desc`,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return regions, nil
+}
+
+func (pg *Postgres) PutReaperAction(action *ReaperAction) error {
+	_, err := pg.db.Exec(
+		`insert into reaper_actions (customer_id, region, resource_type, resource, action, error)
+		 values ($1, $2, $3, $4, $5, $6)`,
+		action.CustomerID, action.Region, action.ResourceType, action.Resource, action.Action, action.Error,
+	)
+
+	return err
+}
+
+func (pg *Postgres) GetHealPolicy(customerID string) (*HealPolicy, error) {
+	policy := &HealPolicy{}
+	err := pg.db.Get(policy, "select * from heal_policies where customer_id = $1", customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (pg *Postgres) PutHealPolicy(policy *HealPolicy) error {
+	_, err := sqlx.NamedExec(
+		pg.db,
+		`with update_policies as (update heal_policies set (enabled, inactive_minutes, max_per_day) =
+		 (:enabled, :inactive_minutes, :max_per_day) where customer_id = :customer_id returning customer_id),
+		 insert_policies as (insert into heal_policies (customer_id, enabled, inactive_minutes, max_per_day)
+		 select :customer_id as customer_id, :enabled as enabled, :inactive_minutes as inactive_minutes, :max_per_day as max_per_day
+		 where not exists (select customer_id from update_policies limit 1) returning customer_id)
+		 select * from update_policies union all select * from insert_policies`,
+		policy,
+	)
+
+	return err
+}
+
+func (pg *Postgres) GetTrackingState(bastionID string) (*TrackingState, error) {
+	state := &TrackingState{}
+	err := pg.db.Get(state, "select id,customer_id,status,last_seen from bastion_tracking where id = $1", bastionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// ListHealCandidates returns the inactive bastions of customers with healing
+// enabled, whether they've been inactive for long enough is up to the caller
+func (pg *Postgres) ListHealCandidates() ([]*HealCandidate, error) {
+	candidates := make([]*HealCandidate, 0)
+	err := pg.db.Select(
+		&candidates,
+		`select bastion_tracking.id as bastion_id, bastion_tracking.customer_id, bastion_tracking.last_seen,
+		 heal_policies.inactive_minutes, heal_policies.max_per_day
+		 from bastion_tracking inner join heal_policies on (bastion_tracking.customer_id = heal_policies.customer_id)
+		 where heal_policies.enabled and bastion_tracking.status = 'inactive'`,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+func (pg *Postgres) PutHealAction(action *HealAction) error {
+	_, err := pg.db.Exec(
+		`insert into heal_actions (id, bastion_id, customer_id, instance_id, state, error)
+		 values ($1, $2, $3, $4, $5, $6)`,
+		action.ID, action.BastionID, action.CustomerID, action.InstanceID, action.State, action.Error,
+	)
+
+	return err
+}
+
+func (pg *Postgres) UpdateHealAction(action *HealAction) error {
+	_, err := pg.db.Exec(
+		"update heal_actions set (state, error) = ($1, $2) where id = $3",
+		action.State, action.Error, action.ID,
+	)
+
+	return err
+}
+
+// ListHealActions returns actions oldest first
+func (pg *Postgres) ListHealActions(request *ListHealActionsRequest) ([]*HealAction, error) {
+	query := "select * from heal_actions where created_at >= $1"
+	args := []interface{}{request.Since}
+
+	if request.CustomerID != "" {
+		args = append(args, request.CustomerID)
+		query += fmt.Sprintf(" and customer_id = $%d", len(args))
+	}
+
+	if len(request.State) > 0 {
+		query += fmt.Sprintf(" and state in (%s)", in(len(args)+1, len(request.State)))
+		for _, s := range request.State {
+			args = append(args, s)
+		}
+	}
+
+	actions := make([]*HealAction, 0)
+	err := pg.db.Select(&actions, query+" order by created_at", args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+func (pg *Postgres) GetAutocheckPolicy(customerID string) (*AutocheckPolicy, error) {
+	policy := &AutocheckPolicy{}
+	err := pg.db.Get(policy, "select * from autocheck_policies where customer_id = $1", customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (pg *Postgres) PutAutocheckPolicy(policy *AutocheckPolicy) error {
+	_, err := pg.db.Exec(
+		`with update_policies as (update autocheck_policies set policy = $2 where customer_id = $1 returning customer_id),
+		 insert_policies as (insert into autocheck_policies (customer_id, policy) select $1, $2
+		 where not exists (select customer_id from update_policies limit 1) returning customer_id)
+		 select * from update_policies union all select * from insert_policies`,
+		policy.CustomerID, []byte(policy.Policy),
+	)
+
+	return err
+}
+
+func (pg *Postgres) putLaunch(x sqlx.Ext, launch *Launch) error {
+	_, err := sqlx.NamedExec(
+		x,
+		`with update_launches as (update launches set (stage, state, image_tag, image_id, topic_arn,
+		 queue_url, queue_arn, stack_id, password_hash, key_name, allow_ssh, subnet_ids, owner, heartbeat) = (:stage,
+		 :state, :image_tag, :image_id, :topic_arn, :queue_url, :queue_arn, :stack_id, :password_hash, :key_name,
+		 :allow_ssh, :subnet_ids, :owner, now())
+		 where id = :id returning id),
+		 insert_launches as (insert into launches (id, bastion_id, customer_id, command, stage, state, region,
+		 user_json, image_tag, image_id, topic_arn, queue_url, queue_arn, stack_id, password_hash, key_name, allow_ssh,
+		 subnet_ids, owner)
+		 select :id, :bastion_id, :customer_id, :command, :stage, :state, :region, :user_json, :image_tag,
+		 :image_id, :topic_arn, :queue_url, :queue_arn, :stack_id, :password_hash, :key_name, :allow_ssh, :subnet_ids,
+		 :owner
+		 where not exists (select id from update_launches limit 1) returning id)
+		 select * from update_launches union all select * from insert_launches`,
+		launch,
+	)
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "idx_launches_in_progress" {
+		return ErrLaunchInProgress
+	}
+
+	return err
+}
+
 func (pg *Postgres) UpdateTrackingSeen(bastionIDs []string, customerIDs []string) error {
 	for i, s := range bastionIDs {
 		bastionIDs[i] = fmt.Sprintf("cast('%s' as UUID)", s)
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/opsee/basic/com"
@@ -9,19 +11,63 @@ import (
 	opsee "github.com/opsee/basic/service"
 )
 
+// ErrBastionExists is returned when putting a bastion into a vpc that
+// already has one new, launching or active
+var ErrBastionExists = errors.New("vpc already has a bastion")
+
+// ErrLaunchInProgress is returned when putting a launch in progress for a
+// bastion that already has one
+var ErrLaunchInProgress = errors.New("bastion already has a launch in progress")
+
 type Store interface {
 	PutBastion(*com.Bastion) error
 	UpdateBastion(*com.Bastion) error
+	UpdateBastionTemplateVersion(string, string) error
+	UpdateBastionFailureReason(string, string) error
+	UpdateActiveBastion(*com.Bastion) (bool, error)
+	UpdateBastionState(string, string, string) (bool, error)
 	PutRegion(*schema.Region) error
 
 	GetBastion(*GetBastionRequest) (*GetBastionResponse, error)
 	ListBastions(*ListBastionsRequest) (*ListBastionsResponse, error)
+	ListBastionsByState(...string) ([]*com.Bastion, error)
 
 	UpdateTrackingSeen([]string, []string) error
 	GetPendingTrackingStates(string) (*TrackingStateResponse, error)
 	ListTrackingStates(int, int) (*TrackingStateResponse, error)
 	ListBastionStates([]string, ...*opsee.Filter) (*TrackingStateResponse, error)
 	UpdateTrackingState(string, string) error
+
+	ListBastionUsers(string) ([]*com.BastionUser, error)
+	PutBastionUser(string, string, []string) error
+	DeleteBastionUser(string, string) error
+
+	ListCustomerTags(string) ([]*CustomerTag, error)
+	PutCustomerTags(string, []*CustomerTag) error
+
+	PutLaunch(*Launch) error
+	GetLaunch(*GetLaunchRequest) (*Launch, error)
+	ListLaunches(*ListLaunchesRequest) (*ListLaunchesResponse, error)
+	ClaimLaunches(*ClaimLaunchesRequest) (*ListLaunchesResponse, error)
+	HeartbeatLaunches(owner, state string) (*ListLaunchesResponse, error)
+	RequestLaunchCancel(id, state string) (bool, error)
+
+	PutLaunchEvent(*LaunchEvent) error
+	ListLaunchEvents(*ListLaunchEventsRequest) ([]*LaunchEvent, error)
+
+	ListBastionRegions() ([]*BastionRegion, error)
+	PutReaperAction(*ReaperAction) error
+
+	GetHealPolicy(string) (*HealPolicy, error)
+	PutHealPolicy(*HealPolicy) error
+	GetTrackingState(string) (*TrackingState, error)
+	ListHealCandidates() ([]*HealCandidate, error)
+	PutHealAction(*HealAction) error
+	UpdateHealAction(*HealAction) error
+	ListHealActions(*ListHealActionsRequest) ([]*HealAction, error)
+
+	GetAutocheckPolicy(string) (*AutocheckPolicy, error)
+	PutAutocheckPolicy(*AutocheckPolicy) error
 }
 
 type TrackingState struct {
@@ -37,9 +83,13 @@ type TrackingStateResponse struct {
 	States []*TrackingState
 }
 
+// GetBastionRequest gets the bastion in State, or in any state with AnyState.
+// CustomerID limits it to the customer's bastions when it's set.
 type GetBastionRequest struct {
-	ID    string
-	State string
+	ID         string
+	State      string
+	AnyState   bool
+	CustomerID string
 }
 
 type GetBastionResponse struct {
@@ -54,3 +104,145 @@ type ListBastionsRequest struct {
 type ListBastionsResponse struct {
 	Bastions []*com.Bastion
 }
+
+// CustomerTag is a tag the customer wants on every resource of their bastions
+type CustomerTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Launch is the persisted progress of a launcher workflow, updated as each
+// of its stages completes.
+type Launch struct {
+	ID              string    `json:"id"`
+	BastionID       string    `json:"bastion_id" db:"bastion_id"`
+	CustomerID      string    `json:"customer_id" db:"customer_id"`
+	Command         string    `json:"command"`
+	Stage           string    `json:"stage"`
+	State           string    `json:"state"`
+	Region          string    `json:"region"`
+	UserJSON        []byte    `json:"-" db:"user_json"`
+	ImageTag        string    `json:"image_tag" db:"image_tag"`
+	ImageID         string    `json:"image_id" db:"image_id"`
+	TopicARN        string    `json:"topic_arn" db:"topic_arn"`
+	QueueURL        string    `json:"queue_url" db:"queue_url"`
+	QueueARN        string    `json:"queue_arn" db:"queue_arn"`
+	StackID         string    `json:"stack_id" db:"stack_id"`
+	PasswordHash    string    `json:"-" db:"password_hash"`
+	KeyName         string    `json:"key_name" db:"key_name"`
+	AllowSSH        bool      `json:"allow_ssh" db:"allow_ssh"`
+	SubnetIDs       string    `json:"subnet_ids" db:"subnet_ids"`
+	Owner           string    `json:"owner"`
+	Heartbeat       time.Time `json:"heartbeat"`
+	CancelRequested bool      `json:"cancel_requested" db:"cancel_requested"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type GetLaunchRequest struct {
+	BastionID  string
+	CustomerID string
+}
+
+type LaunchEvent struct {
+	ID         int64           `json:"id"`
+	LaunchID   string          `json:"launch_id" db:"launch_id"`
+	BastionID  string          `json:"bastion_id" db:"bastion_id"`
+	CustomerID string          `json:"customer_id" db:"customer_id"`
+	Command    string          `json:"command"`
+	State      string          `json:"state"`
+	Message    string          `json:"message"`
+	Attributes json.RawMessage `json:"attributes"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+}
+
+type ListLaunchEventsRequest struct {
+	BastionID  string
+	CustomerID string
+	LaunchID   string
+}
+
+type ListLaunchesRequest struct {
+	State []string
+}
+
+// ClaimLaunchesRequest is for taking over the launches in State that have no
+// owner, or whose owner hasn't heartbeated them in Expiry
+type ClaimLaunchesRequest struct {
+	Owner  string
+	State  string
+	Expiry time.Duration
+}
+
+type ListLaunchesResponse struct {
+	Launches []*Launch
+}
+
+// BastionRegion is a region a customer has had bastions in, and the user
+// that launched the newest of them
+type BastionRegion struct {
+	CustomerID string `json:"customer_id" db:"customer_id"`
+	UserID     int    `json:"user_id" db:"user_id"`
+	Region     string `json:"region"`
+}
+
+// ReaperAction is the audit record of something the reaper did, or tried
+// to do, in a customer's account
+type ReaperAction struct {
+	ID           int64     `json:"id"`
+	CustomerID   string    `json:"customer_id" db:"customer_id"`
+	Region       string    `json:"region"`
+	ResourceType string    `json:"resource_type" db:"resource_type"`
+	Resource     string    `json:"resource"`
+	Action       string    `json:"action"`
+	Error        string    `json:"error"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// HealPolicy is a customer's opt-in to having the instances of bastions that
+// stay inactive replaced
+type HealPolicy struct {
+	CustomerID      string    `json:"customer_id" db:"customer_id"`
+	Enabled         bool      `json:"enabled"`
+	InactiveMinutes int       `json:"inactive_minutes" db:"inactive_minutes"`
+	MaxPerDay       int       `json:"max_per_day" db:"max_per_day"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HealCandidate is an inactive bastion whose customer has healing enabled
+type HealCandidate struct {
+	BastionID       string    `json:"bastion_id" db:"bastion_id"`
+	CustomerID      string    `json:"customer_id" db:"customer_id"`
+	LastSeen        time.Time `json:"last_seen" db:"last_seen"`
+	InactiveMinutes int       `json:"inactive_minutes" db:"inactive_minutes"`
+	MaxPerDay       int       `json:"max_per_day" db:"max_per_day"`
+}
+
+// HealAction is the record of a bastion instance being replaced, its id is
+// used as the launch id of the events it emits
+type HealAction struct {
+	ID         string    `json:"id"`
+	BastionID  string    `json:"bastion_id" db:"bastion_id"`
+	CustomerID string    `json:"customer_id" db:"customer_id"`
+	InstanceID string    `json:"instance_id" db:"instance_id"`
+	State      string    `json:"state"`
+	Error      string    `json:"error"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ListHealActionsRequest struct {
+	CustomerID string
+	State      []string
+	Since      time.Time
+}
+
+// AutocheckPolicy is a customer's rules for the checks launches generate for
+// their resources, the policy is the json of an autocheck.Policy
+type AutocheckPolicy struct {
+	CustomerID string          `json:"customer_id" db:"customer_id"`
+	Policy     json.RawMessage `json:"policy"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,55 @@
+//go:build ignore
+// +build ignore
+
+// gen writes the bastion's cloudformation templates in etc/ into
+// templates_gen.go, run it with go generate after changing a template. the
+// ingress template isn't included, cloudformation only nests stacks from s3,
+// so it's deployed there with make deploy-cf.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strconv"
+)
+
+var templates = []struct {
+	name string
+	file string
+}{
+	{"bastionTemplateBody", "../etc/bastion-cf.template"},
+	{"bastionHATemplateBody", "../etc/bastion-ha-cf.template"},
+}
+
+func main() {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "// generated by gen.go from etc/*.template, DO NOT EDIT")
+	fmt.Fprintln(buf, "")
+	fmt.Fprintln(buf, "package templates")
+	fmt.Fprintln(buf, "")
+	fmt.Fprintln(buf, "const (")
+
+	for _, t := range templates {
+		body, err := ioutil.ReadFile(t.file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Fprintf(buf, "\t%s = %s\n", t.name, strconv.Quote(string(body)))
+	}
+
+	fmt.Fprintln(buf, ")")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("templates_gen.go", src, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+package templates
+
+//go:generate go run gen.go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+var (
+	// Bastion is the bastion's cloudformation template
+	Bastion = mustParse("bastion-cf.template", bastionTemplateBody)
+
+	// BastionHA is the bastion's template for launches spanning two
+	// availability zones
+	BastionHA = mustParse("bastion-ha-cf.template", bastionHATemplateBody)
+)
+
+type Parameter struct {
+	Type    string      `json:"Type"`
+	Default interface{} `json:"Default"`
+}
+
+type Template struct {
+	Name       string
+	Body       string
+	Version    string
+	Parameters map[string]*Parameter
+}
+
+// Parse reads a template's declared parameters and versions it by the
+// hash of its body
+func Parse(name, body string) (*Template, error) {
+	decoded := struct {
+		Parameters map[string]*Parameter `json:"Parameters"`
+	}{}
+
+	err := json.Unmarshal([]byte(body), &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", name, err)
+	}
+
+	sum := sha256.Sum256([]byte(body))
+
+	return &Template{
+		Name:       name,
+		Body:       body,
+		Version:    hex.EncodeToString(sum[:])[:12],
+		Parameters: decoded.Parameters,
+	}, nil
+}
+
+func mustParse(name, body string) *Template {
+	t, err := Parse(name, body)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+// ValidateParameters checks that the keys are all declared by the template,
+// and that every parameter without a default is among them
+func (t *Template) ValidateParameters(keys []string) error {
+	sent := make(map[string]bool)
+	for _, k := range keys {
+		if _, ok := t.Parameters[k]; !ok {
+			return fmt.Errorf("%s doesn't declare parameter %s", t.Name, k)
+		}
+
+		sent[k] = true
+	}
+
+	missing := make([]string, 0)
+	for k, p := range t.Parameters {
+		if p.Default == nil && !sent[k] {
+			missing = append(missing, k)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s requires parameters %v", t.Name, missing)
+	}
+
+	return nil
+}
@@ -0,0 +1,385 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/store"
+	log "github.com/opsee/logrus"
+	"github.com/opsee/spanx/spanxcreds"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const (
+	commandHeal    = "heal-bastion"
+	healInProgress = "in-progress"
+	healComplete   = "complete"
+	healFailed     = "failed"
+
+	// how long a replacement instance has to connect before we give up on it
+	healReconnectTimeout = time.Duration(20) * time.Minute
+
+	// policies cap the number of heals a customer gets in this window
+	healCapWindow = time.Duration(24) * time.Hour
+)
+
+// the sdk we vendor has no interface for autoscaling, these are the calls we make
+type autoscalingAPI interface {
+	DescribeAutoScalingGroups(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+	SetInstanceHealth(*autoscaling.SetInstanceHealthInput) (*autoscaling.SetInstanceHealthOutput, error)
+	TerminateInstanceInAutoScalingGroup(*autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error)
+}
+
+type healClients struct {
+	cloudformation cloudformationiface.CloudFormationAPI
+	autoscaling    autoscalingAPI
+}
+
+func (t *tracker) bastionHealClients(bastion *com.Bastion) *healClients {
+	user := &schema.User{
+		Id:         int32(bastion.UserID),
+		CustomerId: bastion.CustomerID,
+	}
+
+	sess := session.New(&aws.Config{
+		Credentials: spanxcreds.NewSpanxCredentials(user, t.spanx),
+		Region:      aws.String(bastion.Region),
+		MaxRetries:  aws.Int(11),
+	})
+
+	return &healClients{
+		cloudformation: cloudformation.New(sess),
+		autoscaling:    autoscaling.New(sess),
+	}
+}
+
+// heal follows up on instances it replaced earlier, then replaces the
+// instances of bastions that have been inactive for longer than their
+// customer's policy allows. it stops early if ctx is cancelled.
+func (t *tracker) heal(ctx context.Context) {
+	pending, err := t.db.ListHealActions(&store.ListHealActionsRequest{State: []string{healInProgress}})
+	if err != nil {
+		log.WithError(err).Error("failed to list heal actions")
+		return
+	}
+
+	healing := make(map[string]bool)
+	for _, action := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+
+		healing[action.BastionID] = true
+		t.followUpHeal(action)
+	}
+
+	candidates, err := t.db.ListHealCandidates()
+	if err != nil {
+		log.WithError(err).Error("failed to list heal candidates")
+		return
+	}
+
+	for _, c := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if healing[c.BastionID] {
+			continue
+		}
+
+		if time.Since(c.LastSeen) < time.Duration(c.InactiveMinutes)*time.Minute {
+			continue
+		}
+
+		t.healBastion(c)
+	}
+}
+
+func (t *tracker) followUpHeal(action *store.HealAction) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": action.CustomerID,
+		"bastion_id":  action.BastionID,
+		"instance_id": action.InstanceID,
+	})
+
+	state, err := t.db.GetTrackingState(action.BastionID)
+	if err != nil {
+		logger.WithError(err).Error("failed to get bastion tracking state")
+		return
+	}
+
+	if state.Status == "active" {
+		action.State = healComplete
+		t.updateHealAction(action, "bastion reconnected after its instance was replaced")
+		return
+	}
+
+	if time.Since(action.CreatedAt) < healReconnectTimeout {
+		return
+	}
+
+	action.State = healFailed
+	action.Error = fmt.Sprintf("bastion did not reconnect within %s of its instance being replaced", healReconnectTimeout)
+	t.updateHealAction(action, "bastion did not reconnect after its instance was replaced")
+	t.escalateHeal(action, state.LastSeen)
+}
+
+func (t *tracker) healBastion(c *store.HealCandidate) {
+	logger := log.WithFields(log.Fields{
+		"customer_id": c.CustomerID,
+		"bastion_id":  c.BastionID,
+	})
+
+	actions, err := t.db.ListHealActions(&store.ListHealActionsRequest{
+		CustomerID: c.CustomerID,
+		Since:      time.Now().Add(-healCapWindow),
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to list heal actions")
+		return
+	}
+
+	if len(actions) >= c.MaxPerDay {
+		logger.Infof("not healing bastion, customer has had %d heals today", len(actions))
+		return
+	}
+
+	resp, err := t.db.GetBastion(&store.GetBastionRequest{ID: c.BastionID, CustomerID: c.CustomerID, AnyState: true})
+	if err != nil {
+		logger.WithError(err).Error("failed to get bastion")
+		return
+	}
+
+	// bastions that were deleted or failed aren't coming back by replacing
+	// their instance
+	bastion := resp.Bastion
+	if bastion.State != com.BastionStateActive {
+		return
+	}
+
+	clients := t.healClients(bastion)
+	groupName, err := bastionAutoScalingGroup(clients, bastion)
+	if err != nil {
+		logger.WithError(err).Error("failed to get bastion autoscaling group")
+		return
+	}
+
+	if groupName == "" {
+		logger.Info("not healing bastion, its stack no longer exists")
+		return
+	}
+
+	instanceIDs, err := autoScalingGroupInstances(clients, groupName)
+	if err != nil {
+		logger.WithError(err).Error("failed to get bastion instances")
+		return
+	}
+
+	if len(instanceIDs) == 0 {
+		logger.Info("not healing bastion, its autoscaling group has no instances")
+		return
+	}
+
+	// the bastion is inactive when none of its instances' checkers are up, so
+	// every instance of an HA bastion is replaced, as many as the cap allows
+	if remaining := c.MaxPerDay - len(actions); len(instanceIDs) > remaining {
+		logger.Infof("only replacing %d of the bastion's %d instances, customer has had %d heals today", remaining, len(instanceIDs), len(actions))
+		instanceIDs = instanceIDs[:remaining]
+	}
+
+	for _, instanceID := range instanceIDs {
+		t.healInstance(clients, c, instanceID)
+	}
+}
+
+func (t *tracker) healInstance(clients *healClients, c *store.HealCandidate, instanceID string) {
+	// the action is recorded first so that it counts against the cap even
+	// when replacing the instance fails
+	action := &store.HealAction{
+		ID:         uuid.NewV4().String(),
+		BastionID:  c.BastionID,
+		CustomerID: c.CustomerID,
+		InstanceID: instanceID,
+		State:      healInProgress,
+		CreatedAt:  time.Now(),
+	}
+
+	err := t.db.PutHealAction(action)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"customer_id": c.CustomerID,
+			"bastion_id":  c.BastionID,
+			"instance_id": instanceID,
+		}).Error("failed to record heal action")
+		return
+	}
+
+	t.healEvent(action, fmt.Sprintf("replacing instance %s of bastion inactive since %s", instanceID, c.LastSeen.Format(time.RFC1123)))
+
+	err = replaceInstance(clients, instanceID)
+	if err != nil {
+		action.State = healFailed
+		action.Error = err.Error()
+		t.updateHealAction(action, fmt.Sprintf("failed replacing instance %s", instanceID))
+		t.escalateHeal(action, c.LastSeen)
+	}
+}
+
+// bastionAutoScalingGroup returns the name of the group in the bastion's
+// stack, or nothing if the stack is gone
+func bastionAutoScalingGroup(c *healClients, bastion *com.Bastion) (string, error) {
+	stackName := bastion.StackName()
+	if bastion.StackID.Valid {
+		stackName = bastion.StackID.String
+	}
+
+	resources, err := c.cloudformation.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(stackName),
+	})
+
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	for _, resource := range resources.StackResources {
+		if aws.StringValue(resource.ResourceType) == "AWS::AutoScaling::AutoScalingGroup" {
+			return aws.StringValue(resource.PhysicalResourceId), nil
+		}
+	}
+
+	return "", nil
+}
+
+// autoScalingGroupInstances returns the group's instances that are in service
+func autoScalingGroupInstances(c *healClients, groupName string) ([]string, error) {
+	groups, err := c.autoscaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(groupName)},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups.AutoScalingGroups) == 0 {
+		return nil, nil
+	}
+
+	instanceIDs := make([]string, 0)
+	for _, instance := range groups.AutoScalingGroups[0].Instances {
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			instanceIDs = append(instanceIDs, aws.StringValue(instance.InstanceId))
+		}
+	}
+
+	return instanceIDs, nil
+}
+
+// replaceInstance marks the instance unhealthy so that its group replaces it,
+// and terminates it outright if that doesn't work
+func replaceInstance(c *healClients, instanceID string) error {
+	_, err := c.autoscaling.SetInstanceHealth(&autoscaling.SetInstanceHealthInput{
+		InstanceId:               aws.String(instanceID),
+		HealthStatus:             aws.String("Unhealthy"),
+		ShouldRespectGracePeriod: aws.Bool(false),
+	})
+
+	if err == nil {
+		return nil
+	}
+
+	log.WithError(err).WithField("instance_id", instanceID).Warn("failed setting instance health, terminating it")
+	_, err = c.autoscaling.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String(instanceID),
+		ShouldDecrementDesiredCapacity: aws.Bool(false),
+	})
+
+	return err
+}
+
+func (t *tracker) updateHealAction(action *store.HealAction, message string) {
+	err := t.db.UpdateHealAction(action)
+	if err != nil {
+		log.WithError(err).WithField("bastion_id", action.BastionID).Error("failed to update heal action")
+	}
+
+	t.healEvent(action, message)
+}
+
+// healEvent publishes the action's progress, and keeps it with the bastion's
+// launch events under the action's id
+func (t *tracker) healEvent(action *store.HealAction, message string) {
+	attrs := map[string]interface{}{
+		"instance_id": action.InstanceID,
+	}
+
+	if action.Error != "" {
+		attrs["error"] = action.Error
+	}
+
+	logger := log.WithFields(log.Fields(attrs)).WithField("bastion_id", action.BastionID)
+	logger.Info(fmt.Sprintf("[%s](%s): %s", commandHeal, action.State, message))
+
+	err := t.bus.Publish(&bus.Message{
+		Command:    commandHeal,
+		State:      action.State,
+		Message:    message,
+		Attributes: attrs,
+		CustomerID: action.CustomerID,
+		BastionID:  action.BastionID,
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to publish heal event")
+	}
+
+	attributes, err := json.Marshal(attrs)
+	if err != nil {
+		logger.WithError(err).Error("failed to marshal heal event attributes")
+		attributes = nil
+	}
+
+	err = t.db.PutLaunchEvent(&store.LaunchEvent{
+		LaunchID:   action.ID,
+		BastionID:  action.BastionID,
+		CustomerID: action.CustomerID,
+		Command:    commandHeal,
+		State:      action.State,
+		Message:    message,
+		Attributes: attributes,
+	})
+
+	if err != nil {
+		logger.WithError(err).Error("failed to record heal event")
+	}
+}
+
+func (t *tracker) escalateHeal(action *store.HealAction, lastSeen time.Time) {
+	err := t.notifier.NotifySlackBastionHealFailed(action.CustomerID, map[string]interface{}{
+		"bastion_id":  action.BastionID,
+		"customer_id": action.CustomerID,
+		"instance_id": action.InstanceID,
+		"last_seen":   lastSeen.Local().Format(time.RFC1123),
+		"error":       action.Error,
+	})
+
+	if err != nil {
+		log.WithError(err).WithField("bastion_id", action.BastionID).Error("failed to notify heal failure")
+	}
+}
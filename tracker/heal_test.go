@@ -0,0 +1,356 @@
+package tracker
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+const (
+	customerID  = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+	bastionID   = "5d8a1a0e-0d8a-11e6-b9d1-3b8f0a2b6b1c"
+	groupName   = "opsee-bastion-asg"
+	instanceID  = "i-abcdef"
+	instanceID2 = "i-fedcba"
+)
+
+type fakeCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	missing bool
+}
+
+func (f *fakeCloudFormation) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
+	if f.missing {
+		return nil, awserr.New("ValidationError", "Stack with id opsee-bastion-"+bastionID+" does not exist", nil)
+	}
+
+	return &cloudformation.DescribeStackResourcesOutput{
+		StackResources: []*cloudformation.StackResource{
+			{
+				ResourceType:       aws.String("AWS::AutoScaling::AutoScalingGroup"),
+				PhysicalResourceId: aws.String(groupName),
+			},
+		},
+	}, nil
+}
+
+type fakeAutoScaling struct {
+	instances    []string
+	healthErr    error
+	terminateErr error
+	unhealthy    []string
+	terminated   []string
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	instances := []*autoscaling.Instance{
+		// instances on their way out aren't replaced
+		{InstanceId: aws.String("i-terminating"), LifecycleState: aws.String(autoscaling.LifecycleStateTerminating)},
+	}
+
+	for _, id := range f.instances {
+		instances = append(instances, &autoscaling.Instance{
+			InstanceId:     aws.String(id),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	return &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{
+				AutoScalingGroupName: aws.String(groupName),
+				Instances:            instances,
+			},
+		},
+	}, nil
+}
+
+func (f *fakeAutoScaling) SetInstanceHealth(input *autoscaling.SetInstanceHealthInput) (*autoscaling.SetInstanceHealthOutput, error) {
+	if f.healthErr != nil {
+		return nil, f.healthErr
+	}
+
+	f.unhealthy = append(f.unhealthy, aws.StringValue(input.InstanceId))
+	return &autoscaling.SetInstanceHealthOutput{}, nil
+}
+
+func (f *fakeAutoScaling) TerminateInstanceInAutoScalingGroup(input *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	if f.terminateErr != nil {
+		return nil, f.terminateErr
+	}
+
+	f.terminated = append(f.terminated, aws.StringValue(input.InstanceId))
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+}
+
+type fakeStore struct {
+	store.Store
+	candidates []*store.HealCandidate
+	actions    []*store.HealAction
+	status     string
+	events     []*store.LaunchEvent
+}
+
+func (s *fakeStore) ListHealCandidates() ([]*store.HealCandidate, error) {
+	return s.candidates, nil
+}
+
+func (s *fakeStore) ListHealActions(request *store.ListHealActionsRequest) ([]*store.HealAction, error) {
+	actions := make([]*store.HealAction, 0)
+	for _, action := range s.actions {
+		if action.CreatedAt.Before(request.Since) {
+			continue
+		}
+
+		if request.CustomerID != "" && action.CustomerID != request.CustomerID {
+			continue
+		}
+
+		if len(request.State) > 0 && action.State != request.State[0] {
+			continue
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+func (s *fakeStore) PutHealAction(action *store.HealAction) error {
+	s.actions = append(s.actions, action)
+	return nil
+}
+
+func (s *fakeStore) UpdateHealAction(action *store.HealAction) error {
+	return nil
+}
+
+func (s *fakeStore) GetTrackingState(id string) (*store.TrackingState, error) {
+	return &store.TrackingState{ID: id, CustomerID: customerID, Status: s.status}, nil
+}
+
+func (s *fakeStore) GetBastion(request *store.GetBastionRequest) (*store.GetBastionResponse, error) {
+	return &store.GetBastionResponse{
+		Bastion: &com.Bastion{
+			ID:         bastionID,
+			CustomerID: customerID,
+			State:      com.BastionStateActive,
+			StackID:    sql.NullString{String: "arn:aws:cloudformation:us-west-2:123456789012:stack/opsee-bastion-" + bastionID, Valid: true},
+		},
+	}, nil
+}
+
+func (s *fakeStore) PutLaunchEvent(event *store.LaunchEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+type fakeBus struct {
+	bus.Bus
+	messages []*bus.Message
+}
+
+func (b *fakeBus) Publish(msg *bus.Message) error {
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+type fakeNotifier struct {
+	notifier.Notifier
+	healFailures []map[string]interface{}
+}
+
+func (n *fakeNotifier) NotifySlackBastionHealFailed(customerID string, vars map[string]interface{}) error {
+	n.healFailures = append(n.healFailures, vars)
+	return nil
+}
+
+var healTests = []struct {
+	instances    []string
+	inactiveFor  time.Duration
+	pending      time.Duration
+	todaysHeals  int
+	status       string
+	stackMissing bool
+	healthErr    error
+	terminateErr error
+	unhealthy    []string
+	terminated   []string
+	states       []string
+	escalated    bool
+}{
+	// inactive for longer than the policy allows
+	{
+		inactiveFor: time.Hour,
+		status:      "inactive",
+		unhealthy:   []string{instanceID},
+		states:      []string{healInProgress},
+	},
+	// every instance of an HA bastion is replaced
+	{
+		instances:   []string{instanceID, instanceID2},
+		inactiveFor: time.Hour,
+		status:      "inactive",
+		unhealthy:   []string{instanceID, instanceID2},
+		states:      []string{healInProgress, healInProgress},
+	},
+	// as many as the customer has heals left for
+	{
+		instances:   []string{instanceID, instanceID2},
+		inactiveFor: time.Hour,
+		todaysHeals: 1,
+		status:      "inactive",
+		unhealthy:   []string{instanceID},
+		states:      []string{healInProgress},
+	},
+	// not inactive for long enough
+	{
+		inactiveFor: 10 * time.Minute,
+		status:      "inactive",
+	},
+	// the customer has had their heals for the day
+	{
+		inactiveFor: time.Hour,
+		todaysHeals: 2,
+		status:      "inactive",
+	},
+	// the bastion's stack is gone
+	{
+		inactiveFor:  time.Hour,
+		status:       "inactive",
+		stackMissing: true,
+	},
+	// the instance is terminated when its health can't be set
+	{
+		inactiveFor: time.Hour,
+		status:      "inactive",
+		healthErr:   errors.New("AccessDenied"),
+		terminated:  []string{instanceID},
+		states:      []string{healInProgress},
+	},
+	{
+		inactiveFor:  time.Hour,
+		status:       "inactive",
+		healthErr:    errors.New("AccessDenied"),
+		terminateErr: errors.New("AccessDenied"),
+		states:       []string{healInProgress, healFailed},
+		escalated:    true,
+	},
+	// an earlier heal brought the bastion back
+	{
+		pending: 5 * time.Minute,
+		status:  "active",
+		states:  []string{healComplete},
+	},
+	// an earlier heal is still waiting on the bastion, so it isn't healed again
+	{
+		inactiveFor: time.Hour,
+		pending:     5 * time.Minute,
+		status:      "inactive",
+	},
+	// an earlier heal didn't bring the bastion back
+	{
+		pending:   time.Hour,
+		status:    "inactive",
+		states:    []string{healFailed},
+		escalated: true,
+	},
+}
+
+func TestHeal(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tt := range healTests {
+		var (
+			db = &fakeStore{status: tt.status}
+			b  = &fakeBus{}
+			n  = &fakeNotifier{}
+			c  = &healClients{
+				cloudformation: &fakeCloudFormation{missing: tt.stackMissing},
+				autoscaling:    &fakeAutoScaling{instances: tt.instances, healthErr: tt.healthErr, terminateErr: tt.terminateErr},
+			}
+		)
+
+		if tt.inactiveFor > 0 {
+			db.candidates = []*store.HealCandidate{
+				{
+					BastionID:       bastionID,
+					CustomerID:      customerID,
+					LastSeen:        time.Now().Add(-tt.inactiveFor),
+					InactiveMinutes: 30,
+					MaxPerDay:       2,
+				},
+			}
+		}
+
+		for i := 0; i < tt.todaysHeals; i++ {
+			db.actions = append(db.actions, &store.HealAction{
+				BastionID:  bastionID,
+				CustomerID: customerID,
+				State:      healFailed,
+				CreatedAt:  time.Now().Add(-time.Hour),
+			})
+		}
+
+		if tt.pending > 0 {
+			db.actions = append(db.actions, &store.HealAction{
+				ID:         "e1b3f5c2-0d8a-11e6-b9d1-3b8f0a2b6b1c",
+				BastionID:  bastionID,
+				CustomerID: customerID,
+				InstanceID: "i-123456",
+				State:      healInProgress,
+				CreatedAt:  time.Now().Add(-tt.pending),
+			})
+		}
+
+		if tt.instances == nil {
+			c.autoscaling.(*fakeAutoScaling).instances = []string{instanceID}
+		}
+
+		tr := New(db, nil, b, n, nil)
+		tr.healClients = func(*com.Bastion) *healClients {
+			return c
+		}
+
+		tr.heal(context.Background())
+
+		asg := c.autoscaling.(*fakeAutoScaling)
+		assert.Equal(tt.unhealthy, asg.unhealthy)
+		assert.Equal(tt.terminated, asg.terminated)
+
+		// every step of a heal is published and kept with the launch events
+		states := make([]string, 0, len(b.messages))
+		for _, msg := range b.messages {
+			assert.Equal(commandHeal, msg.Command)
+			assert.Equal(bastionID, msg.BastionID)
+			states = append(states, msg.State)
+		}
+
+		if tt.states == nil {
+			assert.Empty(states)
+		} else {
+			assert.Equal(tt.states, states)
+		}
+
+		assert.Len(db.events, len(b.messages))
+
+		if tt.escalated {
+			assert.Len(n.healFailures, 1)
+		} else {
+			assert.Empty(n.healFailures)
+		}
+	}
+}
@@ -1,24 +1,25 @@
 package tracker
 
 import (
-	"encoding/json"
 	"path"
 	"regexp"
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
+	"github.com/opsee/basic/com"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/keelhaul/bus"
+	"github.com/opsee/keelhaul/leader"
 	"github.com/opsee/keelhaul/notifier"
+	"github.com/opsee/keelhaul/router"
 	"github.com/opsee/keelhaul/store"
 	log "github.com/opsee/logrus"
-	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
 )
 
 const (
 	routePath        = "/opsee.co/routes"
 	trackerKeyPath   = "/opsee.co/config/keelhaul/trackerLock"
-	leaderCheckRate  = time.Duration(30) * time.Second
-	serviceTTL       = time.Duration(60) * time.Second
 	minUpdateDelay   = time.Duration(180) * time.Second // matches TTL for routes
 	updateBatchSize  = 128
 	inactiveInterval = "3 minutes"
@@ -28,105 +29,50 @@ const (
 type tracker struct {
 	db             store.Store
 	etcd           etcd.KeysAPI
-	quit           chan struct{}
-	isServing      bool
+	stop           context.CancelFunc
 	lastUpdateTime time.Time
-	selfID         string
-	offerOptions   etcd.SetOptions
-	contOptions    etcd.SetOptions
 	notifier       notifier.Notifier
+	bus            bus.Bus
+	spanx          service.SpanxClient
+	healClients    func(*com.Bastion) *healClients
 }
 
-func New(db store.Store, etcdKAPI etcd.KeysAPI, notifier notifier.Notifier) *tracker {
-	selfID := uuid.NewV4().String()
-	return &tracker{
+func New(db store.Store, etcdKAPI etcd.KeysAPI, bus bus.Bus, notifier notifier.Notifier, spanx service.SpanxClient) *tracker {
+	t := &tracker{
 		db:       db,
 		etcd:     etcdKAPI,
-		quit:     make(chan struct{}, 1),
-		selfID:   selfID,
 		notifier: notifier,
-		offerOptions: etcd.SetOptions{
-			PrevExist: etcd.PrevNoExist,
-			TTL:       serviceTTL,
-			Dir:       false,
-		},
-		contOptions: etcd.SetOptions{
-			PrevValue: selfID,
-			TTL:       serviceTTL,
-			Dir:       false,
-		},
+		bus:      bus,
+		spanx:    spanx,
 	}
+
+	t.healClients = t.bastionHealClients
+	return t
 }
 
 func (t *tracker) Start() {
-	go func() {
-		leaderTicker := time.NewTicker(leaderCheckRate)
-		t.offerService()
-		if t.isServing && t.isTimeToUpdate() {
+	var ctx context.Context
+	ctx, t.stop = context.WithCancel(context.Background())
+
+	go leader.Run(ctx, t.etcd, trackerKeyPath, func(ctx context.Context, leading bool) {
+		if leading && t.isTimeToUpdate() {
 			t.updateSeen()
+			t.heal(ctx)
 			t.lastUpdateTime = time.Now()
 		}
-		for {
-			select {
-			case <-leaderTicker.C:
-				t.offerService()
-				if t.isServing && t.isTimeToUpdate() {
-					t.updateSeen()
-					t.lastUpdateTime = time.Now()
-				}
-			case <-t.quit:
-				leaderTicker.Stop()
-				return
-			}
-		}
-	}()
+	})
 }
 
 func (t *tracker) Stop() {
-	t.quit <- struct{}{}
+	t.stop()
 }
 
 func (t *tracker) isTimeToUpdate() bool {
 	return (time.Now().Sub(t.lastUpdateTime) >= minUpdateDelay)
 }
 
-/*
-attempt become leader by setting key only if empty and continue setting it with compare-and-swap.
-when not leader then peroidically attempt to become leader
-(would be more responsive to use a watcher but the delay here is acceptable)
-*/
-func (t *tracker) offerService() {
-	var setOpts etcd.SetOptions
-	if t.isServing {
-		setOpts = t.contOptions
-	} else {
-		setOpts = t.offerOptions
-	}
-
-	_, err := t.etcd.Set(context.Background(), trackerKeyPath, t.selfID, &setOpts)
-	if err == nil {
-		t.isServing = true
-	} else {
-		t.isServing = false
-
-		switch err.(type) {
-		default:
-			log.WithError(err).Error("unexpected etcd error")
-		case etcd.Error:
-			switch err.(etcd.Error).Code {
-			case etcd.ErrorCodeTestFailed:
-				return
-			case etcd.ErrorCodeNodeExist:
-				return
-			default:
-				log.WithError(err).Error("unexpected etcd error")
-			}
-		}
-	}
-}
-
 func (t *tracker) updateSeen() {
-	log.WithFields(log.Fields{"id": t.selfID}).Info("tracker thought leader")
+	log.Info("tracker thought leader")
 	/* TODO find better approach for reads
 	possibly consume heartbeats from nsq
 	this reads the entire tree including the values.  there will be badness as this scales  */
@@ -154,9 +100,9 @@ func (t *tracker) updateSeen() {
 				continue
 			}
 
-			// right herr we check if the checker service has registered
-			services := make(map[string]interface{})
-			err := json.Unmarshal([]byte(bastNode.Value), &services)
+			// right herr we check if the checker service has registered,
+			// on any of the bastion's instances
+			services, err := router.NodeServices(bastNode)
 			if err != nil {
 				log.WithError(err).Warnf("couldn't unmarshal services for bastion: %s", bastID)
 				continue